@@ -0,0 +1,128 @@
+package audit
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+// Event: One append-only row of the audit_events table
+type Event struct {
+	ActorID   string
+	Action    string
+	Target    string
+	IPAddress string
+	UserAgent string
+	Details   map[string]interface{}
+}
+
+// Record: Appends an event to the audit log
+// Failures are only reported - auditing must never break the action being audited
+func Record(e Event) {
+	details := []byte("{}")
+	if len(e.Details) > 0 {
+		if b, err := json.Marshal(e.Details); err == nil {
+			details = b
+		}
+	}
+
+	_, err := core.Db.Exec(
+		`INSERT INTO audit_events (created_at, actor_id, action, target, ip_address, user_agent, details)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		time.Now(), e.ActorID, e.Action, e.Target, e.IPAddress, e.UserAgent, string(details),
+	)
+	if err != nil {
+		slog.Warn("Failed to record audit event", "action", e.Action, "actor_id", e.ActorID, "err", err)
+	}
+}
+
+// Entry: A stored event as returned by Query
+type Entry struct {
+	EventID   int64                  `json:"event_id"`
+	CreatedAt time.Time              `json:"created_at"`
+	ActorID   string                 `json:"actor_id"`
+	Action    string                 `json:"action"`
+	Target    string                 `json:"target"`
+	IPAddress string                 `json:"ip_address"`
+	UserAgent string                 `json:"user_agent"`
+	Details   map[string]interface{} `json:"details"`
+}
+
+// Filter: Query criteria; zero values match everything
+// Before pages backwards: pass the smallest EventID of the previous page
+type Filter struct {
+	ActorID string
+	Actions []string
+	From    time.Time // inclusive
+	To      time.Time // exclusive
+	Before  int64
+	Limit   int
+}
+
+// Query: Matching events, newest first
+func Query(f Filter) ([]Entry, error) {
+	var conditions []string
+	var args []interface{}
+	if f.ActorID != "" {
+		conditions = append(conditions, "actor_id = ?")
+		args = append(args, f.ActorID)
+	}
+	if len(f.Actions) > 0 {
+		conditions = append(conditions, "action IN (?"+strings.Repeat(", ?", len(f.Actions)-1)+")")
+		for _, action := range f.Actions {
+			args = append(args, action)
+		}
+	}
+	// Stored in the server's zone (Record uses time.Now()), so compare in the same one
+	if !f.From.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.From.Local())
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, f.To.Local())
+	}
+	if f.Before > 0 {
+		conditions = append(conditions, "event_id < ?")
+		args = append(args, f.Before)
+	}
+
+	query := "SELECT event_id, created_at, actor_id, action, target, ip_address, user_agent, details FROM audit_events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY event_id DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := core.Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var details string
+		if err := rows.Scan(&e.EventID, &e.CreatedAt, &e.ActorID, &e.Action, &e.Target, &e.IPAddress, &e.UserAgent, &details); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(details), &e.Details); err != nil {
+			e.Details = map[string]interface{}{"raw": details}
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Prune: Deletes events older than the cutoff (retention); the only way rows ever leave the table
+func Prune(olderThan time.Time) (int64, error) {
+	res, err := core.Db.Exec("DELETE FROM audit_events WHERE created_at < ?", olderThan)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
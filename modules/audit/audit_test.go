@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+func openTestDB(t *testing.T) {
+	t.Helper()
+	core.InitDB(filepath.Join(t.TempDir(), "forum.db"))
+	t.Cleanup(func() { core.Db.Close() })
+}
+
+// insertAt: Stores an event with a chosen timestamp (Record always uses now)
+func insertAt(t *testing.T, at time.Time, actorID, action, details string) {
+	t.Helper()
+	_, err := core.Db.Exec(
+		`INSERT INTO audit_events (created_at, actor_id, action, target, ip_address, user_agent, details)
+		 VALUES (?, ?, ?, '', '', '', ?)`, at, actorID, action, details)
+	if err != nil {
+		t.Fatalf("insert event: %v", err)
+	}
+}
+
+func actions(entries []Entry) []string {
+	got := make([]string, len(entries))
+	for i, e := range entries {
+		got[i] = e.Action
+	}
+	return got
+}
+
+func TestQueryFilters(t *testing.T) {
+	openTestDB(t)
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	insertAt(t, base, "alice", "login_succeeded", `{}`)
+	insertAt(t, base.Add(time.Hour), "bob", "login_failed", `{}`)
+	insertAt(t, base.Add(2*time.Hour), "alice", "post_created", `{}`)
+	insertAt(t, base.Add(3*time.Hour), "alice", "login_failed", `{}`)
+	insertAt(t, base.Add(4*time.Hour), "admin", "user_suspended", `{}`)
+
+	cases := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"everything, newest first", Filter{Limit: 10},
+			[]string{"user_suspended", "login_failed", "post_created", "login_failed", "login_succeeded"}},
+		{"actor", Filter{ActorID: "alice", Limit: 10},
+			[]string{"login_failed", "post_created", "login_succeeded"}},
+		{"actions", Filter{Actions: []string{"login_failed", "user_suspended"}, Limit: 10},
+			[]string{"user_suspended", "login_failed", "login_failed"}},
+		{"actor and action", Filter{ActorID: "alice", Actions: []string{"login_failed"}, Limit: 10},
+			[]string{"login_failed"}},
+		{"from is inclusive, to exclusive", Filter{From: base.Add(time.Hour), To: base.Add(3 * time.Hour), Limit: 10},
+			[]string{"post_created", "login_failed"}},
+		{"window in another zone", Filter{From: base.Add(3 * time.Hour).UTC(), Limit: 10},
+			[]string{"user_suspended", "login_failed"}},
+		{"limit", Filter{Limit: 2},
+			[]string{"user_suspended", "login_failed"}},
+		{"no match", Filter{ActorID: "nobody", Limit: 10},
+			[]string{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := Query(tc.filter)
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if got := actions(entries); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("Query(%+v) = %v, want %v", tc.filter, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQueryPagesWithBefore(t *testing.T) {
+	openTestDB(t)
+	for i := 0; i < 5; i++ {
+		Record(Event{ActorID: "alice", Action: "post_created"})
+	}
+
+	var seen []int64
+	filter := Filter{ActorID: "alice", Limit: 2}
+	for page := 0; page < 4; page++ {
+		entries, err := Query(filter)
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, e := range entries {
+			seen = append(seen, e.EventID)
+		}
+		filter.Before = entries[len(entries)-1].EventID
+	}
+	if len(seen) != 5 {
+		t.Fatalf("paged through %d events, want 5: %v", len(seen), seen)
+	}
+	for i := 1; i < len(seen); i++ {
+		if seen[i] >= seen[i-1] {
+			t.Fatalf("pages overlap or go forwards: %v", seen)
+		}
+	}
+}
+
+func TestRecordDetails(t *testing.T) {
+	openTestDB(t)
+	Record(Event{ActorID: "alice", Action: "session_created", Target: "session:1", IPAddress: "192.0.2.1",
+		Details: map[string]interface{}{"device": "Firefox", "remember_me": true}})
+	insertAt(t, time.Now(), "bob", "legacy", "not json")
+
+	entries, err := Query(Filter{Limit: 10})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if raw := entries[0].Details["raw"]; raw != "not json" {
+		t.Fatalf("unreadable details = %v, want them kept under raw", entries[0].Details)
+	}
+	e := entries[1]
+	if e.Target != "session:1" || e.IPAddress != "192.0.2.1" || e.Details["device"] != "Firefox" || e.Details["remember_me"] != true {
+		t.Fatalf("recorded entry = %+v", e)
+	}
+}
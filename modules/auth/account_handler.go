@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"real-time-forum/modules/audit"
+	"real-time-forum/modules/core"
+)
+
+// AccountExportHandler: GET /api/account/export - downloads the user's data as a ZIP of JSON files
+func AccountExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := UserFromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="account-data-%s.zip"`, time.Now().UTC().Format("2006-01-02")))
+	w.Header().Set("Cache-Control", "no-store")
+	if err := ExportAccountData(w, user.ID); err != nil {
+		// Headers are gone once the archive started; a broken download is all we can signal
+		core.Logger(r.Context()).Error("Account data export failed", "err", err)
+		return
+	}
+	meta := SessionMetaFromRequest(r)
+	audit.Record(audit.Event{ActorID: user.ID, Action: "account_exported", Target: "user:" + user.ID,
+		IPAddress: meta.IPAddress, UserAgent: meta.UserAgent})
+}
+
+// AccountDeleteHandler: DELETE /api/account - deletes the account of the current user
+// Body {"confirmation": password, or nickname for external-login accounts}; every session
+// ends and live connections close with the revoked-session code
+func AccountDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := UserFromContext(r.Context())
+
+	var body struct {
+		Confirmation string `json:"confirmation"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	sessionIDs, err := DeleteAccount(user.ID, body.Confirmation)
+	switch {
+	case errors.Is(err, ErrConfirmationFailed):
+		WriteJSONError(w, http.StatusForbidden, "Confirmation does not match: enter your password (or nickname for single sign-on accounts)")
+		return
+	case errors.Is(err, ErrAccountNotFound), errors.Is(err, ErrDeletionNotAllowed):
+		WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		core.Logger(r.Context()).Error("Account deletion failed", "err", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Unable to delete account. Please try again")
+		return
+	}
+
+	closeSessionConns(sessionIDs...)
+	clearSessionCookies(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
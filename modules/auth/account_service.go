@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"real-time-forum/modules/audit"
+	"real-time-forum/modules/core"
+)
+
+var (
+	ErrAccountNotFound    = errors.New("account not found")
+	ErrDeletionNotAllowed = errors.New("this account cannot be deleted")
+	ErrConfirmationFailed = errors.New("confirmation does not match")
+)
+
+// AccountProfile: profile.json of a data export (no password hash or TOTP secret)
+type AccountProfile struct {
+	UserID      string            `json:"user_id"`
+	Nickname    string            `json:"nickname"`
+	Email       string            `json:"email"`
+	FirstName   string            `json:"first_name"`
+	LastName    string            `json:"last_name"`
+	Age         int               `json:"age"`
+	Gender      string            `json:"gender"`
+	Role        string            `json:"role"`
+	TOTPEnabled bool              `json:"two_factor_enabled"`
+	Identities  []AccountIdentity `json:"external_identities"`
+	ExportedAt  time.Time         `json:"exported_at"`
+}
+
+// AccountIdentity: External login linked to the account
+type AccountIdentity struct {
+	Provider string `json:"provider"`
+	Email    string `json:"email"`
+}
+
+// accountExportFiles: JSON arrays of the export besides profile.json, one query each
+// Every query takes the user ID for each "?"; sessions are listed without their tokens
+var accountExportFiles = []struct {
+	name  string
+	query string
+}{
+	{"posts.json", `
+		SELECT p.post_id, p.content, p.created_at,
+			COALESCE((SELECT GROUP_CONCAT(c.category_name, ', ') FROM posts_categories pc
+				JOIN categories c ON c.category_id = pc.category_id WHERE pc.post_id = p.post_id), '') AS categories
+		FROM posts p WHERE p.user_id = ? ORDER BY p.created_at`},
+	{"comments.json", `
+		SELECT comment_id, post_id, content, created_at
+		FROM comments WHERE user_id = ? ORDER BY created_at`},
+	{"reactions.json", `
+		SELECT 'post' AS target_type, post_id AS target_id, reaction FROM posts_reactions WHERE user_id = ?
+		UNION ALL
+		SELECT 'comment', comment_id, reaction FROM comments_reactions WHERE user_id = ?
+		UNION ALL
+		SELECT 'private_message', message_id, emoji FROM message_reactions WHERE user_id = ?`},
+	{"sessions.json", `
+		SELECT device_label, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM sessions WHERE user_id = ? ORDER BY created_at`},
+	{"private_messages.json", `
+		SELECT m.message_id, s.nickname AS sender, r.nickname AS recipient, m.content,
+			m.created_at, m.edited_at, m.deleted_at, m.reply_to_message_id
+		FROM private_messages m
+		JOIN users s ON s.user_id = m.sender_id
+		JOIN users r ON r.user_id = m.recipient_id
+		WHERE m.sender_id = ? OR m.recipient_id = ?
+		ORDER BY m.created_at`},
+}
+
+// ExportAccountData: Writes a ZIP of the user's personal data, one JSON file per kind
+// Rows are streamed into the archive, so large histories are never held in memory
+func ExportAccountData(w io.Writer, userID string) error {
+	profile, err := loadAccountProfile(userID)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	f, err := zw.Create("profile.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(profile); err != nil {
+		return err
+	}
+
+	for _, file := range accountExportFiles {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		args := make([]interface{}, countPlaceholders(file.query))
+		for i := range args {
+			args[i] = userID
+		}
+		if err := streamRowsJSON(f, file.query, args...); err != nil {
+			return fmt.Errorf("%s: %w", file.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+func loadAccountProfile(userID string) (*AccountProfile, error) {
+	var p AccountProfile
+	var gender sql.NullString
+	err := core.Db.QueryRow(`
+		SELECT user_id, nickname, email, first_name, last_name, age, gender, role, totp_enabled
+		FROM users WHERE user_id = ?`, userID).Scan(&p.UserID, &p.Nickname, &p.Email, &p.FirstName, &p.LastName,
+		&p.Age, &gender, &p.Role, &p.TOTPEnabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Gender = gender.String
+	p.ExportedAt = time.Now().UTC()
+
+	rows, err := core.Db.Query("SELECT provider, email FROM user_identities WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	p.Identities = []AccountIdentity{}
+	for rows.Next() {
+		var id AccountIdentity
+		if err := rows.Scan(&id.Provider, &id.Email); err != nil {
+			return nil, err
+		}
+		p.Identities = append(p.Identities, id)
+	}
+	return &p, rows.Err()
+}
+
+// streamRowsJSON: Writes the query result as a JSON array of objects keyed by column name
+func streamRowsJSON(w io.Writer, query string, args ...interface{}) error {
+	rows, err := core.Db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for n := 0; rows.Next(); n++ {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		record := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			record[col] = values[i]
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		sep := ",\n  "
+		if n == 0 {
+			sep = "\n  "
+		}
+		if _, err := io.WriteString(w, sep+string(line)); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n]\n")
+	return err
+}
+
+func countPlaceholders(query string) int {
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+		}
+	}
+	return n
+}
+
+// DeleteAccount: Removes the account after checking the confirmation, in one transaction
+// Posts, comments and private messages stay (other users' threads and conversations keep
+// their shape) but are re-assigned to the shared "deleted user" placeholder; reactions,
+// sessions and everything else tied to the account are deleted. Returns the revoked
+// session IDs so their live connections can be closed
+// confirmation is the password, or the nickname for accounts without one (external login)
+func DeleteAccount(userID, confirmation string) ([]string, error) {
+	if userID == core.DeletedUserID {
+		return nil, ErrDeletionNotAllowed
+	}
+	var nickname, passwordHash string
+	err := core.Db.QueryRow("SELECT nickname, password FROM users WHERE user_id = ?", userID).Scan(&nickname, &passwordHash)
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if passwordHash != "" && !CheckPasswordHash(confirmation, passwordHash) {
+		return nil, ErrConfirmationFailed
+	}
+	if passwordHash == "" && confirmation != nickname {
+		return nil, ErrConfirmationFailed
+	}
+
+	tx, err := core.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT session_id FROM sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	var sessionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+	rows.Close()
+
+	// Children first, the users row last, so foreign keys hold at every step
+	steps := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE posts SET user_id = ? WHERE user_id = ?", []interface{}{core.DeletedUserID, userID}},
+		{"UPDATE comments SET user_id = ? WHERE user_id = ?", []interface{}{core.DeletedUserID, userID}},
+		{"UPDATE private_messages SET sender_id = ? WHERE sender_id = ?", []interface{}{core.DeletedUserID, userID}},
+		{"UPDATE private_messages SET recipient_id = ? WHERE recipient_id = ?", []interface{}{core.DeletedUserID, userID}},
+		{"DELETE FROM posts_reactions WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM comments_reactions WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM message_reactions WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM sessions WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM pending_logins WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM recovery_codes WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM user_identities WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM user_events WHERE user_id = ?", []interface{}{userID}},
+		// The other side's outbox keeps the conversation for replay: sign it like the rows above
+		{`UPDATE user_events SET payload = json_set(payload, '$.sender_id', ?, '$.sender_nickname', ?)
+			WHERE type IN ('private_message', 'message_edited') AND json_extract(payload, '$.sender_id') = ?`,
+			[]interface{}{core.DeletedUserID, core.DeletedUserNickname, userID}},
+		{`UPDATE user_events SET payload = json_set(payload, '$.reply_to.sender_id', ?, '$.reply_to.sender_nickname', ?)
+			WHERE type IN ('private_message', 'message_edited') AND json_extract(payload, '$.reply_to.sender_id') = ?`,
+			[]interface{}{core.DeletedUserID, core.DeletedUserNickname, userID}},
+		{`UPDATE user_events SET payload = json_set(payload, '$.sender_id', ?)
+			WHERE type = 'message_reactions' AND json_extract(payload, '$.sender_id') = ?`,
+			[]interface{}{core.DeletedUserID, userID}},
+		{`UPDATE user_events SET payload = json_set(payload, '$.recipient_id', ?)
+			WHERE type IN ('private_message', 'message_edited', 'message_reactions') AND json_extract(payload, '$.recipient_id') = ?`,
+			[]interface{}{core.DeletedUserID, userID}},
+		// What is left are the reactions' user_ids lists; a quoted UUID only matches whole values
+		{`UPDATE user_events SET payload = replace(payload, '"' || ? || '"', '"' || ? || '"')
+			WHERE type IN ('private_message', 'message_edited', 'message_reactions') AND instr(payload, '"' || ? || '"') > 0`,
+			[]interface{}{userID, core.DeletedUserID, userID}},
+		{"DELETE FROM delivery_cursors WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM user_suspensions WHERE user_id = ?", []interface{}{userID}},
+		// The lockout counter is keyed by user ID, not a foreign key (see accountAttemptKey)
+		{"DELETE FROM login_attempts WHERE attempt_key = 'user:' || ?", []interface{}{userID}},
+		{"DELETE FROM users WHERE user_id = ?", []interface{}{userID}},
+	}
+	for _, step := range steps {
+		if _, err := tx.Exec(step.query, step.args...); err != nil {
+			return nil, fmt.Errorf("delete account: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	forgetSessions(sessionIDs...)
+	// The actor no longer exists; the nickname is kept so the entry stays readable
+	audit.Record(audit.Event{ActorID: userID, Action: "account_deleted", Target: "user:" + userID,
+		Details: map[string]interface{}{"nickname": nickname}})
+	return sessionIDs, nil
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+// countRows: Rows of table matching the condition
+func countRows(t *testing.T, table, where string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := core.Db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+where, args...).Scan(&n); err != nil {
+		t.Fatalf("count %s: %v", table, err)
+	}
+	return n
+}
+
+func TestDeleteAccount(t *testing.T) {
+	openTestDB(t)
+	alice, bob := insertTestUser(t, "alice"), insertTestUser(t, "bob")
+	setTestPassword(t, alice, "secret1")
+
+	now := time.Now()
+	setup := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO posts (post_id, content, user_id) VALUES ('p1', 'hello', ?)", []interface{}{alice}},
+		{"INSERT INTO comments (comment_id, content, user_id, post_id) VALUES ('c1', 'reply', ?, 'p1')", []interface{}{bob}},
+		{"INSERT INTO comments (comment_id, content, user_id, post_id) VALUES ('c2', 'thanks', ?, 'p1')", []interface{}{alice}},
+		{"INSERT INTO private_messages (message_id, sender_id, recipient_id, content, created_at) VALUES ('m1', ?, ?, 'hi', '1')", []interface{}{alice, bob}},
+		{"INSERT INTO private_messages (message_id, sender_id, recipient_id, content, created_at) VALUES ('m2', ?, ?, 'hey', '2')", []interface{}{bob, alice}},
+		{"INSERT INTO posts_reactions (post_id, user_id, reaction) VALUES ('p1', ?, 'like'), ('p1', ?, 'like')", []interface{}{alice, bob}},
+		{"INSERT INTO comments_reactions (comment_id, user_id, reaction) VALUES ('c1', ?, 'like')", []interface{}{alice}},
+		{"INSERT INTO sessions (session_id, user_id, expires_at) VALUES ('s1', ?, ?), ('s2', ?, ?), ('s3', ?, ?)", []interface{}{alice, now.Add(time.Hour), alice, now.Add(time.Hour), bob, now.Add(time.Hour)}},
+		{"INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, 'hash')", []interface{}{alice}},
+		{"INSERT INTO user_identities (provider, subject, user_id, email, created_at) VALUES ('sso', 'sub', ?, 'alice@example.com', ?)", []interface{}{alice, now}},
+		{"INSERT INTO user_events (user_id, type, payload, created_at) VALUES (?, 'private_message', '{}', ?)", []interface{}{alice, now}},
+		// Bob's outbox: alice's message, his reply quoting it, and reactions from both
+		{"INSERT INTO user_events (user_id, type, payload, created_at) VALUES (?, 'private_message', json_object('message_id', 'm1', 'sender_id', ?, 'sender_nickname', 'alice', 'recipient_id', ?, 'content', 'hi'), ?)", []interface{}{bob, alice, bob, now}},
+		{"INSERT INTO user_events (user_id, type, payload, created_at) VALUES (?, 'message_edited', json_object('message_id', 'm2', 'sender_id', ?, 'sender_nickname', 'bob', 'recipient_id', ?, 'content', 'hey', 'reply_to', json_object('message_id', 'm1', 'sender_id', ?, 'sender_nickname', 'alice', 'content', 'hi')), ?)", []interface{}{bob, bob, alice, alice, now}},
+		{"INSERT INTO user_events (user_id, type, payload, created_at) VALUES (?, 'message_reactions', json_object('message_id', 'm1', 'sender_id', ?, 'recipient_id', ?, 'reactions', json_array(json_object('emoji', '👍', 'count', 2, 'user_ids', json_array(?, ?)))), ?)", []interface{}{bob, alice, bob, alice, bob, now}},
+		{"INSERT INTO login_attempts (attempt_key, failures, last_failure_at) VALUES (?, 3, ?), (?, 2, ?)", []interface{}{"user:" + alice, now, "user:" + bob, now}},
+	}
+	for _, s := range setup {
+		if _, err := core.Db.Exec(s.query, s.args...); err != nil {
+			t.Fatalf("setup %q: %v", s.query, err)
+		}
+	}
+
+	if _, err := DeleteAccount(alice, "wrong"); !errors.Is(err, ErrConfirmationFailed) {
+		t.Fatalf("wrong password: err = %v, want ErrConfirmationFailed", err)
+	}
+	if countRows(t, "users", "user_id = ?", alice) != 1 {
+		t.Fatal("account deleted without a valid confirmation")
+	}
+
+	revoked, err := DeleteAccount(alice, "secret1")
+	if err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	if len(revoked) != 2 {
+		t.Fatalf("revoked sessions = %v, want s1 and s2", revoked)
+	}
+
+	// Content stays, signed by the placeholder
+	for _, check := range []struct {
+		table, where string
+		want         int
+	}{
+		{"posts", "post_id = 'p1' AND user_id = '" + core.DeletedUserID + "'", 1},
+		{"comments", "comment_id = 'c2' AND user_id = '" + core.DeletedUserID + "'", 1},
+		{"comments", "comment_id = 'c1' AND user_id = '" + bob + "'", 1},
+		{"private_messages", "message_id = 'm1' AND sender_id = '" + core.DeletedUserID + "'", 1},
+		{"private_messages", "message_id = 'm2' AND recipient_id = '" + core.DeletedUserID + "'", 1},
+		{"posts_reactions", "user_id = '" + bob + "'", 1},
+		{"sessions", "session_id = 's3'", 1},
+		{"login_attempts", "attempt_key = 'user:" + bob + "'", 1},
+	} {
+		if got := countRows(t, check.table, check.where); got != check.want {
+			t.Fatalf("%s WHERE %s: %d rows, want %d", check.table, check.where, got, check.want)
+		}
+	}
+
+	// Everything tied to the account itself is gone
+	for _, table := range []string{"users", "posts_reactions", "comments_reactions", "sessions", "recovery_codes", "user_identities", "user_events"} {
+		if got := countRows(t, table, "user_id = ?", alice); got != 0 {
+			t.Fatalf("%s still has %d rows of the deleted account", table, got)
+		}
+	}
+	// Bob's replay copies keep the conversation but no longer name alice
+	if got := countRows(t, "user_events", "user_id = ?", bob); got != 3 {
+		t.Fatalf("bob's outbox has %d events, want 3", got)
+	}
+	if got := countRows(t, "user_events", "instr(payload, ?) > 0 OR instr(payload, '\"alice\"') > 0", alice); got != 0 {
+		t.Fatalf("%d stored events still identify the deleted account", got)
+	}
+	if got := countRows(t, "user_events", "json_extract(payload, '$.sender_id') = ? OR json_extract(payload, '$.recipient_id') = ?",
+		core.DeletedUserID, core.DeletedUserID); got != 3 {
+		t.Fatalf("%d stored events point at the placeholder, want 3", got)
+	}
+	if got := countRows(t, "login_attempts", "attempt_key = ?", "user:"+alice); got != 0 {
+		t.Fatal("login failure counter of the deleted account was kept")
+	}
+
+	if _, err := DeleteAccount(alice, "secret1"); !errors.Is(err, ErrAccountNotFound) {
+		t.Fatalf("second deletion: err = %v, want ErrAccountNotFound", err)
+	}
+}
+
+func TestDeleteAccountConfirmation(t *testing.T) {
+	openTestDB(t)
+
+	if _, err := DeleteAccount(core.DeletedUserID, ""); !errors.Is(err, ErrDeletionNotAllowed) {
+		t.Fatalf("placeholder: err = %v, want ErrDeletionNotAllowed", err)
+	}
+
+	// Accounts without a password (external login) confirm with their nickname
+	external := insertTestUser(t, "carol")
+	if _, err := DeleteAccount(external, ""); !errors.Is(err, ErrConfirmationFailed) {
+		t.Fatalf("empty confirmation: err = %v, want ErrConfirmationFailed", err)
+	}
+	if _, err := DeleteAccount(external, "carol"); err != nil {
+		t.Fatalf("nickname confirmation: %v", err)
+	}
+}
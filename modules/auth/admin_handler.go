@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/modules/audit"
+	"real-time-forum/modules/core"
+)
+
+// AdminStatsHandler: GET /api/admin/stats?limit=10 - totals, live connections, top categories and users
+func AdminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, ok := intParam(w, r, "limit", DefaultStatsLimit, MaxStatsLimit)
+	if !ok {
+		return
+	}
+	stats, err := GetForumStats(limit)
+	if err != nil {
+		core.Logger(r.Context()).Error("Admin stats failed", "err", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Unable to compute statistics")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// AdminStatsSeriesHandler: GET /api/admin/stats/timeseries?days=30 - daily counts, oldest first
+func AdminStatsSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	days, ok := intParam(w, r, "days", DefaultStatsDays, MaxStatsDays)
+	if !ok {
+		return
+	}
+	series, err := GetStatsSeries(days)
+	if err != nil {
+		core.Logger(r.Context()).Error("Admin stats series failed", "err", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Unable to compute statistics")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"days": days, "series": series})
+}
+
+// suspensionRequest: Body of the suspend and ban endpoints (bans ignore ExpiresAt)
+type suspensionRequest struct {
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"` // RFC 3339
+}
+
+// AdminSuspendHandler: POST /api/admin/users/{user_id}/suspend - {"reason", "expires_at"}
+func AdminSuspendHandler(w http.ResponseWriter, r *http.Request) {
+	restrictUser(w, r, false)
+}
+
+// AdminBanHandler: POST /api/admin/users/{user_id}/ban - {"reason"}, no expiry
+func AdminBanHandler(w http.ResponseWriter, r *http.Request) {
+	restrictUser(w, r, true)
+}
+
+// restrictUser: Stores the suspension, then closes the user's live connections with
+// CloseSuspended so every open tab shows why
+func restrictUser(w http.ResponseWriter, r *http.Request, permanent bool) {
+	admin, _ := UserFromContext(r.Context())
+
+	var body suspensionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if permanent {
+		body.ExpiresAt = nil
+	} else if body.ExpiresAt == nil {
+		WriteJSONError(w, http.StatusBadRequest, "expires_at is required; use the ban endpoint for a permanent ban")
+		return
+	}
+
+	suspension, sessionIDs, err := SuspendUser(admin, r.PathValue("user_id"), body.Reason, body.ExpiresAt)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, ErrSuspensionReason), errors.Is(err, ErrSuspensionExpiry), errors.Is(err, ErrCannotSuspendSelf):
+		WriteJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		core.Logger(r.Context()).Error("Suspending user failed", "target_user_id", r.PathValue("user_id"), "err", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Unable to suspend user")
+		return
+	}
+
+	closeSuspendedConns(suspension, sessionIDs...)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "suspension": suspension, "revoked_sessions": len(sessionIDs)})
+}
+
+// AdminLiftSuspensionHandler: DELETE /api/admin/users/{user_id}/suspension - ends a suspension or ban
+func AdminLiftSuspensionHandler(w http.ResponseWriter, r *http.Request) {
+	admin, _ := UserFromContext(r.Context())
+	err := LiftSuspension(admin, r.PathValue("user_id"))
+	if errors.Is(err, ErrNoActiveSuspension) {
+		WriteJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		core.Logger(r.Context()).Error("Lifting suspension failed", "target_user_id", r.PathValue("user_id"), "err", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Unable to lift suspension")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// AdminSuspensionsHandler: GET /api/admin/suspensions - active suspensions and bans
+func AdminSuspensionsHandler(w http.ResponseWriter, r *http.Request) {
+	suspensions, err := ListSuspensions()
+	if err != nil {
+		core.Logger(r.Context()).Error("Listing suspensions failed", "err", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Unable to list suspensions")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suspensions)
+}
+
+// Page sizes of the audit log query
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 500
+)
+
+// AdminAuditHandler: GET /api/admin/audit - audit events, newest first
+// Filters: actor (user ID), action (comma-separated), from / to (RFC 3339, to exclusive);
+// page with before = the smallest event_id already seen
+func AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{ActorID: query.Get("actor"), Actions: splitParam(query.Get("action"))}
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		raw := query.Get(bound.name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, bound.name+" must be an RFC 3339 timestamp")
+			return
+		}
+		*bound.dst = t
+	}
+	if raw := query.Get("before"); raw != "" {
+		before, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || before < 1 {
+			WriteJSONError(w, http.StatusBadRequest, "before must be an event_id")
+			return
+		}
+		filter.Before = before
+	}
+	limit, ok := intParam(w, r, "limit", DefaultAuditLimit, MaxAuditLimit)
+	if !ok {
+		return
+	}
+	filter.Limit = limit
+
+	events, err := audit.Query(filter)
+	if err != nil {
+		core.Logger(r.Context()).Error("Audit query failed", "err", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Unable to read the audit log")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"events": events, "more": len(events) == limit})
+}
+
+func splitParam(value string) []string {
+	var out []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// intParam: Optional positive query parameter up to max; writes a 400 and returns false when invalid
+func intParam(w http.ResponseWriter, r *http.Request, name string, fallback, max int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return fallback, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > max {
+		WriteJSONError(w, http.StatusBadRequest, fmt.Sprintf("%s must be a number between 1 and %d", name, max))
+		return 0, false
+	}
+	return n, true
+}
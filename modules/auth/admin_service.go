@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"strings"
+
+	"real-time-forum/modules/core"
+)
+
+// PromoteAdmins: Gives the admin role to the listed nicknames or emails (ADMIN_USERS)
+// Runs at startup; accounts registered later are promoted on the next start.
+// Returns how many accounts were changed
+func PromoteAdmins(names []string) (int64, error) {
+	if len(names) == 0 {
+		return 0, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	args := make([]interface{}, 0, 2*len(names)+1)
+	args = append(args, RoleAdmin)
+	for _, name := range names {
+		args = append(args, name)
+	}
+	for _, name := range names {
+		args = append(args, name)
+	}
+	res, err := core.Db.Exec(`UPDATE users SET role = ?
+		WHERE (nickname IN (`+placeholders+`) OR email IN (`+placeholders+`)) AND role != ?`, append(args, RoleAdmin)...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
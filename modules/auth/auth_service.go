@@ -9,7 +9,9 @@ import (
 	"time"
 	"unicode"
 
+	"real-time-forum/modules/audit"
 	"real-time-forum/modules/core"
+	"real-time-forum/modules/metrics"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -21,7 +23,7 @@ type UserStatus struct {
 	Online   bool   `json:"is_online"`
 }
 
-func RegisterUser(data UserPayload) error {
+func RegisterUser(data UserPayload, meta SessionMeta) error {
 	data.User.FirstName = strings.TrimSpace(data.User.FirstName)
 	data.User.LastName = strings.TrimSpace(data.User.LastName)
 	data.User.Nickname = strings.TrimSpace(data.User.Nickname)
@@ -63,11 +65,19 @@ func RegisterUser(data UserPayload) error {
 	userID := uuid.New().String()
 	data.User.UserID = userID
 
-	_, err = core.Db.Exec(`INSERT INTO users (user_id, first_name, last_name, nickname, age, gender, email, password) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+	_, err = core.Db.Exec(`INSERT INTO users (user_id, first_name, last_name, nickname, age, gender, email, password, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
 		userID, data.User.FirstName, data.User.LastName, data.User.Nickname, data.User.Age, data.User.Gender, data.User.Email, hashedPwd)
 	if err != nil {
 		return err
 	}
+	audit.Record(audit.Event{
+		ActorID:   userID,
+		Action:    "user_registered",
+		Target:    "user:" + userID,
+		IPAddress: meta.IPAddress,
+		UserAgent: meta.UserAgent,
+		Details:   map[string]interface{}{"nickname": data.User.Nickname},
+	})
 	return nil
 }
 
@@ -190,12 +200,29 @@ func HashPassword(password string) (string, error) {
 	return string(hashedBytes), nil
 }
 
-func LoginUser(emailOrNickname, password string) (UserPayload, error) {
+// dummyPasswordHash: Compared against when there is no real hash; same cost as HashPassword
+const dummyPasswordHash = "$2a$14$wo.TeQW8tFNOToHz65HbiOdNnSy86.yvyEvDOpGnrgT.W6tPa9AbC"
+
+// LoginUser: Checks credentials behind the brute-force guard - locked accounts/IPs are
+// rejected before the (expensive) bcrypt comparison
+func LoginUser(emailOrNickname, password string, meta SessionMeta) (UserPayload, error) {
 	var user UserPayload
 	var hashedPwd string
 
+	accountKey := accountAttemptKey(emailOrNickname)
+	ipKey := ipAttemptKey(meta.IPAddress)
+	if retryAfter, locked := loginLockedFor(accountKey, ipKey); locked {
+		metrics.Logins.Inc("failure", "locked")
+		return UserPayload{}, &LoginLockedError{RetryAfter: retryAfter}
+	}
+	loginFailed := func() {
+		recordLoginFailure(accountKey, core.AppConfig.LoginMaxAccountFailures, meta)
+		recordLoginFailure(ipKey, core.AppConfig.LoginMaxIPFailures, meta)
+		recordLoginEvent("login_failed", user.User.UserID, emailOrNickname, meta, "invalid_credentials")
+	}
+
 	err := core.Db.QueryRow(
-		`SELECT user_id, first_name, last_name, nickname, age, gender, email, password 
+		`SELECT user_id, first_name, last_name, nickname, age, gender, email, password, role 
 		 FROM users 
 		 WHERE email = ? OR nickname = ?`,
 		emailOrNickname, emailOrNickname,
@@ -208,38 +235,100 @@ func LoginUser(emailOrNickname, password string) (UserPayload, error) {
 		&user.User.Gender,
 		&user.User.Email,
 		&hashedPwd,
+		&user.User.Role,
 	)
-	if err != nil {
+	if err != nil || hashedPwd == "" {
+		// Unknown accounts and accounts without a password (single sign-on, the deleted-user
+		// placeholder) pay the same bcrypt cost, so timing does not reveal which exist
+		CheckPasswordHash(password, dummyPasswordHash)
+		loginFailed()
 		// no need to specifie the error (senstitive data)
 		return UserPayload{}, fmt.Errorf("invalid email/nickname or password")
 	}
 
 	if !CheckPasswordHash(password, hashedPwd) {
+		loginFailed()
 		// no need to specifie the error (senstitive data)
 		return UserPayload{}, fmt.Errorf("invalid email/nickname or password")
 	}
 
+	// Checked after the password so a suspension reveals nothing to someone guessing it
+	if err := checkNotSuspended(user.User.UserID); err != nil {
+		recordLoginEvent("login_failed", user.User.UserID, emailOrNickname, meta, "suspended")
+		return UserPayload{}, err
+	}
+	// With a second factor the login is not over yet: CompleteTwoFactorLogin resets the
+	// counter, otherwise each new password step would wipe out its failed codes
+	enabled, err := userHasTOTP(user.User.UserID)
+	if err != nil {
+		return UserPayload{}, err
+	}
+	if !enabled {
+		clearLoginFailures(accountKey)
+	}
+	recordLoginEvent("login_succeeded", user.User.UserID, emailOrNickname, meta, "")
 	return user, nil
 }
 
-func CreateSession(userID string) (string, error) {
+// recordLoginEvent: Audits and counts a password login attempt; unknown accounts are targeted
+// by the identifier typed
+func recordLoginEvent(action, userID, emailOrNickname string, meta SessionMeta, reason string) {
+	outcome := "failure"
+	if action == "login_succeeded" {
+		outcome = "success"
+	}
+	metrics.Logins.Inc(outcome, reason)
+
+	event := audit.Event{
+		ActorID:   userID,
+		Action:    action,
+		Target:    "login:" + emailOrNickname,
+		IPAddress: meta.IPAddress,
+		UserAgent: meta.UserAgent,
+	}
+	if userID != "" {
+		event.Target = "user:" + userID
+	}
+	if reason != "" {
+		event.Details = map[string]interface{}{"reason": reason}
+	}
+	audit.Record(event)
+}
+
+// CreateSession: Issues a new session for the user without touching their other devices
+// The session starts with the idle timeout and can never outlive its absolute lifetime
+// Suspended accounts get none, whichever login path (password, 2FA, SSO) led here
+func CreateSession(userID string, meta SessionMeta) (string, error) {
+	if err := checkNotSuspended(userID); err != nil {
+		return "", err
+	}
 	sessionID := uuid.New().String()
-	expiresAt := time.Now().Add(24 * time.Hour)
+	now := time.Now()
+	idle, maxLifetime := sessionLimits(meta.RememberMe)
+	expiresAt := now.Add(idle)
+	absoluteExpiresAt := now.Add(maxLifetime)
 
-	// Delete any existing sessions for this user
-	_, err := core.Db.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
-	if err != nil {
-		return "", fmt.Errorf("failed to clear existing sessions: %v", err)
+	if meta.DeviceLabel == "" {
+		meta.DeviceLabel = deviceLabelFromUserAgent(meta.UserAgent)
 	}
 
 	// Insert new session
-	_, err = core.Db.Exec(
-		"INSERT INTO sessions (session_id, user_id, expires_at) VALUES (?, ?, ?)",
-		sessionID, userID, expiresAt,
+	_, err := core.Db.Exec(
+		`INSERT INTO sessions (session_id, user_id, expires_at, absolute_expires_at, remember_me, device_label, user_agent, ip_address, last_used_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sessionID, userID, expiresAt, absoluteExpiresAt, meta.RememberMe, meta.DeviceLabel, meta.UserAgent, meta.IPAddress, now,
 	)
 	if err != nil {
 		return "", err
 	}
+	audit.Record(audit.Event{
+		ActorID:   userID,
+		Action:    "session_created",
+		Target:    "session:" + publicSessionID(sessionID),
+		IPAddress: meta.IPAddress,
+		UserAgent: meta.UserAgent,
+		Details:   map[string]interface{}{"device": meta.DeviceLabel, "remember_me": meta.RememberMe},
+	})
 
 	return sessionID, nil
 }
@@ -250,12 +339,20 @@ func CheckPasswordHash(password, hash string) bool {
 }
 
 func GetUserFromSessionID(sessionID string) (UserPayload, error) {
+	user, _, err := ResolveSession(sessionID)
+	return user, err
+}
+
+// ResolveSession: Loads the session's user, slides its expiry forward (throttled)
+// and returns the resulting expiry time
+func ResolveSession(sessionID string) (UserPayload, time.Time, error) {
 	var user UserPayload
-	var expiresAt time.Time
+	var times sessionTimes
 
 	query := `
         SELECT u.user_id, u.nickname, u.first_name, u.last_name, 
-               u.email, u.age, u.gender, s.expires_at
+               u.email, u.age, u.gender, u.role, s.expires_at, s.absolute_expires_at,
+               s.last_used_at, s.created_at, s.remember_me
         FROM sessions s
         JOIN users u ON u.user_id = s.user_id
         WHERE s.session_id = ?
@@ -264,22 +361,25 @@ func GetUserFromSessionID(sessionID string) (UserPayload, error) {
 	err := core.Db.QueryRow(query, sessionID).
 		Scan(&user.User.UserID, &user.User.Nickname, &user.User.FirstName,
 			&user.User.LastName, &user.User.Email, &user.User.Age,
-			&user.User.Gender, &expiresAt)
+			&user.User.Gender, &user.User.Role, &times.expiresAt, &times.absoluteExpiresAt,
+			&times.lastUsedAt, &times.createdAt, &times.rememberMe)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return UserPayload{}, errors.New("session not found")
+			return UserPayload{}, time.Time{}, ErrSessionNotFound
 		}
-		return UserPayload{}, err
+		return UserPayload{}, time.Time{}, err
 	}
 
-	if time.Now().After(expiresAt) {
-		_, deleteErr := core.Db.Exec("DELETE FROM sessions WHERE session_id = ?", sessionID)
-		if deleteErr != nil {
-			fmt.Printf("Warning: Failed to delete expired session %s: %v\n", sessionID, deleteErr)
-		}
-		return UserPayload{}, errors.New("session expired")
+	if time.Now().After(times.expiresAt) {
+		deleteExpiredSession(sessionID)
+		return UserPayload{}, time.Time{}, ErrSessionExpired
+	}
+	// Suspending revokes the sessions already; this covers a session resolved mid-suspension
+	if err := checkNotSuspended(user.User.UserID); err != nil {
+		return UserPayload{}, time.Time{}, err
 	}
 
+	expiresAt := slideSession(sessionID, times, false)
 	user.User.SessionID = sessionID
-	return user, nil
+	return user, expiresAt, nil
 }
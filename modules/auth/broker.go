@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// Envelope kinds exchanged between nodes
+const (
+	envelopeUser          = "user"           // WSResponse for every connection of UserID
+	envelopeBroadcast     = "broadcast"      // WSResponse for every authenticated connection
+	envelopePresence      = "presence"       // UserID got their first / lost their last connection on Node
+	envelopePresenceSync  = "presence_sync"  // full list of users online on Node
+	envelopeCloseSessions = "close_sessions" // revoked sessions whose connections must close
+)
+
+// Envelope: One event on the backplane
+// Node identifies the publisher so a hub can skip its own events (it already delivered them locally)
+type Envelope struct {
+	Node       string          `json:"node"`
+	Kind       string          `json:"kind"`
+	UserID     string          `json:"user_id,omitempty"`
+	Online     bool            `json:"online,omitempty"`
+	Users      []string        `json:"users,omitempty"`
+	SessionIDs []string        `json:"session_ids,omitempty"`
+	CloseCode  int             `json:"close_code,omitempty"` // close_sessions; 0 means CloseSessionRevoked
+	CloseText  string          `json:"close_text,omitempty"`
+	Message    json.RawMessage `json:"message,omitempty"` // encoded WSResponse
+}
+
+// Broker: Pub/sub backplane connecting the hubs of several server instances
+// Publish must not block for long; handlers registered with Subscribe may be called
+// from any goroutine
+type Broker interface {
+	Publish(env Envelope) error
+	Subscribe(handler func(Envelope)) error
+	Close() error
+}
+
+// NewBroker: Picks the implementation from the configured URL
+// "" keeps everything in-process (single instance); redis:// and rediss:// use Redis pub/sub
+func NewBroker(rawURL, channel string) (Broker, error) {
+	if rawURL == "" {
+		return NewLocalBroker(), nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("broker url: %w", err)
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		return NewRedisBroker(u, channel), nil
+	default:
+		return nil, fmt.Errorf("broker url: unsupported scheme %q", u.Scheme)
+	}
+}
+
+// LocalBroker: In-process broker - fans events out to every hub of this process
+// A single-instance server uses it as a no-op; several hubs in one process (tests,
+// embedded setups) can share one to behave like a cluster
+type LocalBroker struct {
+	mu       sync.RWMutex
+	handlers []func(Envelope)
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{}
+}
+
+func (b *LocalBroker) Publish(env Envelope) error {
+	b.mu.RLock()
+	handlers := append([]func(Envelope){}, b.handlers...)
+	b.mu.RUnlock()
+	for _, handle := range handlers {
+		handle(env)
+	}
+	return nil
+}
+
+func (b *LocalBroker) Subscribe(handler func(Envelope)) error {
+	b.mu.Lock()
+	b.handlers = append(b.handlers, handler)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *LocalBroker) Close() error {
+	b.mu.Lock()
+	b.handlers = nil
+	b.mu.Unlock()
+	return nil
+}
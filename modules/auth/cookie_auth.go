@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"real-time-forum/modules/audit"
+	"real-time-forum/modules/core"
+)
+
+// Cookie names and CSRF header used by the REST API and the WebSocket upgrade
+const (
+	SessionCookieName = "session_id"
+	CSRFCookieName    = "csrf_token"
+	CSRFHeaderName    = "X-CSRF-Token"
+)
+
+// SessionIDFromRequest: Reads the session token from the Session-ID header (legacy clients)
+// or from the HttpOnly session cookie; fromCookie tells the caller whether CSRF checks apply
+func SessionIDFromRequest(r *http.Request) (sessionID string, fromCookie bool) {
+	if sessionID = r.Header.Get("Session-ID"); sessionID != "" {
+		return sessionID, false
+	}
+	if cookie, err := r.Cookie(SessionCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value, true
+	}
+	return "", false
+}
+
+// ValidCSRF: Double-submit check for cookie-authenticated requests
+// Safe methods pass; others need a matching X-CSRF-Token header and a same-origin Origin
+func ValidCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if !sameOrigin(r) {
+		return false
+	}
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(CSRFHeaderName)
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
+
+// sameOrigin: True when the Origin header is absent (non-browser client) or matches the host
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
+
+// setSessionCookies: Sets the HttpOnly session cookie and the JS-readable CSRF cookie
+// "Remember me" sessions get persistent cookies, others last until the browser closes
+func setSessionCookies(w http.ResponseWriter, sessionID string, rememberMe bool) {
+	var expires time.Time
+	if rememberMe {
+		expires = time.Now().Add(core.AppConfig.RememberMeMaxLifetime)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    sessionID,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   core.AppConfig.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    newCSRFToken(),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: false, // the SPA echoes it back in X-CSRF-Token
+		Secure:   core.AppConfig.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearSessionCookies: Expires both auth cookies in the browser
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{SessionCookieName, CSRFCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name == SessionCookieName,
+			Secure:   core.AppConfig.CookieSecure,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// newCSRFToken: Random 256-bit token, hex encoded
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LoginHandler: REST login - authenticates like the WS "login" message, but hands the
+// session to the browser as an HttpOnly cookie instead of returning the token
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, `{"error": "Invalid origin"}`, http.StatusForbidden)
+		return
+	}
+
+	var loginData UserPayload
+	if err := json.NewDecoder(r.Body).Decode(&loginData); err != nil {
+		http.Error(w, `{"error": "Invalid login data format"}`, http.StatusBadRequest)
+		return
+	}
+
+	meta := SessionMetaFromRequest(r)
+	meta.RememberMe = loginData.User.RememberMe
+	user, pendingToken, err := BeginLogin(loginData.User.EmailOrNickname, loginData.User.Password, meta)
+	if err != nil {
+		writeLoginError(w, err)
+		return
+	}
+	if pendingToken != "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":        "2fa_required",
+			"pending_token": pendingToken,
+			"expires_in":    int(core.AppConfig.PendingLoginTTL.Seconds()),
+		})
+		return
+	}
+
+	issueSessionCookies(w, user, meta)
+}
+
+// Login2FAHandler: Second REST login step for accounts with two-factor authentication
+func Login2FAHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, `{"error": "Invalid origin"}`, http.StatusForbidden)
+		return
+	}
+
+	var data TwoFactorPayload
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, `{"error": "Invalid verification data format"}`, http.StatusBadRequest)
+		return
+	}
+	// Single sign-on hands the token over in a cookie instead of the response body
+	if cookie, err := r.Cookie(pendingLoginCookie); err == nil && data.PendingToken == "" {
+		data.PendingToken = cookie.Value
+	}
+
+	meta := SessionMetaFromRequest(r)
+	user, rememberMe, err := CompleteTwoFactorLogin(data.PendingToken, data.Code, meta)
+	if err != nil {
+		if errors.Is(err, ErrPendingLogin) {
+			clearPendingLoginCookie(w)
+		}
+		writeLoginError(w, err)
+		return
+	}
+	clearPendingLoginCookie(w)
+	meta.RememberMe = rememberMe
+	issueSessionCookies(w, user, meta)
+}
+
+// pendingLoginCookie: Pending 2FA token of a single sign-on login, only ever sent to the
+// second login step - it stays out of the redirect URL, browser history and access logs
+const pendingLoginCookie = "pending_login"
+
+func setPendingLoginCookie(w http.ResponseWriter, pendingToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingLoginCookie,
+		Value:    pendingToken,
+		Path:     "/api/auth/login/2fa",
+		MaxAge:   int(core.AppConfig.PendingLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   core.AppConfig.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearPendingLoginCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingLoginCookie,
+		Value:    "",
+		Path:     "/api/auth/login/2fa",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   core.AppConfig.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// writeLoginError: 429 with Retry-After while locked out, 403 for suspended accounts, 401 otherwise
+func writeLoginError(w http.ResponseWriter, err error) {
+	var locked *LoginLockedError
+	if errors.As(err, &locked) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds())+1))
+		WriteJSONError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	var suspended *AccountSuspendedError
+	if errors.As(err, &suspended) {
+		WriteJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	WriteJSONError(w, http.StatusUnauthorized, err.Error())
+}
+
+// issueSessionCookies: Creates the session and answers the successful login
+func issueSessionCookies(w http.ResponseWriter, user UserPayload, meta SessionMeta) {
+	sessionID, err := CreateSession(user.User.UserID, meta)
+	var suspended *AccountSuspendedError
+	if errors.As(err, &suspended) {
+		WriteJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Cannot create session"}`, http.StatusInternalServerError)
+		return
+	}
+	setSessionCookies(w, sessionID, meta.RememberMe)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"user":   user.User,
+	})
+}
+
+// LogoutHandler: Deletes the current session row, clears the cookies and closes the
+// WebSocket connections bound to the session. Not behind RequireAuth: a client whose
+// session already expired or was revoked must still get its stale cookies cleared
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, fromCookie := SessionIDFromRequest(r)
+	if fromCookie && !ValidCSRF(r) {
+		WriteJSONError(w, http.StatusForbidden, "Invalid CSRF token")
+		return
+	}
+
+	// Best effort: an expired or revoked session has no row left, the cookies go regardless
+	var userID string
+	if sessionID != "" {
+		err := core.Db.QueryRow("DELETE FROM sessions WHERE session_id = ? RETURNING user_id", sessionID).Scan(&userID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, `{"error": "Failed to end session"}`, http.StatusInternalServerError)
+			return
+		}
+		forgetSessions(sessionID)
+		closeSessionConns(sessionID)
+	}
+	clearSessionCookies(w)
+	if userID != "" {
+		meta := SessionMetaFromRequest(r)
+		audit.Record(audit.Event{ActorID: userID, Action: "logout", Target: "session:" + publicSessionID(sessionID),
+			IPAddress: meta.IPAddress, UserAgent: meta.UserAgent})
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
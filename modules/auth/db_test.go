@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+
+	"real-time-forum/modules/core"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// openTestDB: Points core.Db at a fresh database in the test's temp directory
+func openTestDB(t *testing.T) {
+	t.Helper()
+	core.InitDB(filepath.Join(t.TempDir(), "forum.db"))
+	t.Cleanup(func() { core.Db.Close() })
+}
+
+// insertTestUser: Adds an account with the given nickname and no password, returns its id
+func insertTestUser(t *testing.T, nickname string) string {
+	t.Helper()
+	userID := uuid.New().String()
+	_, err := core.Db.Exec(`INSERT INTO users (user_id, first_name, last_name, nickname, age, gender, email, password, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		userID, "Test", "User", nickname, 30, "", nickname+"@example.com", "")
+	if err != nil {
+		t.Fatalf("insert user %s: %v", nickname, err)
+	}
+	return userID
+}
+
+// setTestPassword: Stores a bcrypt hash at the minimum cost, so tests stay fast
+func setTestPassword(t *testing.T, userID, password string) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if _, err := core.Db.Exec("UPDATE users SET password = ? WHERE user_id = ?", string(hash), userID); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+// AckPayload: Body of "ack" - the highest seq the client has processed
+type AckPayload struct {
+	Seq int64 `json:"seq"`
+}
+
+// sendDurable: Records an event in the user's outbox, then pushes it live stamped with its seq
+// Connected clients get it right away; the others get it replayed after their next session_check
+func sendDurable(userID string, resp WSResponse) {
+	if seq, err := storeEvent(userID, resp); err != nil {
+		slog.Error("Failed to store durable event", "type", resp.Type, "user_id", userID, "err", err)
+	} else {
+		resp.Seq = seq
+	}
+	hub.sendToUser(userID, resp)
+}
+
+func storeEvent(userID string, resp WSResponse) (int64, error) {
+	payload, err := json.Marshal(resp.Data)
+	if err != nil {
+		return 0, err
+	}
+	res, err := core.Db.Exec(
+		"INSERT INTO user_events (user_id, type, payload, created_at) VALUES (?, ?, ?, ?)",
+		userID, resp.Type, string(payload), time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// AckEvents: Moves the user's delivery cursor forward - never back, never past their newest event
+func AckEvents(userID string, seq int64) error {
+	_, err := core.Db.Exec(`
+		INSERT INTO delivery_cursors (user_id, acked_seq, updated_at)
+		SELECT ?, MIN(?, COALESCE(MAX(seq), 0)), ? FROM user_events WHERE user_id = ?
+		ON CONFLICT(user_id) DO UPDATE SET
+			acked_seq = MAX(acked_seq, excluded.acked_seq),
+			updated_at = excluded.updated_at
+	`, userID, seq, time.Now(), userID)
+	return err
+}
+
+// replayMissed: Re-sends the events after the user's cursor, oldest first, one batch at a time
+// Ends with "replay_done"; "more" asks the client to ack and send "replay" for the next batch
+func replayMissed(c *Client, userID string) {
+	batch := core.AppConfig.DeliveryReplayBatch
+	rows, err := core.Db.Query(`
+		SELECT seq, type, payload FROM user_events
+		WHERE user_id = ?
+		  AND seq > COALESCE((SELECT acked_seq FROM delivery_cursors WHERE user_id = ?), 0)
+		ORDER BY seq ASC
+		LIMIT ?
+	`, userID, userID, batch+1)
+	if err != nil {
+		writeResponse(c, "replay_done", "error", nil, "Unable to load missed messages")
+		return
+	}
+	defer rows.Close()
+
+	var count int
+	var lastSeq int64
+	more := false
+	for rows.Next() {
+		if count == batch {
+			more = true
+			break
+		}
+		var (
+			resp    WSResponse
+			payload string
+		)
+		if err := rows.Scan(&resp.Seq, &resp.Type, &payload); err != nil {
+			break
+		}
+		resp.Status = "ok"
+		resp.Data = json.RawMessage(payload)
+		msg, err := json.Marshal(resp)
+		if err != nil {
+			continue
+		}
+		c.queue(msg)
+		count++
+		lastSeq = resp.Seq
+	}
+	writeResponse(c, "replay_done", "ok", map[string]interface{}{
+		"count":    count,
+		"last_seq": lastSeq,
+		"more":     more,
+	}, "")
+}
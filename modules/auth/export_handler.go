@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"real-time-forum/modules/chat"
+	"real-time-forum/modules/core"
+)
+
+// ConversationExportHandler: GET /api/conversations/{user_id}/export?format=json|html|txt
+// Downloads the whole conversation of the current user with {user_id}; the body is streamed,
+// so an error after the first bytes can only cut the download short
+func ConversationExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := UserFromContext(r.Context())
+	otherID := r.PathValue("user_id")
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = chat.ExportJSON
+	}
+	contentType, err := chat.ExportContentType(format)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Unknown export format: use json, html or txt")
+		return
+	}
+	otherNickname, err := chat.GetNicknameByUserID(otherID)
+	if errors.Is(err, sql.ErrNoRows) {
+		WriteJSONError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, "Unable to export conversation")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="conversation-%s.%s"`, safeFilename(otherNickname), format))
+	w.Header().Set("Cache-Control", "no-store")
+	if err := chat.ExportConversation(w, format, user.ID, otherID); err != nil {
+		core.Logger(r.Context()).Error("Conversation export failed", "other_user_id", otherID, "err", err)
+	}
+}
+
+// safeFilename: Keeps letters, digits, '-' and '_' of a nickname for the download name
+func safeFilename(name string) string {
+	out := make([]rune, 0, len(name))
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			out = append(out, r)
+		default:
+			out = append(out, '_')
+		}
+	}
+	return string(out)
+}
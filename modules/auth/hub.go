@@ -0,0 +1,553 @@
+package auth
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"real-time-forum/modules/core"
+	"real-time-forum/modules/metrics"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Client: One real-time connection (a WebSocket, or an SSE stream when conn is nil)
+// Only the pump (writePump / ssePump) writes; everything else queues messages on send,
+// so a slow client never blocks the goroutine that produced the message
+type Client struct {
+	conn *websocket.Conn
+	send chan []byte
+	ip   string
+	id   string // connection ID in the logs
+
+	log atomic.Pointer[slog.Logger] // carries conn_id and user_id; replaced on login
+
+	errorReplies atomic.Int64 // "error" responses sent, for the message outcome metric
+
+	// Set by Hub.register, read under hub.mu
+	userID    string
+	sessionID string
+
+	closeOnce   sync.Once
+	closing     chan struct{}
+	closeCode   int
+	closeReason string
+}
+
+func newClient(conn *websocket.Conn, ip string) *Client {
+	c := &Client{
+		conn:    conn,
+		send:    make(chan []byte, core.AppConfig.WSSendBufferSize),
+		ip:      ip,
+		id:      uuid.NewString(),
+		closing: make(chan struct{}),
+	}
+	c.setLogUser("")
+	return c
+}
+
+// logger: Logger of this connection - every line carries conn_id and user_id
+func (c *Client) logger() *slog.Logger {
+	return c.log.Load()
+}
+
+func (c *Client) setLogUser(userID string) {
+	c.log.Store(slog.With("conn_id", c.id, "user_id", userID))
+}
+
+// queue: Non-blocking send; a client whose buffer is full is too slow and gets dropped
+func (c *Client) queue(msg []byte) {
+	select {
+	case <-c.closing:
+	case c.send <- msg:
+	default:
+		c.closeWith(CloseSlowConsumer, "client too slow")
+	}
+}
+
+// closeWith: Asks the pump to flush queued messages, send a close frame and close the socket
+// Safe to call from any goroutine, any number of times (the first code wins)
+func (c *Client) closeWith(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.closing)
+		c.logger().Info("ws closing", "code", code, "reason", reason)
+	})
+}
+
+// writePump: The only writer of the connection - messages, pings and the final close frame
+func (c *Client) writePump() {
+	ticker := time.NewTicker(core.AppConfig.WSPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg := <-c.send:
+			if err := c.write(websocket.TextMessage, msg); err != nil {
+				c.closeWith(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				c.closeWith(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.closing:
+			// Deliver what was queued before the close (e.g. "session_expired")
+			for len(c.send) > 0 {
+				if err := c.write(websocket.TextMessage, <-c.send); err != nil {
+					return
+				}
+			}
+			c.write(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeReason))
+			return
+		}
+	}
+}
+
+func (c *Client) write(messageType int, data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(core.AppConfig.WSWriteWait))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// Hub: Registry of live clients - owns registration, unregistration and per-IP counts
+// With a broker it is one node of a cluster: events for users connected elsewhere are
+// published, and presence is the union of every node's connected users
+type Hub struct {
+	mu      sync.RWMutex
+	users   map[string]map[*Client]bool // userID -> authenticated clients (multiple tabs)
+	ipConns map[string]int              // client IP -> open connections (authenticated or not)
+
+	node   string                 // this instance on the backplane
+	broker Broker                 // nil until Start: local delivery only
+	remote map[string]*remoteNode // other instances, by node ID
+}
+
+// remoteNode: What another instance last told us about its connected users
+type remoteNode struct {
+	users    map[string]bool
+	lastSeen time.Time
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		users:   make(map[string]map[*Client]bool),
+		ipConns: make(map[string]int),
+		node:    uuid.NewString(),
+		remote:  make(map[string]*remoteNode),
+	}
+}
+
+var hub = NewHub()
+
+// StartHub: Connects the process hub to the backplane; call before serving requests
+func StartHub(broker Broker) error {
+	return hub.Start(broker)
+}
+
+// Start: Subscribes to the broker, announces this node and keeps presence in sync
+func (h *Hub) Start(broker Broker) error {
+	h.broker = broker
+	if err := broker.Subscribe(h.receive); err != nil {
+		return err
+	}
+	h.publishSync()
+	go h.syncLoop()
+	return nil
+}
+
+// trackIP: Counts a new connection; false when the address is at WSMaxConnsPerIP
+func (h *Hub) trackIP(ip string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ipConns[ip] >= core.AppConfig.WSMaxConnsPerIP {
+		return false
+	}
+	h.ipConns[ip]++
+	return true
+}
+
+func (h *Hub) untrackIP(ip string) {
+	h.mu.Lock()
+	if h.ipConns[ip]--; h.ipConns[ip] <= 0 {
+		delete(h.ipConns, ip)
+	}
+	h.mu.Unlock()
+}
+
+// presenceChange: A user going from zero to one connection on this node or back
+type presenceChange struct {
+	UserID string
+	Online bool
+}
+
+// register: Binds an authenticated client to its user and session
+// Returns false when the user already has WSMaxConnsPerUser connections on this node,
+// plus the presence transitions the registration caused (see announce)
+func (h *Hub) register(c *Client, userID, sessionID string) (bool, []presenceChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var changes []presenceChange
+	if c.userID != "" && c.userID != userID {
+		changes = h.removeLocked(c) // same socket logging in as someone else
+	}
+	conns := h.users[userID]
+	if !conns[c] && len(conns) >= core.AppConfig.WSMaxConnsPerUser {
+		return false, changes
+	}
+	if conns == nil {
+		conns = make(map[*Client]bool)
+		h.users[userID] = conns
+		changes = append(changes, presenceChange{UserID: userID, Online: true})
+	}
+	conns[c] = true
+	c.userID = userID
+	c.sessionID = sessionID
+	c.setLogUser(userID)
+	return true, changes
+}
+
+// unregister: Removes a client (on disconnect); reports the user going offline
+// when this was their last connection on this node
+func (h *Hub) unregister(c *Client) []presenceChange {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.removeLocked(c)
+}
+
+func (h *Hub) removeLocked(c *Client) []presenceChange {
+	conns, ok := h.users[c.userID]
+	if !ok || !conns[c] {
+		return nil
+	}
+	delete(conns, c)
+	if len(conns) > 0 {
+		return nil
+	}
+	delete(h.users, c.userID)
+	return []presenceChange{{UserID: c.userID, Online: false}}
+}
+
+// userConnCount: Number of live connections of a user on this node
+func (h *Hub) userConnCount(userID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.users[userID])
+}
+
+// liveStats: Connection figures for the admin statistics
+// Connections counts every socket and event stream of this node, logged in or not;
+// OnlineUsers is cluster-wide like presence
+func (h *Hub) liveStats() LiveStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	stats := LiveStats{ConnectedUsers: len(h.users), Nodes: 1 + len(h.remote)}
+	for _, conns := range h.users {
+		stats.AuthenticatedConnections += len(conns)
+	}
+	for _, n := range h.ipConns {
+		stats.Connections += n
+	}
+	online := make(map[string]bool, len(h.users))
+	for userID := range h.users {
+		online[userID] = true
+	}
+	for _, rn := range h.remote {
+		for userID := range rn.users {
+			online[userID] = true
+		}
+	}
+	stats.OnlineUsers = len(online)
+	return stats
+}
+
+// Connection gauges, read from the hub on every /metrics scrape
+var (
+	_ = metrics.NewGaugeFunc("forum_ws_connections",
+		"Open WebSocket and event stream connections on this instance, logged in or not",
+		func() float64 { return float64(hub.liveStats().Connections) })
+	_ = metrics.NewGaugeFunc("forum_ws_authenticated_connections",
+		"Open connections of logged-in users on this instance",
+		func() float64 { return float64(hub.liveStats().AuthenticatedConnections) })
+	_ = metrics.NewGaugeFunc("forum_connected_users",
+		"Users with at least one connection on this instance",
+		func() float64 { return float64(hub.liveStats().ConnectedUsers) })
+	_ = metrics.NewGaugeFunc("forum_online_users",
+		"Users online on any instance (cluster-wide presence)",
+		func() float64 { return float64(hub.liveStats().OnlineUsers) })
+)
+
+// isOnline: True while the user has a live connection on any node
+func (h *Hub) isOnline(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.onlineLocked(userID)
+}
+
+func (h *Hub) onlineLocked(userID string) bool {
+	return len(h.users[userID]) > 0 || h.remoteOnlineLocked(userID)
+}
+
+func (h *Hub) remoteOnlineLocked(userID string) bool {
+	for _, rn := range h.remote {
+		if rn.users[userID] {
+			return true
+		}
+	}
+	return false
+}
+
+// clientsOf: Snapshot of a user's clients, safe to use after the lock is released
+func (h *Hub) clientsOf(userID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := make([]*Client, 0, len(h.users[userID]))
+	for c := range h.users[userID] {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// sessionClients: Clients bound to any of the given sessions
+func (h *Hub) sessionClients(sessionIDs ...string) []*Client {
+	wanted := make(map[string]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		wanted[id] = true
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var clients []*Client
+	for _, conns := range h.users {
+		for c := range conns {
+			if wanted[c.sessionID] {
+				clients = append(clients, c)
+			}
+		}
+	}
+	return clients
+}
+
+// sendToUser: Queues a message on every connection of a user, on every node
+func (h *Hub) sendToUser(userID string, resp WSResponse) {
+	msg, err := json.Marshal(resp)
+	if err != nil {
+		slog.Error("Failed to encode WS message", "type", resp.Type, "user_id", userID, "err", err)
+		return
+	}
+	h.deliver(userID, msg)
+	h.publish(Envelope{Kind: envelopeUser, UserID: userID, Message: msg})
+}
+
+// broadcast: Queues a message on every authenticated connection, on every node
+func (h *Hub) broadcast(resp WSResponse) {
+	msg, err := json.Marshal(resp)
+	if err != nil {
+		slog.Error("Failed to encode WS message", "type", resp.Type, "err", err)
+		return
+	}
+	h.deliverAll(msg)
+	h.publish(Envelope{Kind: envelopeBroadcast, Message: msg})
+}
+
+// closeSessions: Closes the connections of revoked sessions, on every node
+func (h *Hub) closeSessions(sessionIDs ...string) {
+	h.closeSessionsWith(CloseSessionRevoked, "session revoked", sessionIDs...)
+}
+
+// closeSessionsWith: closeSessions with a specific close code and reason
+func (h *Hub) closeSessionsWith(code int, reason string, sessionIDs ...string) {
+	h.closeLocalSessions(code, reason, sessionIDs...)
+	h.publish(Envelope{Kind: envelopeCloseSessions, SessionIDs: sessionIDs, CloseCode: code, CloseText: reason})
+}
+
+func (h *Hub) closeLocalSessions(code int, reason string, sessionIDs ...string) {
+	for _, c := range h.sessionClients(sessionIDs...) {
+		c.closeWith(code, reason)
+	}
+}
+
+func (h *Hub) deliver(userID string, msg []byte) {
+	for _, c := range h.clientsOf(userID) {
+		c.queue(msg)
+	}
+}
+
+func (h *Hub) deliverAll(msg []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, conns := range h.users {
+		for c := range conns {
+			c.queue(msg)
+		}
+	}
+}
+
+func (h *Hub) publish(env Envelope) {
+	if h.broker == nil {
+		return
+	}
+	env.Node = h.node
+	if err := h.broker.Publish(env); err != nil {
+		slog.Error("Failed to publish to broker", "kind", env.Kind, "node", h.node, "err", err)
+	}
+}
+
+// receive: Handles events published by other nodes (our own were delivered already)
+func (h *Hub) receive(env Envelope) {
+	if env.Node == h.node {
+		return
+	}
+	switch env.Kind {
+	case envelopeUser:
+		h.deliver(env.UserID, env.Message)
+	case envelopeBroadcast:
+		h.deliverAll(env.Message)
+	case envelopeCloseSessions:
+		if env.CloseCode == 0 {
+			env.CloseCode, env.CloseText = CloseSessionRevoked, "session revoked"
+		}
+		// The publishing node only dropped its own auth cache; REST calls here must re-check too
+		forgetSessions(env.SessionIDs...)
+		h.closeLocalSessions(env.CloseCode, env.CloseText, env.SessionIDs...)
+	case envelopePresence:
+		h.applyRemotePresence(env.Node, func(rn *remoteNode) map[string]bool {
+			next := make(map[string]bool, len(rn.users)+1)
+			for userID := range rn.users {
+				next[userID] = true
+			}
+			if env.Online {
+				next[env.UserID] = true
+			} else {
+				delete(next, env.UserID)
+			}
+			return next
+		})
+	case envelopePresenceSync:
+		h.applyRemotePresence(env.Node, func(*remoteNode) map[string]bool {
+			next := make(map[string]bool, len(env.Users))
+			for _, userID := range env.Users {
+				next[userID] = true
+			}
+			return next
+		})
+	}
+}
+
+// applyRemotePresence: Replaces a node's user set and tells local clients about every
+// user whose cluster-wide state changed. A node heard from for the first time gets our
+// own list back, so a freshly started instance learns the cluster state right away
+func (h *Hub) applyRemotePresence(node string, update func(*remoteNode) map[string]bool) {
+	h.mu.Lock()
+	rn, known := h.remote[node]
+	if !known {
+		rn = &remoteNode{users: make(map[string]bool)}
+		h.remote[node] = rn
+	}
+	rn.lastSeen = time.Now()
+	changes := h.replaceUsersLocked(rn, update(rn))
+	h.mu.Unlock()
+
+	for _, change := range changes {
+		h.notifyPresence(change)
+	}
+	if !known {
+		h.publishSync()
+	}
+}
+
+// replaceUsersLocked: Swaps a node's user set, returning the cluster-wide transitions
+func (h *Hub) replaceUsersLocked(rn *remoteNode, next map[string]bool) []presenceChange {
+	var changes []presenceChange
+	for userID := range rn.users {
+		if !next[userID] {
+			delete(rn.users, userID)
+			if !h.onlineLocked(userID) {
+				changes = append(changes, presenceChange{UserID: userID, Online: false})
+			}
+		}
+	}
+	for userID := range next {
+		if !rn.users[userID] {
+			wasOnline := h.onlineLocked(userID)
+			rn.users[userID] = true
+			if !wasOnline {
+				changes = append(changes, presenceChange{UserID: userID, Online: true})
+			}
+		}
+	}
+	return changes
+}
+
+// announce: Publishes this node's presence transitions and notifies local clients of
+// those that change the cluster-wide state (a user already online elsewhere stays online)
+func (h *Hub) announce(changes ...presenceChange) {
+	for _, change := range changes {
+		h.publish(Envelope{Kind: envelopePresence, UserID: change.UserID, Online: change.Online})
+		h.mu.RLock()
+		elsewhere := h.remoteOnlineLocked(change.UserID)
+		h.mu.RUnlock()
+		if !elsewhere {
+			h.notifyPresence(change)
+		}
+	}
+}
+
+// notifyPresence: "presence_changed" for the clients of this node only - every node
+// derives the event from its own view, so it is never relayed
+func (h *Hub) notifyPresence(change presenceChange) {
+	payload := PresencePayload{UserID: change.UserID, Online: change.Online}
+	core.Db.QueryRow("SELECT nickname FROM users WHERE user_id = ?", change.UserID).Scan(&payload.Nickname)
+	msg, err := json.Marshal(WSResponse{Type: "presence_changed", Status: "ok", Data: payload})
+	if err != nil {
+		return
+	}
+	h.deliverAll(msg)
+}
+
+// publishSync: Announces the full list of users connected to this node
+func (h *Hub) publishSync() {
+	h.mu.RLock()
+	users := make([]string, 0, len(h.users))
+	for userID := range h.users {
+		users = append(users, userID)
+	}
+	h.mu.RUnlock()
+	h.publish(Envelope{Kind: envelopePresenceSync, Users: users})
+}
+
+// syncLoop: Re-announces this node periodically (repairing missed events) and forgets
+// nodes that stopped announcing - their users go offline
+func (h *Hub) syncLoop() {
+	interval := core.AppConfig.PresenceSyncInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.publishSync()
+
+		var changes []presenceChange
+		h.mu.Lock()
+		for node, rn := range h.remote {
+			if time.Since(rn.lastSeen) > 3*interval {
+				delete(h.remote, node)
+				for userID := range rn.users {
+					if !h.onlineLocked(userID) {
+						changes = append(changes, presenceChange{UserID: userID, Online: false})
+					}
+				}
+			}
+		}
+		h.mu.Unlock()
+		for _, change := range changes {
+			h.notifyPresence(change)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// startTestCluster: Two hubs on one LocalBroker, behaving like two server instances
+func startTestCluster(t *testing.T) (*Hub, *Hub) {
+	t.Helper()
+	openTestDB(t)
+	broker := NewLocalBroker()
+	t.Cleanup(func() { broker.Close() })
+	a, b := NewHub(), NewHub()
+	for _, h := range []*Hub{a, b} {
+		if err := h.Start(broker); err != nil {
+			t.Fatalf("start hub: %v", err)
+		}
+	}
+	return a, b
+}
+
+// connectTestClient: A logged-in client without a socket; messages stay in its send queue
+func connectTestClient(t *testing.T, h *Hub, userID, sessionID string) *Client {
+	t.Helper()
+	c := newClient(nil, "192.0.2.1")
+	ok, changes := h.register(c, userID, sessionID)
+	if !ok {
+		t.Fatalf("register %s: connection limit reached", userID)
+	}
+	h.announce(changes...)
+	return c
+}
+
+func disconnectTestClient(h *Hub, c *Client) {
+	h.announce(h.unregister(c)...)
+}
+
+// queuedTypes: Drains the client's send queue and returns the message types in order
+func queuedTypes(t *testing.T, c *Client) []string {
+	t.Helper()
+	var types []string
+	for {
+		select {
+		case msg := <-c.send:
+			var resp WSResponse
+			if err := json.Unmarshal(msg, &resp); err != nil {
+				t.Fatalf("queued message is not a WSResponse: %s", msg)
+			}
+			types = append(types, resp.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func countType(types []string, want string) int {
+	n := 0
+	for _, typ := range types {
+		if typ == want {
+			n++
+		}
+	}
+	return n
+}
+
+func isClosing(c *Client) bool {
+	select {
+	case <-c.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestHubSendToUserAcrossNodes(t *testing.T) {
+	a, b := startTestCluster(t)
+	onA := connectTestClient(t, a, "user-1", "session-1")
+	onB := connectTestClient(t, b, "user-1", "session-2")
+	other := connectTestClient(t, b, "user-2", "session-3")
+	queuedTypes(t, onA)
+	queuedTypes(t, onB)
+	queuedTypes(t, other)
+
+	a.sendToUser("user-1", WSResponse{Type: "private_message", Status: "ok"})
+
+	// Every tab of the user gets the message exactly once, wherever it is connected
+	if n := countType(queuedTypes(t, onA), "private_message"); n != 1 {
+		t.Fatalf("client on the sending node got %d messages, want 1", n)
+	}
+	if n := countType(queuedTypes(t, onB), "private_message"); n != 1 {
+		t.Fatalf("client on the other node got %d messages, want 1", n)
+	}
+	if n := countType(queuedTypes(t, other), "private_message"); n != 0 {
+		t.Fatalf("another user got %d messages, want 0", n)
+	}
+
+	b.broadcast(WSResponse{Type: "new_post", Status: "ok"})
+	for _, c := range []*Client{onA, onB, other} {
+		if n := countType(queuedTypes(t, c), "new_post"); n != 1 {
+			t.Fatalf("broadcast reached a client %d times, want 1", n)
+		}
+	}
+}
+
+func TestHubCloseSessionsAcrossNodes(t *testing.T) {
+	a, b := startTestCluster(t)
+	revoked := connectTestClient(t, b, "user-1", "session-1")
+	sameUser := connectTestClient(t, b, "user-1", "session-2")
+	local := connectTestClient(t, a, "user-1", "session-1")
+
+	a.closeSessions("session-1")
+
+	if !isClosing(revoked) || !isClosing(local) {
+		t.Fatal("connections of the revoked session were not closed on both nodes")
+	}
+	if revoked.closeCode != CloseSessionRevoked {
+		t.Fatalf("close code = %d, want %d", revoked.closeCode, CloseSessionRevoked)
+	}
+	if isClosing(sameUser) {
+		t.Fatal("connection of another session of the same user was closed")
+	}
+
+	b.closeSessionsWith(CloseSuspended, "account suspended", "session-2")
+	if !isClosing(sameUser) || sameUser.closeCode != CloseSuspended || sameUser.closeReason != "account suspended" {
+		t.Fatalf("close = %d %q, want the code and reason given by the publishing node", sameUser.closeCode, sameUser.closeReason)
+	}
+}
+
+func TestHubCloseSessionsForgetsCachedAuth(t *testing.T) {
+	_, b := startTestCluster(t)
+	sessionCacheMu.Lock()
+	sessionCache["session-1"] = cachedSession{user: AuthUser{ID: "user-1", SessionID: "session-1"}}
+	sessionCacheMu.Unlock()
+	t.Cleanup(func() { forgetSessions("session-1") })
+
+	// Each node has its own cache in production; deliver as if another node revoked the session
+	b.receive(Envelope{Kind: envelopeCloseSessions, Node: "other-node", SessionIDs: []string{"session-1"}})
+
+	sessionCacheMu.Lock()
+	_, cached := sessionCache["session-1"]
+	sessionCacheMu.Unlock()
+	if cached {
+		t.Fatal("revoked session is still in the auth cache of the receiving node")
+	}
+}
+
+func TestHubPresenceMergedAcrossNodes(t *testing.T) {
+	a, b := startTestCluster(t)
+	watcher := connectTestClient(t, b, "watcher", "session-w")
+	queuedTypes(t, watcher)
+
+	alice := connectTestClient(t, a, "alice", "session-a1")
+	if !b.isOnline("alice") {
+		t.Fatal("user connected to the other node is not online")
+	}
+	if n := countType(queuedTypes(t, watcher), "presence_changed"); n != 1 {
+		t.Fatalf("watcher got %d presence events for alice coming online, want 1", n)
+	}
+
+	// A second tab on the other node changes nothing cluster-wide
+	aliceOnB := connectTestClient(t, b, "alice", "session-a2")
+	if n := countType(queuedTypes(t, watcher), "presence_changed"); n != 0 {
+		t.Fatalf("watcher got %d presence events for a second tab, want 0", n)
+	}
+
+	for _, h := range []*Hub{a, b} {
+		stats := h.liveStats()
+		if stats.OnlineUsers != 2 || stats.Nodes != 2 {
+			t.Fatalf("liveStats = %+v, want 2 online users on 2 nodes", stats)
+		}
+	}
+
+	disconnectTestClient(a, alice)
+	if !a.isOnline("alice") || !b.isOnline("alice") {
+		t.Fatal("user went offline while still connected to the other node")
+	}
+	if n := countType(queuedTypes(t, watcher), "presence_changed"); n != 0 {
+		t.Fatalf("watcher got %d presence events while alice stayed online, want 0", n)
+	}
+
+	disconnectTestClient(b, aliceOnB)
+	if a.isOnline("alice") || b.isOnline("alice") {
+		t.Fatal("user still online after closing the last connection")
+	}
+	if n := countType(queuedTypes(t, watcher), "presence_changed"); n != 1 {
+		t.Fatalf("watcher got %d presence events for alice going offline, want 1", n)
+	}
+}
+
+func TestHubLearnsPresenceOfRunningNode(t *testing.T) {
+	openTestDB(t)
+	broker := NewLocalBroker()
+	t.Cleanup(func() { broker.Close() })
+
+	a := NewHub()
+	if err := a.Start(broker); err != nil {
+		t.Fatalf("start hub: %v", err)
+	}
+	connectTestClient(t, a, "alice", "session-a")
+
+	// A node joining later gets the full list from the sync exchange
+	b := NewHub()
+	if err := b.Start(broker); err != nil {
+		t.Fatalf("start hub: %v", err)
+	}
+	if !b.isOnline("alice") {
+		t.Fatal("new node did not learn about users already connected elsewhere")
+	}
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"real-time-forum/modules/audit"
+	"real-time-forum/modules/core"
+)
+
+// LoginLockedError: Returned by LoginUser while the account or the client IP is locked out
+// The message is the same whether or not the account exists
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, please try again in %d seconds", int(e.RetryAfter.Seconds())+1)
+}
+
+// accountAttemptKey: Failure counter key for a login identifier
+// Known accounts are keyed by user ID (email and nickname share the counter); unknown
+// identifiers get their own counter so lockouts behave the same and reveal nothing
+func accountAttemptKey(emailOrNickname string) string {
+	var userID string
+	err := core.Db.QueryRow("SELECT user_id FROM users WHERE email = ? OR nickname = ?", emailOrNickname, emailOrNickname).Scan(&userID)
+	if err != nil {
+		return "unknown:" + strings.ToLower(emailOrNickname)
+	}
+	return "user:" + userID
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginLockedFor: Longest remaining lockout among the given keys, if any
+func loginLockedFor(keys ...string) (time.Duration, bool) {
+	var retryAfter time.Duration
+	now := time.Now()
+	for _, key := range keys {
+		var lockedUntil sql.NullTime
+		err := core.Db.QueryRow("SELECT locked_until FROM login_attempts WHERE attempt_key = ?", key).Scan(&lockedUntil)
+		if err != nil || !lockedUntil.Valid {
+			continue
+		}
+		if remaining := lockedUntil.Time.Sub(now); remaining > retryAfter {
+			retryAfter = remaining
+		}
+	}
+	return retryAfter, retryAfter > 0
+}
+
+// recordLoginFailure: Counts a failed attempt; from the threshold on, every failure locks
+// the key for an exponentially growing period (base * 2^n, capped) and is audited
+func recordLoginFailure(key string, threshold int, meta SessionMeta) {
+	now := time.Now()
+	cfg := core.AppConfig
+
+	var failures int
+	var lastFailure sql.NullTime
+	err := core.Db.QueryRow("SELECT failures, last_failure_at FROM login_attempts WHERE attempt_key = ?", key).Scan(&failures, &lastFailure)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Warn("Failed to read login attempts", "key", key, "err", err)
+		return
+	}
+	if !lastFailure.Valid || now.Sub(lastFailure.Time) > cfg.LoginFailureWindow {
+		failures = 0 // previous failures are too old to count
+	}
+	failures++
+
+	var lockedUntil sql.NullTime
+	if failures >= threshold {
+		lockout := cfg.LoginLockoutMax
+		if exp := failures - threshold; exp < 20 {
+			lockout = min(cfg.LoginLockoutBase<<exp, cfg.LoginLockoutMax)
+		}
+		lockedUntil = sql.NullTime{Time: now.Add(lockout), Valid: true}
+	}
+
+	_, err = core.Db.Exec(`
+		INSERT INTO login_attempts (attempt_key, failures, last_failure_at, locked_until)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(attempt_key) DO UPDATE SET
+			failures = excluded.failures,
+			last_failure_at = excluded.last_failure_at,
+			locked_until = excluded.locked_until`,
+		key, failures, now, lockedUntil)
+	if err != nil {
+		slog.Warn("Failed to record login failure", "key", key, "err", err)
+		return
+	}
+
+	if lockedUntil.Valid {
+		scope := "account"
+		if strings.HasPrefix(key, "ip:") {
+			scope = "ip"
+		}
+		audit.Record(audit.Event{
+			Action:    "login_lockout",
+			Target:    key,
+			IPAddress: meta.IPAddress,
+			UserAgent: meta.UserAgent,
+			Details: map[string]interface{}{
+				"scope":        scope,
+				"failures":     failures,
+				"locked_until": lockedUntil.Time,
+			},
+		})
+	}
+}
+
+// clearLoginFailures: Resets a counter after a successful login
+func clearLoginFailures(key string) {
+	_, err := core.Db.Exec("DELETE FROM login_attempts WHERE attempt_key = ?", key)
+	if err != nil {
+		slog.Warn("Failed to reset login attempts", "key", key, "err", err)
+	}
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+// AuthUser: Authenticated user attached to the request context by RequireAuth
+type AuthUser struct {
+	ID        string `json:"user_id"`
+	Nickname  string `json:"nickname"`
+	Role      string `json:"role"`
+	SessionID string `json:"-"`
+}
+
+type contextKey struct{}
+
+var authUserKey = contextKey{}
+
+// cachedSession: Resolved session kept in memory to skip repeated DB lookups
+type cachedSession struct {
+	user             AuthUser
+	cachedUntil      time.Time
+	sessionExpiresAt time.Time
+}
+
+// maxCachedSessions: Size above which stale cache entries are swept on insert
+const maxCachedSessions = 1024
+
+var (
+	sessionCache   = make(map[string]cachedSession)
+	sessionCacheMu sync.Mutex
+)
+
+// RequireAuth: Wraps an /api handler - resolves the session once (header or cookie),
+// enforces CSRF for cookie auth and attaches the AuthUser to the request context
+func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionID, fromCookie := SessionIDFromRequest(r)
+		if sessionID == "" {
+			WriteJSONError(w, http.StatusUnauthorized, "Session required")
+			return
+		}
+		if fromCookie && !ValidCSRF(r) {
+			WriteJSONError(w, http.StatusForbidden, "Invalid CSRF token")
+			return
+		}
+
+		user, err := authenticate(sessionID)
+		var suspended *AccountSuspendedError
+		if errors.As(err, &suspended) {
+			WriteJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			WriteJSONError(w, http.StatusUnauthorized, "Invalid session")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authUserKey, user)
+		ctx = core.WithLogger(ctx, core.Logger(ctx).With("user_id", user.ID))
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// UserFromContext: Returns the user attached by RequireAuth
+func UserFromContext(ctx context.Context) (AuthUser, bool) {
+	user, ok := ctx.Value(authUserKey).(AuthUser)
+	return user, ok
+}
+
+// WriteJSONError: Uniform JSON error body for the REST API
+func WriteJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// authenticate: Cache lookup first, then the same resolution path as the WebSocket
+// (expiry check + sliding refresh) so REST and WS agree on session validity
+func authenticate(sessionID string) (AuthUser, error) {
+	now := time.Now()
+
+	sessionCacheMu.Lock()
+	entry, ok := sessionCache[sessionID]
+	sessionCacheMu.Unlock()
+	if ok && now.Before(entry.cachedUntil) && now.Before(entry.sessionExpiresAt) {
+		return entry.user, nil
+	}
+
+	payload, expiresAt, err := ResolveSession(sessionID)
+	if err != nil {
+		forgetSessions(sessionID)
+		return AuthUser{}, err
+	}
+
+	user := AuthUser{
+		ID:        payload.User.UserID,
+		Nickname:  payload.User.Nickname,
+		Role:      payload.User.Role,
+		SessionID: sessionID,
+	}
+	sessionCacheMu.Lock()
+	if len(sessionCache) >= maxCachedSessions {
+		for id, e := range sessionCache {
+			if now.After(e.cachedUntil) {
+				delete(sessionCache, id)
+			}
+		}
+	}
+	sessionCache[sessionID] = cachedSession{
+		user:             user,
+		cachedUntil:      now.Add(core.AppConfig.AuthCacheTTL),
+		sessionExpiresAt: expiresAt,
+	}
+	sessionCacheMu.Unlock()
+	return user, nil
+}
+
+// forgetSessions: Drops sessions from the cache (logout, revocation, expiry)
+func forgetSessions(sessionIDs ...string) {
+	sessionCacheMu.Lock()
+	for _, id := range sessionIDs {
+		delete(sessionCache, id)
+	}
+	sessionCacheMu.Unlock()
+}
+
+// RoleAdmin: users.role of accounts allowed on /api/admin
+const RoleAdmin = "admin"
+
+// RequireAdmin: RequireAuth plus a role check; other users get 403
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if user, _ := UserFromContext(r.Context()); user.Role != RoleAdmin {
+			WriteJSONError(w, http.StatusForbidden, "Admin access required")
+			return
+		}
+		next(w, r)
+	})
+}
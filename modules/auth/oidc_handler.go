@@ -0,0 +1,388 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"real-time-forum/modules/audit"
+	"real-time-forum/modules/core"
+	"real-time-forum/modules/metrics"
+
+	"github.com/google/uuid"
+)
+
+// oauthStateCookie: Binds an authorization request to the browser that started it,
+// so a callback URL cannot be replayed in someone else's browser (login CSRF)
+const oauthStateCookie = "oauth_state"
+
+// ProviderInfo: External login option shown on the login page
+type ProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	LoginURL    string `json:"login_url"`
+}
+
+// ProvidersHandler: Lists the configured external login providers
+func ProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	loginProvidersMu.RLock()
+	providers := make([]ProviderInfo, 0, len(loginProviders))
+	for name, p := range loginProviders {
+		providers = append(providers, ProviderInfo{
+			Name:        name,
+			DisplayName: p.DisplayName(),
+			LoginURL:    "/api/auth/oidc/" + url.PathEscape(name) + "/login",
+		})
+	}
+	loginProvidersMu.RUnlock()
+
+	json.NewEncoder(w).Encode(providers)
+}
+
+// OIDCLoginHandler: Starts the authorization-code flow - stores state, PKCE verifier
+// and nonce, then redirects the browser to the provider
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := getLoginProvider(r.PathValue("provider"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	state, verifier, nonce := randomToken(), randomToken(), randomToken()
+	challenge := sha256.Sum256([]byte(verifier))
+	authURL, err := provider.AuthCodeURL(state, base64.RawURLEncoding.EncodeToString(challenge[:]), nonce)
+	if err != nil {
+		core.Logger(r.Context()).Warn("OIDC provider unavailable", "provider", provider.Name(), "err", err)
+		redirectLoginError(w, r, "Login provider is unavailable")
+		return
+	}
+
+	rememberMe := r.URL.Query().Get("remember_me") == "1"
+	_, err = core.Db.Exec(
+		"INSERT INTO oauth_states (state, provider, code_verifier, nonce, remember_me, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		state, provider.Name(), verifier, nonce, rememberMe, time.Now().Add(core.AppConfig.OAuthStateTTL))
+	if err != nil {
+		redirectLoginError(w, r, "Cannot start login")
+		return
+	}
+
+	// Lax, not Strict: the callback is a cross-site navigation coming back from the provider
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   int(core.AppConfig.OAuthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   core.AppConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler: Finishes the flow - checks state, exchanges the code, links or
+// provisions the local user and issues a normal session cookie (or, with 2FA, a pending login)
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := getLoginProvider(r.PathValue("provider"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		redirectLoginError(w, r, "Login was cancelled or denied")
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		redirectLoginError(w, r, "Login request expired, please try again")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Value: "", Path: "/api/auth/oidc/", MaxAge: -1})
+
+	verifier, nonce, rememberMe, err := consumeOAuthState(state, provider.Name())
+	if err != nil {
+		redirectLoginError(w, r, "Login request expired, please try again")
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), query.Get("code"), verifier, nonce)
+	if err != nil {
+		core.Logger(r.Context()).Warn("OIDC login failed", "provider", provider.Name(), "err", err)
+		metrics.Logins.Inc("failure", "external_provider")
+		redirectLoginError(w, r, "Login with "+provider.DisplayName()+" failed")
+		return
+	}
+
+	meta := SessionMetaFromRequest(r)
+	meta.RememberMe = rememberMe
+	userID, err := userForExternalIdentity(identity, meta)
+	if err != nil {
+		metrics.Logins.Inc("failure", "external_account")
+		redirectLoginError(w, r, err.Error())
+		return
+	}
+
+	// An account with TOTP still needs its second factor: the SPA shows the same code
+	// form as after a password login and redeems the pending token on /api/auth/login/2fa
+	enabled, err := userHasTOTP(userID)
+	if err != nil {
+		redirectLoginError(w, r, "Cannot sign in")
+		return
+	}
+	if enabled {
+		pendingToken, err := createPendingLogin(userID, rememberMe)
+		if err != nil {
+			redirectLoginError(w, r, "Cannot sign in")
+			return
+		}
+		setPendingLoginCookie(w, pendingToken)
+		http.Redirect(w, r, "/?sso_2fa=1#login", http.StatusFound)
+		return
+	}
+
+	sessionID, err := CreateSession(userID, meta)
+	if err != nil {
+		var suspended *AccountSuspendedError
+		if errors.As(err, &suspended) {
+			metrics.Logins.Inc("failure", "suspended")
+		}
+		redirectLoginError(w, r, sessionErrorMessage(err))
+		return
+	}
+	setSessionCookies(w, sessionID, rememberMe)
+	metrics.Logins.Inc("success", "")
+	audit.Record(audit.Event{
+		ActorID:   userID,
+		Action:    "login_external",
+		Target:    "user:" + userID,
+		IPAddress: meta.IPAddress,
+		UserAgent: meta.UserAgent,
+		Details:   map[string]interface{}{"provider": identity.Provider},
+	})
+
+	// The SPA picks the cookie up on this route and reconnects its WebSocket
+	http.Redirect(w, r, "/#sso-complete", http.StatusFound)
+}
+
+// consumeOAuthState: Single-use lookup of a stored authorization request
+func consumeOAuthState(state, provider string) (verifier, nonce string, rememberMe bool, err error) {
+	var storedProvider string
+	var expiresAt time.Time
+	err = core.Db.QueryRow(
+		"SELECT provider, code_verifier, nonce, remember_me, expires_at FROM oauth_states WHERE state = ?", state).
+		Scan(&storedProvider, &verifier, &nonce, &rememberMe, &expiresAt)
+	if err != nil {
+		return "", "", false, err
+	}
+	res, err := core.Db.Exec("DELETE FROM oauth_states WHERE state = ?", state)
+	if err != nil {
+		return "", "", false, err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return "", "", false, errors.New("state already used")
+	}
+	if storedProvider != provider || time.Now().After(expiresAt) {
+		return "", "", false, errors.New("state expired")
+	}
+	return verifier, nonce, rememberMe, nil
+}
+
+// userForExternalIdentity: Known subject -> its user; verified email of an existing
+// account -> link it; otherwise provision a new account
+func userForExternalIdentity(identity ExternalIdentity, meta SessionMeta) (string, error) {
+	var userID string
+	err := core.Db.QueryRow("SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?",
+		identity.Provider, identity.Subject).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	if identity.Email == "" || IsValidEmail(identity.Email) != nil {
+		return "", errors.New("your identity provider did not share a valid email address")
+	}
+
+	err = core.Db.QueryRow("SELECT user_id FROM users WHERE email = ?", identity.Email).Scan(&userID)
+	if err == nil {
+		// Only trust the email for linking when the provider has verified it
+		if !identity.EmailVerified {
+			return "", errors.New("an account with this email already exists")
+		}
+		if err := linkIdentity(core.Db, userID, identity); err != nil {
+			return "", err
+		}
+		audit.Record(audit.Event{
+			ActorID:   userID,
+			Action:    "identity_linked",
+			Target:    "user:" + userID,
+			IPAddress: meta.IPAddress,
+			UserAgent: meta.UserAgent,
+			Details:   map[string]interface{}{"provider": identity.Provider},
+		})
+		return userID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	userID, err = provisionExternalUser(identity)
+	if err != nil {
+		return "", err
+	}
+	audit.Record(audit.Event{
+		ActorID:   userID,
+		Action:    "user_provisioned",
+		Target:    "user:" + userID,
+		IPAddress: meta.IPAddress,
+		UserAgent: meta.UserAgent,
+		Details:   map[string]interface{}{"provider": identity.Provider},
+	})
+	return userID, nil
+}
+
+// provisionExternalUser: Creates the users row and its identity link in one transaction
+// The password hash is empty, which never matches - these accounts sign in through the provider
+func provisionExternalUser(identity ExternalIdentity) (string, error) {
+	var data UserPayload
+	data.User.FirstName = lettersOnly(identity.GivenName, "Member")
+	data.User.LastName = lettersOnly(identity.FamilyName, "User")
+	data.User.Email = identity.Email
+
+	nickname, err := generateNickname(identity, data)
+	if err != nil {
+		return "", err
+	}
+	data.User.Nickname = nickname
+
+	tx, err := core.Db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	userID := uuid.New().String()
+	_, err = tx.Exec(`INSERT INTO users (user_id, first_name, last_name, nickname, age, gender, email, password, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		userID, data.User.FirstName, data.User.LastName, data.User.Nickname, 0, "", data.User.Email, "")
+	if err != nil {
+		return "", err
+	}
+	if err := linkIdentity(tx, userID, identity); err != nil {
+		return "", err
+	}
+	return userID, tx.Commit()
+}
+
+// execer: *sql.DB or *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func linkIdentity(db execer, userID string, identity ExternalIdentity) error {
+	_, err := db.Exec("INSERT INTO user_identities (provider, subject, user_id, email, created_at) VALUES (?, ?, ?, ?, ?)",
+		identity.Provider, identity.Subject, userID, identity.Email, time.Now())
+	return err
+}
+
+// generateNickname: Derives a free nickname from the provider's username or the email,
+// adding a numeric suffix on collisions; the result always passes ValidateNamesAndNickname
+func generateNickname(identity ExternalIdentity, data UserPayload) (string, error) {
+	base := identity.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+
+	var b strings.Builder
+	for _, r := range base {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' {
+			b.WriteRune(r)
+		}
+	}
+	candidate := truncateUTF8(b.String(), 15) // room for a suffix within the 20 character limit
+	// Letters are counted on what the cut kept; the prefix itself survives the second cut
+	letters := 0
+	for _, r := range candidate {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	if letters < 4 {
+		candidate = truncateUTF8("user"+candidate, 15)
+	}
+
+	for i := 0; i < 20; i++ {
+		nickname := candidate
+		if i > 0 {
+			nickname = fmt.Sprintf("%s%d", candidate, 1000+randomInt(9000))
+		}
+		data.User.Nickname = nickname
+		if ValidateNamesAndNickname(data) != nil {
+			break
+		}
+		var existing string
+		err := core.Db.QueryRow("SELECT nickname FROM users WHERE nickname = ?", nickname).Scan(&existing)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nickname, nil
+		}
+	}
+	return "", errors.New("could not generate a nickname for your account")
+}
+
+// lettersOnly: Keeps the letters of a name (our name rules), capped at 20 characters
+func lettersOnly(name, fallback string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) && b.Len()+utf8.RuneLen(r) <= 20 {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() < 2 {
+		return fallback
+	}
+	return b.String()
+}
+
+// truncateUTF8: Cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	for len(s) > n {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
+
+// redirectLoginError: Back to the login page with a message the SPA displays
+func redirectLoginError(w http.ResponseWriter, r *http.Request, msg string) {
+	http.Redirect(w, r, "/?sso_error="+url.QueryEscape(msg)+"#login", http.StatusFound)
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func randomInt(n int) int {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return int(uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8|uint32(b[3])) % n
+}
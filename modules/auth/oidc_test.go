@@ -0,0 +1,450 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+// testIdP: Local OpenID provider - discovery, JWKS and a token endpoint that checks PKCE
+type testIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	clientID string
+
+	mu    sync.Mutex
+	codes map[string]testAuthCode
+	// signWith: When set, ID tokens are signed with this key instead of the published one
+	signWith *rsa.PrivateKey
+}
+
+// testAuthCode: What the provider remembers about an authorization code it handed out
+type testAuthCode struct {
+	challenge string
+	nonce     string
+	claims    map[string]interface{}
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	idp := &testIdP{key: key, kid: "test-key", clientID: "forum", codes: make(map[string]testAuthCode)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": idp.kid,
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// token: Redeems a code once, only with the verifier matching its S256 challenge
+func (idp *testIdP) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	r.ParseForm()
+	idp.mu.Lock()
+	code, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	signWith := idp.signWith
+	idp.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("client_id") != idp.clientID ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != code.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   idp.server.URL,
+		"aud":   idp.clientID,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": code.nonce,
+	}
+	for k, v := range code.claims {
+		claims[k] = v
+	}
+	if signWith == nil {
+		signWith = idp.key
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signTestJWT(signWith, idp.kid, claims)})
+}
+
+func signTestJWT(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// authorize: Stands in for the user approving the login at the provider; returns the code
+func (idp *testIdP) authorize(t *testing.T, authURL string, claims map[string]interface{}) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, idp.server.URL+"/authorize?") {
+		t.Fatalf("unexpected authorization URL %q", authURL)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" {
+		t.Fatalf("authorization URL without PKCE or nonce: %q", authURL)
+	}
+	code := randomToken()
+	idp.mu.Lock()
+	idp.codes[code] = testAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), claims: claims}
+	idp.mu.Unlock()
+	return code
+}
+
+// oidcTestSetup: Fresh database, a registered provider backed by the test IdP and the forum routes
+func oidcTestSetup(t *testing.T) (*testIdP, http.Handler) {
+	t.Helper()
+	openTestDB(t)
+	idp := newTestIdP(t)
+	RegisterLoginProvider(NewOIDCProvider(core.OIDCProviderConfig{
+		Name:        "test",
+		DisplayName: "Test IdP",
+		Issuer:      idp.server.URL,
+		ClientID:    idp.clientID,
+		RedirectURL: "http://forum.test/api/auth/oidc/test/callback",
+		Scopes:      []string{"openid", "email", "profile"},
+	}))
+	t.Cleanup(func() {
+		loginProvidersMu.Lock()
+		delete(loginProviders, "test")
+		loginProvidersMu.Unlock()
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/auth/oidc/{provider}/login", OIDCLoginHandler)
+	mux.HandleFunc("GET /api/auth/oidc/{provider}/callback", OIDCCallbackHandler)
+	return idp, mux
+}
+
+// startOIDCLogin: Runs the login handler and returns the provider URL and the state cookie
+func startOIDCLogin(t *testing.T, forum http.Handler) (string, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	forum.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/test/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status %d, want 302", rec.Code)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oauthStateCookie {
+			return rec.Header().Get("Location"), c
+		}
+	}
+	t.Fatal("login: no state cookie")
+	return "", nil
+}
+
+// oidcCallback: Calls the callback as the browser coming back from the provider would
+func oidcCallback(forum http.Handler, state, code string, stateCookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet,
+		"/api/auth/oidc/test/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil)
+	if stateCookie != nil {
+		req.AddCookie(stateCookie)
+	}
+	rec := httptest.NewRecorder()
+	forum.ServeHTTP(rec, req)
+	return rec
+}
+
+func sessionCookieFrom(rec *httptest.ResponseRecorder) string {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == SessionCookieName {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func assertLoginError(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if loc := rec.Header().Get("Location"); rec.Code != http.StatusFound || !strings.Contains(loc, "sso_error=") {
+		t.Fatalf("callback: status %d, Location %q; want a redirect with sso_error", rec.Code, loc)
+	}
+	if sessionCookieFrom(rec) != "" {
+		t.Fatal("callback issued a session cookie on failure")
+	}
+}
+
+func newUserClaims(subject, email string) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":                subject,
+		"email":              email,
+		"email_verified":     true,
+		"given_name":         "Carol",
+		"family_name":        "Doe",
+		"preferred_username": "carol",
+	}
+}
+
+func TestOIDCCallbackProvisionsUser(t *testing.T) {
+	idp, forum := oidcTestSetup(t)
+	authURL, stateCookie := startOIDCLogin(t, forum)
+	code := idp.authorize(t, authURL, newUserClaims("sub-carol", "carol@example.com"))
+
+	rec := oidcCallback(forum, stateCookie.Value, code, stateCookie)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/#sso-complete" {
+		t.Fatalf("callback: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	sessionID := sessionCookieFrom(rec)
+	if sessionID == "" {
+		t.Fatal("callback did not set a session cookie")
+	}
+
+	var userID, nickname string
+	err := core.Db.QueryRow(`SELECT u.user_id, u.nickname FROM users u
+		JOIN user_identities i ON i.user_id = u.user_id WHERE i.provider = 'test' AND i.subject = 'sub-carol'`).Scan(&userID, &nickname)
+	if err != nil {
+		t.Fatalf("provisioned user: %v", err)
+	}
+	if nickname != "carol" {
+		t.Fatalf("nickname = %q, want carol", nickname)
+	}
+	var sessionUser string
+	core.Db.QueryRow("SELECT user_id FROM sessions WHERE session_id = ?", sessionID).Scan(&sessionUser)
+	if sessionUser != userID {
+		t.Fatalf("session belongs to %q, want %q", sessionUser, userID)
+	}
+
+	// The same subject signs in to the same account next time
+	authURL, stateCookie = startOIDCLogin(t, forum)
+	code = idp.authorize(t, authURL, newUserClaims("sub-carol", "carol@example.com"))
+	if rec := oidcCallback(forum, stateCookie.Value, code, stateCookie); sessionCookieFrom(rec) == "" {
+		t.Fatal("second login did not set a session cookie")
+	}
+	var users int
+	core.Db.QueryRow("SELECT COUNT(*) FROM users WHERE user_id != ?", core.DeletedUserID).Scan(&users)
+	if users != 1 {
+		t.Fatalf("users after two logins = %d, want 1", users)
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	idp, forum := oidcTestSetup(t)
+
+	t.Run("cookie mismatch", func(t *testing.T) {
+		authURL, stateCookie := startOIDCLogin(t, forum)
+		code := idp.authorize(t, authURL, newUserClaims("sub-carol", "carol@example.com"))
+		other := &http.Cookie{Name: oauthStateCookie, Value: randomToken()}
+		assertLoginError(t, oidcCallback(forum, stateCookie.Value, code, other))
+	})
+	t.Run("no cookie", func(t *testing.T) {
+		authURL, stateCookie := startOIDCLogin(t, forum)
+		code := idp.authorize(t, authURL, newUserClaims("sub-carol", "carol@example.com"))
+		assertLoginError(t, oidcCallback(forum, stateCookie.Value, code, nil))
+	})
+	t.Run("replayed", func(t *testing.T) {
+		authURL, stateCookie := startOIDCLogin(t, forum)
+		code := idp.authorize(t, authURL, newUserClaims("sub-carol", "carol@example.com"))
+		if rec := oidcCallback(forum, stateCookie.Value, code, stateCookie); sessionCookieFrom(rec) == "" {
+			t.Fatalf("first callback failed: Location %q", rec.Header().Get("Location"))
+		}
+		code = idp.authorize(t, authURL, newUserClaims("sub-carol", "carol@example.com"))
+		assertLoginError(t, oidcCallback(forum, stateCookie.Value, code, stateCookie))
+	})
+	t.Run("expired", func(t *testing.T) {
+		authURL, stateCookie := startOIDCLogin(t, forum)
+		code := idp.authorize(t, authURL, newUserClaims("sub-carol", "carol@example.com"))
+		core.Db.Exec("UPDATE oauth_states SET expires_at = ? WHERE state = ?", time.Now().Add(-time.Second), stateCookie.Value)
+		assertLoginError(t, oidcCallback(forum, stateCookie.Value, code, stateCookie))
+	})
+}
+
+func TestOIDCCallbackPKCE(t *testing.T) {
+	idp, forum := oidcTestSetup(t)
+	authURL, stateCookie := startOIDCLogin(t, forum)
+	code := idp.authorize(t, authURL, newUserClaims("sub-carol", "carol@example.com"))
+
+	// A verifier that does not hash to the challenge sent with the authorization request
+	core.Db.Exec("UPDATE oauth_states SET code_verifier = ? WHERE state = ?", randomToken(), stateCookie.Value)
+	assertLoginError(t, oidcCallback(forum, stateCookie.Value, code, stateCookie))
+}
+
+func TestOIDCCallbackIDTokenVerification(t *testing.T) {
+	idp, forum := oidcTestSetup(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		claims map[string]interface{}
+		sign   *rsa.PrivateKey
+	}{
+		{"signed with an unpublished key", newUserClaims("sub-carol", "carol@example.com"), otherKey},
+		{"wrong audience", withClaim(newUserClaims("sub-carol", "carol@example.com"), "aud", "someone-else"), nil},
+		{"wrong issuer", withClaim(newUserClaims("sub-carol", "carol@example.com"), "iss", "https://evil.example"), nil},
+		{"expired", withClaim(newUserClaims("sub-carol", "carol@example.com"), "exp", time.Now().Add(-time.Minute).Unix()), nil},
+		{"wrong nonce", withClaim(newUserClaims("sub-carol", "carol@example.com"), "nonce", "replayed"), nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			idp.mu.Lock()
+			idp.signWith = tc.sign
+			idp.mu.Unlock()
+			authURL, stateCookie := startOIDCLogin(t, forum)
+			code := idp.authorize(t, authURL, tc.claims)
+			assertLoginError(t, oidcCallback(forum, stateCookie.Value, code, stateCookie))
+		})
+	}
+	var users int
+	core.Db.QueryRow("SELECT COUNT(*) FROM users WHERE user_id != ?", core.DeletedUserID).Scan(&users)
+	if users != 0 {
+		t.Fatalf("users after rejected tokens = %d, want 0", users)
+	}
+}
+
+func withClaim(claims map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	claims[key] = value
+	return claims
+}
+
+func TestOIDCCallbackLinksVerifiedEmail(t *testing.T) {
+	idp, forum := oidcTestSetup(t)
+	userID := insertTestUser(t, "alice")
+
+	// An unverified email must not take over the existing account
+	authURL, stateCookie := startOIDCLogin(t, forum)
+	code := idp.authorize(t, authURL, withClaim(newUserClaims("sub-alice", "alice@example.com"), "email_verified", false))
+	assertLoginError(t, oidcCallback(forum, stateCookie.Value, code, stateCookie))
+
+	// "true" as a string is accepted like true
+	authURL, stateCookie = startOIDCLogin(t, forum)
+	code = idp.authorize(t, authURL, withClaim(newUserClaims("sub-alice", "alice@example.com"), "email_verified", "true"))
+	rec := oidcCallback(forum, stateCookie.Value, code, stateCookie)
+	if sessionCookieFrom(rec) == "" {
+		t.Fatalf("verified email did not sign in: Location %q", rec.Header().Get("Location"))
+	}
+	var linked string
+	core.Db.QueryRow("SELECT user_id FROM user_identities WHERE provider = 'test' AND subject = 'sub-alice'").Scan(&linked)
+	if linked != userID {
+		t.Fatalf("identity linked to %q, want %q", linked, userID)
+	}
+}
+
+func TestOIDCCallbackRequiresSecondFactor(t *testing.T) {
+	idp, forum := oidcTestSetup(t)
+	userID := insertTestUser(t, "alice")
+	key := enableTestTOTP(t, userID)
+
+	authURL, stateCookie := startOIDCLogin(t, forum)
+	code := idp.authorize(t, authURL, newUserClaims("sub-alice", "alice@example.com"))
+	rec := oidcCallback(forum, stateCookie.Value, code, stateCookie)
+	if sessionCookieFrom(rec) != "" {
+		t.Fatal("callback issued a session for a 2FA account")
+	}
+	if loc := rec.Header().Get("Location"); loc != "/?sso_2fa=1#login" {
+		t.Fatalf("callback: Location %q, want the code form without the token", loc)
+	}
+	var pending *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == pendingLoginCookie {
+			pending = c
+		}
+	}
+	if pending == nil || pending.Value == "" || !pending.HttpOnly || pending.SameSite != http.SameSiteStrictMode ||
+		pending.Path != "/api/auth/login/2fa" || pending.MaxAge <= 0 {
+		t.Fatalf("pending login cookie = %+v, want a short-lived HttpOnly Strict cookie for the 2FA step", pending)
+	}
+
+	// The SPA posts only the code; the token comes from the cookie
+	login2FA := func(code string) *httptest.ResponseRecorder {
+		body := strings.NewReader(`{"code": "` + code + `"}`)
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login/2fa", body)
+		req.AddCookie(pending)
+		rec := httptest.NewRecorder()
+		Login2FAHandler(rec, req)
+		return rec
+	}
+	if rec := login2FA(totpCode(key, time.Now().Unix()/totpPeriod-5)); rec.Code != http.StatusUnauthorized || sessionCookieFrom(rec) != "" {
+		t.Fatalf("wrong code: status %d", rec.Code)
+	}
+	rec = login2FA(totpCode(key, time.Now().Unix()/totpPeriod))
+	if rec.Code != http.StatusOK || sessionCookieFrom(rec) == "" {
+		t.Fatalf("valid code: status %d, body %s", rec.Code, rec.Body)
+	}
+	var sessionUser string
+	core.Db.QueryRow("SELECT user_id FROM sessions WHERE session_id = ?", sessionCookieFrom(rec)).Scan(&sessionUser)
+	if sessionUser != userID {
+		t.Fatalf("session belongs to %q, want %q", sessionUser, userID)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == pendingLoginCookie && c.MaxAge >= 0 {
+			t.Fatal("pending login cookie was not cleared after the login")
+		}
+	}
+}
+
+func TestGenerateNicknamePassesValidation(t *testing.T) {
+	openTestDB(t)
+	var data UserPayload
+	data.User.FirstName, data.User.LastName = "Carol", "Doe"
+
+	for _, username := range []string{"carol", "0123456789012345abcd", "123", "a.b_c-d", "ééé1234567890123456", ""} {
+		nickname, err := generateNickname(ExternalIdentity{PreferredUsername: username, Email: "x@example.com"}, data)
+		if err != nil {
+			t.Fatalf("%q: %v", username, err)
+		}
+		data.User.Nickname = nickname
+		if err := ValidateNamesAndNickname(data); err != nil || len(nickname) > 15 {
+			t.Fatalf("%q -> %q: %v (%d bytes)", username, nickname, err, len(nickname))
+		}
+	}
+}
+
+func TestOIDCCallbackProvisionsMostlyDigitsUsername(t *testing.T) {
+	idp, forum := oidcTestSetup(t)
+	authURL, stateCookie := startOIDCLogin(t, forum)
+	code := idp.authorize(t, authURL, withClaim(newUserClaims("sub-digits", "digits@example.com"), "preferred_username", "0123456789012345abcd"))
+
+	rec := oidcCallback(forum, stateCookie.Value, code, stateCookie)
+	if sessionCookieFrom(rec) == "" {
+		t.Fatalf("callback: Location %q, want a signed-in user", rec.Header().Get("Location"))
+	}
+	var nickname string
+	core.Db.QueryRow("SELECT u.nickname FROM users u JOIN user_identities i ON i.user_id = u.user_id WHERE i.subject = 'sub-digits'").Scan(&nickname)
+	if !strings.HasPrefix(nickname, "user") {
+		t.Fatalf("nickname = %q, want the user prefix", nickname)
+	}
+}
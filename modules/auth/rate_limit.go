@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+// tokenBucket: Classic token bucket; tokens refill continuously up to the burst size
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  core.RateLimit
+}
+
+func newTokenBucket(limit core.RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{tokens: float64(limit.Burst), last: now, limit: limit}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// wait: Time until one token is available (0 if one is available now)
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	if b.limit.Rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take() { b.tokens-- }
+
+// full: An untouched bucket carries no state worth keeping
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+// userBuckets: Per-user buckets shared by every connection of the user
+var (
+	userBuckets   = make(map[string]map[string]*tokenBucket)
+	userBucketsMu sync.Mutex
+)
+
+// maxTrackedUsers: Size above which idle user buckets are swept
+const maxTrackedUsers = 1024
+
+// connLimiter: Rate-limit state of one connection (used only by its read loop)
+type connLimiter struct {
+	buckets map[string]*tokenBucket
+	strikes []time.Time
+}
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// allow: Checks the connection bucket and, once logged in, the user bucket for msgType
+// A message consumes a token from both or from neither
+func (l *connLimiter) allow(userID, msgType string) (time.Duration, bool) {
+	limit, ok := core.AppConfig.WSRateLimits[msgType]
+	if !ok {
+		return 0, true
+	}
+	now := time.Now()
+
+	connBucket, ok := l.buckets[msgType]
+	if !ok {
+		connBucket = newTokenBucket(limit.PerConnection, now)
+		l.buckets[msgType] = connBucket
+	}
+	if wait := connBucket.wait(now); wait > 0 {
+		return wait, false
+	}
+	if userID == "" {
+		connBucket.take()
+		return 0, true
+	}
+
+	userBucketsMu.Lock()
+	defer userBucketsMu.Unlock()
+	if len(userBuckets) >= maxTrackedUsers {
+		sweepUserBuckets(now)
+	}
+	buckets, ok := userBuckets[userID]
+	if !ok {
+		buckets = make(map[string]*tokenBucket)
+		userBuckets[userID] = buckets
+	}
+	userBucket, ok := buckets[msgType]
+	if !ok {
+		userBucket = newTokenBucket(limit.PerUser, now)
+		buckets[msgType] = userBucket
+	}
+	if wait := userBucket.wait(now); wait > 0 {
+		return wait, false
+	}
+	connBucket.take()
+	userBucket.take()
+	return 0, true
+}
+
+// strike: Records a rejected message; true once the connection has too many in the window
+func (l *connLimiter) strike() bool {
+	now := time.Now()
+	cutoff := now.Add(-core.AppConfig.WSRateLimitStrikeWindow)
+	kept := l.strikes[:0]
+	for _, t := range l.strikes {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	l.strikes = append(kept, now)
+	return len(l.strikes) >= core.AppConfig.WSRateLimitStrikes
+}
+
+// sweepUserBuckets: Drops users whose buckets have all refilled (caller holds userBucketsMu)
+func sweepUserBuckets(now time.Time) {
+	for userID, buckets := range userBuckets {
+		idle := true
+		for _, b := range buckets {
+			if !b.full(now) {
+				idle = false
+				break
+			}
+		}
+		if idle {
+			delete(userBuckets, userID)
+		}
+	}
+}
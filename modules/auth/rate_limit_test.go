@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	b := newTokenBucket(core.RateLimit{Rate: 2, Burst: 3}, start)
+
+	for i := 0; i < 3; i++ {
+		if wait := b.wait(start); wait != 0 {
+			t.Fatalf("token %d of the burst: wait %v, want 0", i+1, wait)
+		}
+		b.take()
+	}
+	if wait := b.wait(start); wait != 500*time.Millisecond {
+		t.Fatalf("empty bucket: wait %v, want 500ms at 2 tokens/s", wait)
+	}
+
+	// A quarter second refills half a token
+	if wait := b.wait(start.Add(250 * time.Millisecond)); wait != 250*time.Millisecond {
+		t.Fatalf("half a token: wait %v, want 250ms", wait)
+	}
+	if wait := b.wait(start.Add(500 * time.Millisecond)); wait != 0 {
+		t.Fatalf("after 500ms: wait %v, want 0", wait)
+	}
+	b.take()
+	if b.full(start.Add(500 * time.Millisecond)) {
+		t.Fatal("bucket reported full right after a take")
+	}
+
+	// Refill stops at the burst size however long the bucket sat idle
+	later := start.Add(time.Hour)
+	if !b.full(later) {
+		t.Fatal("bucket not full after an hour")
+	}
+	for i := 0; i < 3; i++ {
+		b.take()
+	}
+	if wait := b.wait(later); wait == 0 {
+		t.Fatal("idle bucket refilled past its burst")
+	}
+}
+
+func TestTokenBucketWithoutRate(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(core.RateLimit{Rate: 0, Burst: 1}, now)
+	b.take()
+	if wait := b.wait(now.Add(time.Minute)); wait != time.Hour {
+		t.Fatalf("bucket without refill: wait %v, want 1h", wait)
+	}
+}
+
+// withTestRateLimit: Registers a limit for a message type used only by the test
+func withTestRateLimit(t *testing.T, msgType string, limit core.WSMessageLimit) {
+	t.Helper()
+	core.AppConfig.WSRateLimits[msgType] = limit
+	t.Cleanup(func() {
+		delete(core.AppConfig.WSRateLimits, msgType)
+		userBucketsMu.Lock()
+		userBuckets = make(map[string]map[string]*tokenBucket)
+		userBucketsMu.Unlock()
+	})
+}
+
+func TestConnLimiterSharesUserBucket(t *testing.T) {
+	withTestRateLimit(t, "test_message", core.WSMessageLimit{
+		PerConnection: core.RateLimit{Rate: 0, Burst: 2},
+		PerUser:       core.RateLimit{Rate: 0, Burst: 3},
+	})
+	first, second := newConnLimiter(), newConnLimiter()
+
+	for i := 0; i < 2; i++ {
+		if _, ok := first.allow("user-1", "test_message"); !ok {
+			t.Fatalf("first connection, message %d rejected", i+1)
+		}
+	}
+	if _, ok := first.allow("user-1", "test_message"); ok {
+		t.Fatal("first connection went past its own burst")
+	}
+
+	// The second tab has its own connection bucket but the user has one token left
+	if _, ok := second.allow("user-1", "test_message"); !ok {
+		t.Fatal("second connection rejected while the user had a token left")
+	}
+	if _, ok := second.allow("user-1", "test_message"); ok {
+		t.Fatal("second connection went past the user's burst")
+	}
+	// A rejection by the user bucket must not use up the connection token
+	if tokens := second.buckets["test_message"].tokens; tokens != 1 {
+		t.Fatalf("second connection tokens = %v, want 1", tokens)
+	}
+
+	// Other users and message types without a limit are unaffected
+	if _, ok := second.allow("user-2", "test_message"); !ok {
+		t.Fatal("another user was rejected")
+	}
+	if _, ok := first.allow("user-1", "unlimited_type"); !ok {
+		t.Fatal("message type without a limit was rejected")
+	}
+}
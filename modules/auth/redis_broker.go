@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisBroker: Broker over Redis pub/sub (works with any server speaking RESP2: Redis,
+// Valkey, KeyDB, Dragonfly). One connection publishes, a second one stays subscribed
+// and is re-established with backoff when it drops
+type RedisBroker struct {
+	addr     string
+	password string
+	useTLS   bool
+	channel  string
+
+	pubMu   sync.Mutex
+	pubConn *respConn
+
+	subMu   sync.Mutex
+	subConn *respConn
+	done    chan struct{}
+	closed  sync.Once
+}
+
+// maxBulkLen: Largest bulk string accepted from the server (a chat message envelope is a few KB)
+const maxBulkLen = 8 << 20
+
+func NewRedisBroker(u *url.URL, channel string) *RedisBroker {
+	password, _ := u.User.Password()
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	return &RedisBroker{
+		addr:     addr,
+		password: password,
+		useTLS:   u.Scheme == "rediss",
+		channel:  channel,
+		done:     make(chan struct{}),
+	}
+}
+
+// Publish: PUBLISH on the shared connection, redialing once if it went away
+func (b *RedisBroker) Publish(env Envelope) error {
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.pubConn == nil {
+			if b.pubConn, err = b.dial(); err != nil {
+				return err
+			}
+		}
+		if _, err = b.pubConn.do("PUBLISH", b.channel, string(payload)); err == nil {
+			return nil
+		}
+		b.pubConn.Close()
+		b.pubConn = nil
+	}
+	return err
+}
+
+// Subscribe: Starts the subscriber loop; handler runs on its goroutine
+func (b *RedisBroker) Subscribe(handler func(Envelope)) error {
+	go func() {
+		backoff := time.Second
+		for {
+			started := time.Now()
+			err := b.subscribeOnce(handler)
+			select {
+			case <-b.done:
+				return
+			default:
+			}
+			if time.Since(started) > time.Minute {
+				backoff = time.Second // it was healthy for a while
+			}
+			slog.Warn("Broker subscription lost, retrying", "retry_in", backoff, "err", err)
+			select {
+			case <-b.done:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *RedisBroker) subscribeOnce(handler func(Envelope)) error {
+	conn, err := b.dial()
+	if err != nil {
+		return err
+	}
+	b.subMu.Lock()
+	b.subConn = conn
+	b.subMu.Unlock()
+	defer conn.Close()
+
+	if err := conn.send("SUBSCRIBE", b.channel); err != nil {
+		return err
+	}
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		// Pushed messages look like ["message", channel, payload]
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 {
+			continue
+		}
+		if kind, _ := parts[0].(string); kind != "message" {
+			continue // "subscribe" confirmation
+		}
+		payload, _ := parts[2].(string)
+		var env Envelope
+		if err := json.Unmarshal([]byte(payload), &env); err != nil {
+			continue
+		}
+		handler(env)
+	}
+}
+
+func (b *RedisBroker) Close() error {
+	b.closed.Do(func() {
+		close(b.done)
+		b.subMu.Lock()
+		if b.subConn != nil {
+			b.subConn.Close()
+		}
+		b.subMu.Unlock()
+		b.pubMu.Lock()
+		if b.pubConn != nil {
+			b.pubConn.Close()
+			b.pubConn = nil
+		}
+		b.pubMu.Unlock()
+	})
+	return nil
+}
+
+// dial: Opens a connection and authenticates it
+func (b *RedisBroker) dial() (*respConn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 15 * time.Second}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if b.useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", b.addr, &tls.Config{ServerName: hostOnly(b.addr)})
+	} else {
+		conn, err = dialer.Dial("tcp", b.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis dial: %w", err)
+	}
+	rc := &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	if b.password != "" {
+		if _, err := rc.do("AUTH", b.password); err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	return rc, nil
+}
+
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// respConn: Minimal RESP2 client - enough for AUTH, PUBLISH and SUBSCRIBE
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// redisError: "-ERR ..." reply
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (c *respConn) Close() error { return c.conn.Close() }
+
+// do: Sends a command and waits (bounded) for its reply
+func (c *respConn) do(args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if rerr, ok := reply.(redisError); ok {
+		return nil, rerr
+	}
+	return reply, nil
+}
+
+// send: Writes a command as an array of bulk strings
+func (c *respConn) send(args ...string) error {
+	c.w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		c.w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		c.w.WriteString(arg)
+		c.w.WriteString("\r\n")
+	}
+	return c.w.Flush()
+}
+
+// read: Parses one reply: simple string, error, integer, bulk string or array
+func (c *respConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	prefix, body := line[0], line[1:len(line)-2]
+
+	switch prefix {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n > maxBulkLen {
+			return nil, errors.New("redis: bad bulk length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n > 1024 {
+			return nil, errors.New("redis: bad array length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", prefix)
+	}
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"real-time-forum/modules/audit"
+	"real-time-forum/modules/core"
+	"real-time-forum/modules/metrics"
+)
+
+// SessionMeta: Request context and login options captured when a session is issued
+type SessionMeta struct {
+	DeviceLabel string
+	UserAgent   string
+	IPAddress   string
+	RememberMe  bool
+}
+
+// sessionTimes: Expiry bookkeeping of a stored session
+type sessionTimes struct {
+	expiresAt         time.Time
+	absoluteExpiresAt sql.NullTime
+	lastUsedAt        sql.NullTime
+	createdAt         time.Time
+	rememberMe        bool
+}
+
+// SessionInfo: Public view of one of the user's active sessions (session management UI)
+// ID is a hash of the token: the raw session ID never reaches JavaScript
+type SessionInfo struct {
+	ID          string    `json:"id"`
+	DeviceLabel string    `json:"device_label"`
+	UserAgent   string    `json:"user_agent"`
+	IPAddress   string    `json:"ip_address"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Current     bool      `json:"current"`
+}
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionExpired  = errors.New("session expired")
+)
+
+// SessionMetaFromRequest: Extracts user agent and client IP from the HTTP request
+func SessionMetaFromRequest(r *http.Request) SessionMeta {
+	return SessionMeta{
+		UserAgent: r.UserAgent(),
+		IPAddress: clientIP(r),
+	}
+}
+
+// clientIP: Remote address without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// deviceLabelFromUserAgent: Builds a short human-readable label like "Firefox on Linux"
+func deviceLabelFromUserAgent(ua string) string {
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"), strings.Contains(ua, "Opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	}
+
+	platform := "unknown device"
+	switch {
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		platform = "iOS"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		platform = "macOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+
+	return fmt.Sprintf("%s on %s", browser, platform)
+}
+
+// sessionLimits: Idle timeout and absolute lifetime for regular / "remember me" sessions
+func sessionLimits(rememberMe bool) (time.Duration, time.Duration) {
+	if rememberMe {
+		return core.AppConfig.RememberMeIdleTimeout, core.AppConfig.RememberMeMaxLifetime
+	}
+	return core.AppConfig.SessionIdleTimeout, core.AppConfig.SessionMaxLifetime
+}
+
+// slideSession: Pushes expires_at forward by the idle timeout, capped at the absolute lifetime
+// Skipped when the session was refreshed less than SessionRefreshInterval ago, unless forced
+func slideSession(sessionID string, t sessionTimes, force bool) time.Time {
+	now := time.Now()
+	lastUsed := t.createdAt
+	if t.lastUsedAt.Valid {
+		lastUsed = t.lastUsedAt.Time
+	}
+	if !force && now.Sub(lastUsed) < core.AppConfig.SessionRefreshInterval {
+		return t.expiresAt
+	}
+
+	idle, maxLifetime := sessionLimits(t.rememberMe)
+	absolute := t.createdAt.Add(maxLifetime)
+	if t.absoluteExpiresAt.Valid {
+		absolute = t.absoluteExpiresAt.Time
+	}
+	expiresAt := now.Add(idle)
+	if expiresAt.After(absolute) {
+		expiresAt = absolute
+	}
+
+	_, err := core.Db.Exec("UPDATE sessions SET expires_at = ?, last_used_at = ? WHERE session_id = ?", expiresAt, now, sessionID)
+	if err != nil {
+		slog.Warn("Failed to refresh session", "session_id", sessionID, "err", err)
+		return t.expiresAt
+	}
+	return expiresAt
+}
+
+// loadSessionTimes: Reads the expiry bookkeeping of a session, deleting it if already expired
+func loadSessionTimes(sessionID string) (sessionTimes, error) {
+	var t sessionTimes
+	err := core.Db.QueryRow(`
+		SELECT expires_at, absolute_expires_at, last_used_at, created_at, remember_me
+		FROM sessions WHERE session_id = ?`, sessionID).
+		Scan(&t.expiresAt, &t.absoluteExpiresAt, &t.lastUsedAt, &t.createdAt, &t.rememberMe)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return t, ErrSessionNotFound
+		}
+		return t, err
+	}
+	if time.Now().After(t.expiresAt) {
+		deleteExpiredSession(sessionID)
+		return t, ErrSessionExpired
+	}
+	return t, nil
+}
+
+// RefreshSession: Records activity on a live session and returns its new expiry
+// force bypasses the refresh throttle (explicit "stay signed in" from the client)
+func RefreshSession(sessionID string, force bool) (time.Time, error) {
+	t, err := loadSessionTimes(sessionID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return slideSession(sessionID, t, force), nil
+}
+
+// SessionExpiry: Current expiry of a live session, without extending it
+func SessionExpiry(sessionID string) (time.Time, error) {
+	t, err := loadSessionTimes(sessionID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.expiresAt, nil
+}
+
+// deleteExpiredSession: Removes a session that was found past its expiry
+func deleteExpiredSession(sessionID string) {
+	forgetSessions(sessionID)
+	res, err := core.Db.Exec("DELETE FROM sessions WHERE session_id = ?", sessionID)
+	if err != nil {
+		slog.Warn("Failed to delete expired session", "session_id", sessionID, "err", err)
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		metrics.SessionsCleaned.Add(float64(n))
+	}
+}
+
+// ListSessions: Returns the user's non-expired sessions, most recently used first
+func ListSessions(userID, currentSessionID string) ([]SessionInfo, error) {
+	rows, err := core.Db.Query(`
+		SELECT session_id, device_label, user_agent, ip_address, created_at,
+		       last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY COALESCE(last_used_at, created_at) DESC`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []SessionInfo{}
+	for rows.Next() {
+		var s SessionInfo
+		var sessionID string
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&sessionID, &s.DeviceLabel, &s.UserAgent, &s.IPAddress,
+			&s.CreatedAt, &lastUsedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		s.LastUsedAt = s.CreatedAt
+		if lastUsedAt.Valid {
+			s.LastUsedAt = lastUsedAt.Time
+		}
+		s.ID = publicSessionID(sessionID)
+		s.Current = sessionID == currentSessionID
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// publicSessionID: Non-secret handle for a session, safe to show in the UI
+func publicSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// RevokeSession: Deletes one of the user's sessions by its public ID; users can only revoke their own
+// Returns the raw session ID so its live connections can be closed
+func RevokeSession(userID, publicID string) (string, error) {
+	rows, err := core.Db.Query("SELECT session_id FROM sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return "", err
+	}
+	var sessionID string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return "", err
+		}
+		if publicSessionID(id) == publicID {
+			sessionID = id
+			break
+		}
+	}
+	rows.Close()
+	if sessionID == "" {
+		return "", ErrSessionNotFound
+	}
+
+	_, err = core.Db.Exec("DELETE FROM sessions WHERE session_id = ? AND user_id = ?", sessionID, userID)
+	if err != nil {
+		return "", err
+	}
+	forgetSessions(sessionID)
+	audit.Record(audit.Event{ActorID: userID, Action: "session_revoked", Target: "session:" + publicID})
+	return sessionID, nil
+}
+
+// RevokeOtherSessions: Deletes every session of the user except the current one
+// Returns the revoked session IDs so their live connections can be closed
+func RevokeOtherSessions(userID, currentSessionID string) ([]string, error) {
+	rows, err := core.Db.Query("SELECT session_id FROM sessions WHERE user_id = ? AND session_id != ?", userID, currentSessionID)
+	if err != nil {
+		return nil, err
+	}
+	var revoked []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		revoked = append(revoked, id)
+	}
+	rows.Close()
+
+	_, err = core.Db.Exec("DELETE FROM sessions WHERE user_id = ? AND session_id != ?", userID, currentSessionID)
+	if err != nil {
+		return nil, err
+	}
+	forgetSessions(revoked...)
+	if len(revoked) > 0 {
+		audit.Record(audit.Event{ActorID: userID, Action: "sessions_revoked", Target: "user:" + userID,
+			Details: map[string]interface{}{"count": len(revoked)}})
+	}
+	return revoked, nil
+}
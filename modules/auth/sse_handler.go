@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"real-time-forum/modules/core"
+
+	"github.com/gorilla/websocket"
+)
+
+// StreamIDHeader: Identifies the event stream a POST /api/events/send belongs to
+// The ID is only ever sent down that stream, so it also proves the sender owns it
+const StreamIDHeader = "X-Stream-ID"
+
+// eventStream: Server-Sent Events fallback for clients whose proxies break WebSocket upgrades
+// Same messages and WSResponse envelope as /ws: events go down the stream, client messages
+// come in as POSTs and run through the same wsConn dispatch
+type eventStream struct {
+	mu     sync.Mutex // dispatch is sequential, like a WebSocket read loop
+	wc     *wsConn
+	closed bool
+}
+
+var (
+	eventStreams   = make(map[string]*eventStream)
+	eventStreamsMu sync.Mutex
+)
+
+// EventStreamHandler: GET /api/events - opens the stream
+// The first event is "stream_open" carrying the stream ID; connection-level failures end
+// the stream with a "close" event holding the same code a WebSocket close frame would
+func EventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteJSONError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	var cookieSessionID string
+	if cookie, err := r.Cookie(SessionCookieName); err == nil && sameOrigin(r) {
+		cookieSessionID = cookie.Value
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep buffering proxies from holding events back
+	w.WriteHeader(http.StatusOK)
+
+	client := newClient(nil, clientIP(r))
+	client.logger().Info("ws connected", "transport", "sse", "ip", client.ip)
+	if !originAllowed(r) {
+		client.closeWith(CloseOriginRejected, "origin not allowed")
+		client.ssePump(w, r)
+		return
+	}
+	if !hub.trackIP(client.ip) {
+		client.closeWith(CloseTooManyForIP, "too many connections from this address")
+		client.ssePump(w, r)
+		return
+	}
+	defer hub.untrackIP(client.ip)
+
+	streamID := randomToken()
+	stream := &eventStream{wc: newWSConn(client, r, cookieSessionID)}
+	eventStreamsMu.Lock()
+	eventStreams[streamID] = stream
+	eventStreamsMu.Unlock()
+	defer func() {
+		eventStreamsMu.Lock()
+		delete(eventStreams, streamID)
+		eventStreamsMu.Unlock()
+		stream.mu.Lock()
+		stream.closed = true
+		stream.mu.Unlock()
+		stream.wc.shutdown()
+	}()
+
+	writeResponse(client, "stream_open", "ok", map[string]string{"stream_id": streamID}, "")
+	flusher.Flush()
+	client.ssePump(w, r)
+}
+
+// EventSendHandler: POST /api/events/send - one client message for the stream named in X-Stream-ID
+// Answers 202 right away; the result arrives on the stream like it would over /ws
+func EventSendHandler(w http.ResponseWriter, r *http.Request) {
+	if !originAllowed(r) {
+		WriteJSONError(w, http.StatusForbidden, "Origin not allowed")
+		return
+	}
+	eventStreamsMu.Lock()
+	stream, ok := eventStreams[r.Header.Get(StreamIDHeader)]
+	eventStreamsMu.Unlock()
+	if !ok {
+		WriteJSONError(w, http.StatusNotFound, "Unknown or closed event stream")
+		return
+	}
+
+	var msg ClientWSMessage
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, wsReadLimit)).Decode(&msg)
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		// Same outcome as an oversized WebSocket frame
+		stream.wc.client.closeWith(websocket.CloseMessageTooBig, "message too big")
+		WriteJSONError(w, http.StatusRequestEntityTooLarge, "Message too big")
+		return
+	}
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid message format")
+		return
+	}
+
+	stream.mu.Lock()
+	if !stream.closed {
+		stream.wc.handle(msg) // a connection that must end is already closing its stream
+	}
+	stream.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ssePump: The only writer of an event stream - the SSE counterpart of writePump
+// Comments keep proxies from timing the stream out; a failed write ends it
+func (c *Client) ssePump(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	ticker := time.NewTicker(core.AppConfig.WSPingPeriod)
+	defer ticker.Stop()
+
+	write := func(frame string) error {
+		rc.SetWriteDeadline(time.Now().Add(core.AppConfig.WSWriteWait))
+		if _, err := io.WriteString(w, frame); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	for {
+		select {
+		case msg := <-c.send:
+			if err := write("data: " + string(msg) + "\n\n"); err != nil {
+				c.closeWith(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			if err := write(": ping\n\n"); err != nil {
+				c.closeWith(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-r.Context().Done():
+			c.closeWith(websocket.CloseGoingAway, "")
+			return
+		case <-c.closing:
+			for len(c.send) > 0 {
+				if err := write("data: " + string(<-c.send) + "\n\n"); err != nil {
+					return
+				}
+			}
+			closeEvent, _ := json.Marshal(map[string]interface{}{"code": c.closeCode, "reason": c.closeReason})
+			write(fmt.Sprintf("event: close\ndata: %s\n\n", closeEvent))
+			return
+		}
+	}
+}
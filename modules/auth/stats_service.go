@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+// Bounds of the admin statistics query parameters
+const (
+	DefaultStatsDays  = 30
+	MaxStatsDays      = 365
+	DefaultStatsLimit = 10
+	MaxStatsLimit     = 100
+)
+
+// ForumStats: Admin overview - totals, live connections and the most active categories and users
+type ForumStats struct {
+	Totals          StatsTotals        `json:"totals"`
+	Live            LiveStats          `json:"live"`
+	TopCategories   []CategoryActivity `json:"top_categories"`
+	MostActiveUsers []UserActivity     `json:"most_active_users"`
+	GeneratedAt     time.Time          `json:"generated_at"`
+}
+
+// StatsTotals: Row counts; Reactions covers posts, comments and private messages
+type StatsTotals struct {
+	Users           int `json:"users"`
+	Posts           int `json:"posts"`
+	Comments        int `json:"comments"`
+	Reactions       int `json:"reactions"`
+	PrivateMessages int `json:"private_messages"`
+	ActiveSessions  int `json:"active_sessions"`
+}
+
+// LiveStats: WebSocket and event stream connections of this instance
+type LiveStats struct {
+	ConnectedUsers           int `json:"connected_users"`           // users with a connection on this node
+	AuthenticatedConnections int `json:"authenticated_connections"` // their connections (tabs, devices)
+	Connections              int `json:"connections"`               // all open connections, logged in or not
+	OnlineUsers              int `json:"online_users"`              // cluster-wide presence
+	Nodes                    int `json:"nodes"`                     // instances seen on the backplane
+}
+
+// CategoryActivity: Activity counts the posts of a category, their comments and reactions
+type CategoryActivity struct {
+	CategoryID string `json:"category_id"`
+	Name       string `json:"name"`
+	Posts      int    `json:"posts"`
+	Comments   int    `json:"comments"`
+	Reactions  int    `json:"reactions"`
+	Activity   int    `json:"activity"`
+}
+
+// UserActivity: Everything a user authored or reacted with; Activity is the sum
+type UserActivity struct {
+	UserID          string `json:"user_id"`
+	Nickname        string `json:"nickname"`
+	Posts           int    `json:"posts"`
+	Comments        int    `json:"comments"`
+	Reactions       int    `json:"reactions"`
+	PrivateMessages int    `json:"private_messages"`
+	Activity        int    `json:"activity"`
+}
+
+// StatsDay: One day (UTC) of the time series
+type StatsDay struct {
+	Date            string `json:"date"`
+	Registrations   int    `json:"registrations"`
+	Posts           int    `json:"posts"`
+	Comments        int    `json:"comments"`
+	Reactions       int    `json:"reactions"`
+	PrivateMessages int    `json:"private_messages"`
+}
+
+// GetForumStats: Builds the overview with one aggregate query per section
+// The "deleted user" placeholder is not a member and never shows up
+func GetForumStats(limit int) (*ForumStats, error) {
+	stats := &ForumStats{Live: hub.liveStats(), GeneratedAt: time.Now().UTC()}
+
+	err := core.Db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE user_id != ?),
+			(SELECT COUNT(*) FROM posts),
+			(SELECT COUNT(*) FROM comments),
+			(SELECT COUNT(*) FROM posts_reactions) + (SELECT COUNT(*) FROM comments_reactions) +
+				(SELECT COUNT(*) FROM message_reactions),
+			(SELECT COUNT(*) FROM private_messages),
+			(SELECT COUNT(*) FROM sessions WHERE expires_at > ?)
+	`, core.DeletedUserID, time.Now()).Scan(&stats.Totals.Users, &stats.Totals.Posts, &stats.Totals.Comments,
+		&stats.Totals.Reactions, &stats.Totals.PrivateMessages, &stats.Totals.ActiveSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.TopCategories, err = topCategories(limit); err != nil {
+		return nil, err
+	}
+	if stats.MostActiveUsers, err = mostActiveUsers(limit); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// topCategories: Comments and reactions are counted per post first, so the joins don't multiply rows
+func topCategories(limit int) ([]CategoryActivity, error) {
+	rows, err := core.Db.Query(`
+		WITH post_comments AS (
+			SELECT post_id, COUNT(*) AS n FROM comments GROUP BY post_id
+		), post_reactions AS (
+			SELECT post_id, COUNT(*) AS n FROM posts_reactions GROUP BY post_id
+		)
+		SELECT c.category_id, c.category_name,
+			COUNT(pc.post_id) AS posts,
+			COALESCE(SUM(cm.n), 0) AS comments,
+			COALESCE(SUM(pr.n), 0) AS reactions
+		FROM categories c
+		LEFT JOIN posts_categories pc ON pc.category_id = c.category_id
+		LEFT JOIN post_comments cm ON cm.post_id = pc.post_id
+		LEFT JOIN post_reactions pr ON pr.post_id = pc.post_id
+		GROUP BY c.category_id
+		ORDER BY posts + comments + reactions DESC, c.category_name ASC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []CategoryActivity{}
+	for rows.Next() {
+		var c CategoryActivity
+		if err := rows.Scan(&c.CategoryID, &c.Name, &c.Posts, &c.Comments, &c.Reactions); err != nil {
+			return nil, err
+		}
+		c.Activity = c.Posts + c.Comments + c.Reactions
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+func mostActiveUsers(limit int) ([]UserActivity, error) {
+	rows, err := core.Db.Query(`
+		WITH activity(user_id, posts, comments, reactions, messages) AS (
+			SELECT user_id, 1, 0, 0, 0 FROM posts
+			UNION ALL SELECT user_id, 0, 1, 0, 0 FROM comments
+			UNION ALL SELECT user_id, 0, 0, 1, 0 FROM posts_reactions
+			UNION ALL SELECT user_id, 0, 0, 1, 0 FROM comments_reactions
+			UNION ALL SELECT user_id, 0, 0, 1, 0 FROM message_reactions
+			UNION ALL SELECT sender_id, 0, 0, 0, 1 FROM private_messages
+		)
+		SELECT a.user_id, u.nickname, SUM(a.posts), SUM(a.comments), SUM(a.reactions), SUM(a.messages), COUNT(*) AS total
+		FROM activity a
+		JOIN users u ON u.user_id = a.user_id
+		WHERE a.user_id != ?
+		GROUP BY a.user_id
+		ORDER BY total DESC, u.nickname ASC
+		LIMIT ?
+	`, core.DeletedUserID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []UserActivity{}
+	for rows.Next() {
+		var u UserActivity
+		if err := rows.Scan(&u.UserID, &u.Nickname, &u.Posts, &u.Comments, &u.Reactions, &u.PrivateMessages, &u.Activity); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// statsSeriesQueries: Per-day counts since a date, one query per metric
+// DATETIME columns hold text starting with YYYY-MM-DD in every format the tables contain, so
+// comparing with the start date works as-is; private messages and their reactions store
+// Unix milliseconds instead
+var statsSeriesQueries = []struct {
+	query  string
+	millis bool
+	add    func(day *StatsDay, n int)
+}{
+	{`SELECT date(created_at), COUNT(*) FROM users WHERE created_at >= ? GROUP BY 1`, false, // the placeholder has none
+		func(d *StatsDay, n int) { d.Registrations += n }},
+	{`SELECT date(created_at), COUNT(*) FROM posts WHERE created_at >= ? GROUP BY 1`, false,
+		func(d *StatsDay, n int) { d.Posts += n }},
+	{`SELECT date(created_at), COUNT(*) FROM comments WHERE created_at >= ? GROUP BY 1`, false,
+		func(d *StatsDay, n int) { d.Comments += n }},
+	{`SELECT date(created_at), COUNT(*) FROM posts_reactions WHERE created_at >= ? GROUP BY 1`, false,
+		func(d *StatsDay, n int) { d.Reactions += n }},
+	{`SELECT date(created_at), COUNT(*) FROM comments_reactions WHERE created_at >= ? GROUP BY 1`, false,
+		func(d *StatsDay, n int) { d.Reactions += n }},
+	{`SELECT date(created_at / 1000, 'unixepoch'), COUNT(*) FROM message_reactions WHERE created_at >= ? GROUP BY 1`, true,
+		func(d *StatsDay, n int) { d.Reactions += n }},
+	{`SELECT date(created_at / 1000, 'unixepoch'), COUNT(*) FROM private_messages WHERE created_at >= ? GROUP BY 1`, true,
+		func(d *StatsDay, n int) { d.PrivateMessages += n }},
+}
+
+// GetStatsSeries: Daily counts for the last `days` days (UTC), today included, oldest first
+// Days without activity are present with zeros; rows older than their created_at column
+// (registrations and post/comment reactions before it existed) only count in the totals
+func GetStatsSeries(days int) ([]StatsDay, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	start := today.AddDate(0, 0, -(days - 1))
+
+	series := make([]StatsDay, days)
+	index := make(map[string]*StatsDay, days)
+	for i := range series {
+		series[i].Date = start.AddDate(0, 0, i).Format("2006-01-02")
+		index[series[i].Date] = &series[i]
+	}
+
+	for _, sq := range statsSeriesQueries {
+		args := []interface{}{start.Format("2006-01-02")}
+		if sq.millis {
+			args[0] = start.UnixMilli()
+		}
+		if err := addSeriesCounts(index, sq.add, sq.query, args...); err != nil {
+			return nil, err
+		}
+	}
+	return series, nil
+}
+
+func addSeriesCounts(index map[string]*StatsDay, add func(*StatsDay, int), query string, args ...interface{}) error {
+	rows, err := core.Db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var date *string
+		var n int
+		if err := rows.Scan(&date, &n); err != nil {
+			return err
+		}
+		// Timestamps with a zone can land on the day before the window in UTC
+		if date == nil || index[*date] == nil {
+			continue
+		}
+		add(index[*date], n)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"real-time-forum/modules/audit"
+	"real-time-forum/modules/core"
+)
+
+// MaxSuspensionReasonLength: The reason is shown to the user on login and in the close frame
+const MaxSuspensionReasonLength = 500
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrCannotSuspendSelf  = errors.New("admins cannot suspend their own account")
+	ErrSuspensionReason   = fmt.Errorf("a reason of at most %d characters is required", MaxSuspensionReasonLength)
+	ErrSuspensionExpiry   = errors.New("expires_at must be in the future")
+	ErrNoActiveSuspension = errors.New("user is not suspended")
+)
+
+// Suspension: An admin's restriction of an account; ExpiresAt nil is a permanent ban
+type Suspension struct {
+	UserID    string     `json:"user_id"`
+	Nickname  string     `json:"nickname,omitempty"`
+	Reason    string     `json:"reason"`
+	Permanent bool       `json:"permanent"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Message: What the suspended user is told
+func (s *Suspension) Message() string {
+	if s.Permanent {
+		return "This account has been banned: " + s.Reason
+	}
+	return fmt.Sprintf("This account is suspended until %s: %s", s.ExpiresAt.UTC().Format("2006-01-02 15:04 UTC"), s.Reason)
+}
+
+// AccountSuspendedError: Returned by LoginUser, CreateSession and ResolveSession while
+// the account is suspended or banned
+type AccountSuspendedError struct {
+	Suspension Suspension
+}
+
+func (e *AccountSuspendedError) Error() string {
+	return e.Suspension.Message()
+}
+
+// sessionErrorMessage: What a client is told when CreateSession fails
+func sessionErrorMessage(err error) string {
+	var suspended *AccountSuspendedError
+	if errors.As(err, &suspended) {
+		return err.Error()
+	}
+	return "Cannot create session"
+}
+
+// activeSuspension: The user's current restriction, nil when there is none (or it expired)
+func activeSuspension(userID string) (*Suspension, error) {
+	var s Suspension
+	var expiresAt sql.NullTime
+	err := core.Db.QueryRow(`
+		SELECT user_id, reason, expires_at, created_by, created_at
+		FROM user_suspensions
+		WHERE user_id = ? AND (expires_at IS NULL OR expires_at > ?)
+	`, userID, time.Now()).Scan(&s.UserID, &s.Reason, &expiresAt, &s.CreatedBy, &s.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	setSuspensionExpiry(&s, expiresAt)
+	return &s, nil
+}
+
+// checkNotSuspended: nil, or an *AccountSuspendedError for a restricted account
+func checkNotSuspended(userID string) error {
+	s, err := activeSuspension(userID)
+	if err != nil {
+		return err
+	}
+	if s != nil {
+		return &AccountSuspendedError{Suspension: *s}
+	}
+	return nil
+}
+
+func setSuspensionExpiry(s *Suspension, expiresAt sql.NullTime) {
+	s.Permanent = !expiresAt.Valid
+	if expiresAt.Valid {
+		t := expiresAt.Time
+		s.ExpiresAt = &t
+	}
+}
+
+// SuspendUser: Suspends the user until expiresAt, or bans them for good when it is nil
+// Replaces any earlier restriction, ends every session and unfinished login of the user
+// and returns the revoked session IDs so their live connections can be closed
+func SuspendUser(admin AuthUser, userID, reason string, expiresAt *time.Time) (*Suspension, []string, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || len([]rune(reason)) > MaxSuspensionReasonLength {
+		return nil, nil, ErrSuspensionReason
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, nil, ErrSuspensionExpiry
+	}
+	if userID == admin.ID {
+		return nil, nil, ErrCannotSuspendSelf
+	}
+
+	s := &Suspension{UserID: userID, Reason: reason, Permanent: expiresAt == nil, CreatedBy: admin.ID, CreatedAt: time.Now()}
+	var storedExpiry interface{}
+	if expiresAt != nil {
+		// Same zone as time.Now() so the stored text compares correctly with it
+		local := expiresAt.Local()
+		s.ExpiresAt = &local
+		storedExpiry = local
+	}
+
+	tx, err := core.Db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("SELECT nickname FROM users WHERE user_id = ? AND user_id != ?", userID, core.DeletedUserID).Scan(&s.Nickname)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_suspensions (user_id, reason, expires_at, created_by, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET reason = excluded.reason, expires_at = excluded.expires_at,
+			created_by = excluded.created_by, created_at = excluded.created_at
+	`, userID, reason, storedExpiry, admin.ID, s.CreatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := tx.Query("SELECT session_id FROM sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sessionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+	rows.Close()
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM pending_logins WHERE user_id = ?", userID); err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	forgetSessions(sessionIDs...)
+
+	action, details := "user_banned", map[string]interface{}{"reason": reason}
+	if expiresAt != nil {
+		action, details["expires_at"] = "user_suspended", s.ExpiresAt.UTC()
+	}
+	audit.Record(audit.Event{ActorID: admin.ID, Action: action, Target: "user:" + userID, Details: details})
+	return s, sessionIDs, nil
+}
+
+// LiftSuspension: Ends the user's suspension or ban early
+func LiftSuspension(admin AuthUser, userID string) error {
+	res, err := core.Db.Exec("DELETE FROM user_suspensions WHERE user_id = ? AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNoActiveSuspension
+	}
+	audit.Record(audit.Event{ActorID: admin.ID, Action: "suspension_lifted", Target: "user:" + userID})
+	return nil
+}
+
+// ListSuspensions: Active suspensions and bans, most recent first
+func ListSuspensions() ([]Suspension, error) {
+	rows, err := core.Db.Query(`
+		SELECT s.user_id, u.nickname, s.reason, s.expires_at, s.created_by, s.created_at
+		FROM user_suspensions s
+		JOIN users u ON u.user_id = s.user_id
+		WHERE s.expires_at IS NULL OR s.expires_at > ?
+		ORDER BY s.created_at DESC
+	`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suspensions := []Suspension{}
+	for rows.Next() {
+		var s Suspension
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&s.UserID, &s.Nickname, &s.Reason, &expiresAt, &s.CreatedBy, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		setSuspensionExpiry(&s, expiresAt)
+		suspensions = append(suspensions, s)
+	}
+	return suspensions, rows.Err()
+}
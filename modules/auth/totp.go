@@ -0,0 +1,353 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"real-time-forum/modules/audit"
+	"real-time-forum/modules/core"
+	"real-time-forum/modules/metrics"
+)
+
+// RFC 6238 parameters - the defaults every authenticator app understands
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1 // accept codes one step before/after to absorb clock drift
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrInvalidTOTPCode    = errors.New("invalid verification code")
+	ErrTOTPNotEnrolled    = errors.New("two-factor authentication setup was not started")
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrPendingLogin       = errors.New("login verification expired, please log in again")
+)
+
+// TOTPSetup: Returned when enrollment starts - the secret stays inactive until verified
+type TOTPSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// TOTPStatus: Current 2FA state of an account (profile page)
+type TOTPStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// totpCode: HOTP value (RFC 4226) for the given time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP: Returns the time step the code belongs to, checking +/- totpSkew steps
+func matchTOTP(encodedSecret, code string, now time.Time) (int64, bool) {
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encodedSecret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// provisioningURI: otpauth:// URI understood by authenticator apps (usually shown as a QR code)
+func provisioningURI(secret, accountName string) string {
+	issuer := core.AppConfig.TOTPIssuer
+	label := url.PathEscape(issuer + ":" + accountName)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// BeginTOTPSetup: Generates a new (inactive) secret for the user
+func BeginTOTPSetup(userID string) (TOTPSetup, error) {
+	var nickname string
+	var enabled bool
+	err := core.Db.QueryRow("SELECT nickname, totp_enabled FROM users WHERE user_id = ?", userID).Scan(&nickname, &enabled)
+	if err != nil {
+		return TOTPSetup{}, err
+	}
+	if enabled {
+		return TOTPSetup{}, ErrTOTPAlreadyEnabled
+	}
+
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return TOTPSetup{}, err
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+
+	_, err = core.Db.Exec("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE user_id = ?", secret, userID)
+	if err != nil {
+		return TOTPSetup{}, err
+	}
+	return TOTPSetup{Secret: secret, ProvisioningURI: provisioningURI(secret, nickname)}, nil
+}
+
+// ConfirmTOTPSetup: Activates 2FA once the user proves their app produces valid codes
+// Returns the plaintext recovery codes - they are only ever shown this once
+func ConfirmTOTPSetup(userID, code string) ([]string, error) {
+	var secret string
+	var enabled bool
+	err := core.Db.QueryRow("SELECT totp_secret, totp_enabled FROM users WHERE user_id = ?", userID).Scan(&secret, &enabled)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if secret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	step, ok := matchTOTP(secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := core.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE user_id = ?", step, userID); err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, c := range codes {
+		if _, err = tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashRecoveryCode(c)); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	audit.Record(audit.Event{ActorID: userID, Action: "totp_enabled", Target: "user:" + userID})
+	return codes, nil
+}
+
+// DisableTOTP: Turns 2FA off; requires a current code or an unused recovery code
+func DisableTOTP(userID, code string) error {
+	enabled, err := userHasTOTP(userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTOTPNotEnabled
+	}
+	if !verifySecondFactor(userID, code) {
+		return ErrInvalidTOTPCode
+	}
+
+	tx, err := core.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("UPDATE users SET totp_enabled = 0, totp_secret = '', totp_last_step = 0 WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	audit.Record(audit.Event{ActorID: userID, Action: "totp_disabled", Target: "user:" + userID})
+	return nil
+}
+
+// GetTOTPStatus: Whether 2FA is on and how many recovery codes remain
+func GetTOTPStatus(userID string) (TOTPStatus, error) {
+	var status TOTPStatus
+	err := core.Db.QueryRow(`
+		SELECT u.totp_enabled,
+		       (SELECT COUNT(*) FROM recovery_codes rc WHERE rc.user_id = u.user_id AND rc.used_at IS NULL)
+		FROM users u WHERE u.user_id = ?`, userID).Scan(&status.Enabled, &status.RecoveryCodesLeft)
+	return status, err
+}
+
+func userHasTOTP(userID string) (bool, error) {
+	var enabled bool
+	err := core.Db.QueryRow("SELECT totp_enabled FROM users WHERE user_id = ?", userID).Scan(&enabled)
+	return enabled, err
+}
+
+// verifySecondFactor: Accepts a TOTP code (each time step only once) or a one-time recovery code
+func verifySecondFactor(userID, code string) bool {
+	code = strings.TrimSpace(code)
+
+	var secret string
+	err := core.Db.QueryRow("SELECT totp_secret FROM users WHERE user_id = ?", userID).Scan(&secret)
+	if err != nil {
+		return false
+	}
+
+	if step, ok := matchTOTP(secret, code, time.Now()); ok {
+		// Reject replays of a code that was already used
+		res, err := core.Db.Exec("UPDATE users SET totp_last_step = ? WHERE user_id = ? AND totp_last_step < ?", step, userID, step)
+		if err != nil {
+			return false
+		}
+		n, _ := res.RowsAffected()
+		return n == 1
+	}
+
+	res, err := core.Db.Exec(
+		"UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now(), userID, hashRecoveryCode(code))
+	if err != nil {
+		return false
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		audit.Record(audit.Event{ActorID: userID, Action: "recovery_code_used", Target: "user:" + userID})
+		return true
+	}
+	return false
+}
+
+// generateRecoveryCodes: recoveryCodeCount random codes formatted as xxxxx-xxxxx
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		h := hex.EncodeToString(b)
+		codes = append(codes, h[:5]+"-"+h[5:])
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode: Codes are random (40 bits each), so a fast hash is enough here
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// createPendingLogin: Short-lived token bridging the password step and the 2FA step
+func createPendingLogin(userID string, rememberMe bool) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	_, err := core.Db.Exec(
+		"INSERT INTO pending_logins (token, user_id, remember_me, expires_at) VALUES (?, ?, ?, ?)",
+		token, userID, rememberMe, time.Now().Add(core.AppConfig.PendingLoginTTL))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// CompleteTwoFactorLogin: Second login step - checks the code against the pending token's user
+// Wrong codes count towards the pending token's tries and the account and IP lockouts; the
+// account counter is only reset here, so fresh pending tokens don't grant fresh guesses
+func CompleteTwoFactorLogin(pendingToken, code string, meta SessionMeta) (UserPayload, bool, error) {
+	var userID string
+	var rememberMe bool
+	var attempts int
+	var expiresAt time.Time
+	err := core.Db.QueryRow(
+		"SELECT user_id, remember_me, attempts, expires_at FROM pending_logins WHERE token = ?", pendingToken).
+		Scan(&userID, &rememberMe, &attempts, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserPayload{}, false, ErrPendingLogin
+		}
+		return UserPayload{}, false, err
+	}
+	if time.Now().After(expiresAt) || attempts >= core.AppConfig.PendingLoginMaxTries {
+		core.Db.Exec("DELETE FROM pending_logins WHERE token = ?", pendingToken)
+		return UserPayload{}, false, ErrPendingLogin
+	}
+
+	accountKey, ipKey := "user:"+userID, ipAttemptKey(meta.IPAddress)
+	if retryAfter, locked := loginLockedFor(accountKey, ipKey); locked {
+		metrics.Logins.Inc("failure", "locked")
+		return UserPayload{}, false, &LoginLockedError{RetryAfter: retryAfter}
+	}
+
+	if !verifySecondFactor(userID, code) {
+		core.Db.Exec("UPDATE pending_logins SET attempts = attempts + 1 WHERE token = ?", pendingToken)
+		recordLoginFailure(accountKey, core.AppConfig.LoginMaxAccountFailures, meta)
+		recordLoginFailure(ipKey, core.AppConfig.LoginMaxIPFailures, meta)
+		recordLoginEvent("login_failed", userID, "", meta, "invalid_second_factor")
+		return UserPayload{}, false, ErrInvalidTOTPCode
+	}
+	core.Db.Exec("DELETE FROM pending_logins WHERE token = ?", pendingToken)
+	clearLoginFailures(accountKey)
+
+	var user UserPayload
+	err = core.Db.QueryRow(
+		`SELECT user_id, first_name, last_name, nickname, age, gender, email, role FROM users WHERE user_id = ?`, userID).
+		Scan(&user.User.UserID, &user.User.FirstName, &user.User.LastName, &user.User.Nickname,
+			&user.User.Age, &user.User.Gender, &user.User.Email, &user.User.Role)
+	if err != nil {
+		return UserPayload{}, false, err
+	}
+	return user, rememberMe, nil
+}
+
+// BeginLogin: Password step. Returns the user when no second factor is needed,
+// otherwise a pending token the client must redeem with CompleteTwoFactorLogin
+func BeginLogin(emailOrNickname, password string, meta SessionMeta) (user UserPayload, pendingToken string, err error) {
+	user, err = LoginUser(emailOrNickname, password, meta)
+	if err != nil {
+		return UserPayload{}, "", err
+	}
+	enabled, err := userHasTOTP(user.User.UserID)
+	if err != nil {
+		return UserPayload{}, "", err
+	}
+	if !enabled {
+		return user, "", nil
+	}
+	pendingToken, err = createPendingLogin(user.User.UserID, meta.RememberMe)
+	if err != nil {
+		return UserPayload{}, "", err
+	}
+	return UserPayload{}, pendingToken, nil
+}
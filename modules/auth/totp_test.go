@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+// enableTestTOTP: Gives the user a fixed TOTP secret and returns the raw key for computing codes
+func enableTestTOTP(t *testing.T, userID string) []byte {
+	t.Helper()
+	key := []byte("12345678901234567890")
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	if _, err := core.Db.Exec("UPDATE users SET totp_secret = ?, totp_enabled = 1, totp_last_step = 0 WHERE user_id = ?", secret, userID); err != nil {
+		t.Fatalf("enable totp: %v", err)
+	}
+	return key
+}
+
+func TestTOTPCodeRFC6238Vector(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, T = 59s -> 94287082 (last six digits)
+	if got := totpCode([]byte("12345678901234567890"), 59/totpPeriod); got != "287082" {
+		t.Fatalf("totpCode = %s, want 287082", got)
+	}
+}
+
+func TestVerifySecondFactorRejectsReplay(t *testing.T) {
+	openTestDB(t)
+	userID := insertTestUser(t, "alice")
+	key := enableTestTOTP(t, userID)
+
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	if !verifySecondFactor(userID, code) {
+		t.Fatal("first use of a valid code was rejected")
+	}
+	if verifySecondFactor(userID, code) {
+		t.Fatal("replayed code was accepted")
+	}
+}
+
+func TestVerifySecondFactorRejectsOlderStep(t *testing.T) {
+	openTestDB(t)
+	userID := insertTestUser(t, "alice")
+	key := enableTestTOTP(t, userID)
+
+	current := time.Now().Unix() / totpPeriod
+	if !verifySecondFactor(userID, totpCode(key, current+1)) {
+		t.Fatal("code of the next step (clock skew) was rejected")
+	}
+	// Still inside the skew window, but older than the step already used
+	if verifySecondFactor(userID, totpCode(key, current)) {
+		t.Fatal("code of an earlier step was accepted after a later one")
+	}
+}
+
+func TestVerifySecondFactorRecoveryCodeSingleUse(t *testing.T) {
+	openTestDB(t)
+	userID := insertTestUser(t, "alice")
+	enableTestTOTP(t, userID)
+
+	code := "abcde-12345"
+	if _, err := core.Db.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashRecoveryCode(code)); err != nil {
+		t.Fatalf("insert recovery code: %v", err)
+	}
+	if !verifySecondFactor(userID, " ABCDE-12345 ") {
+		t.Fatal("recovery code was rejected")
+	}
+	if verifySecondFactor(userID, code) {
+		t.Fatal("recovery code was accepted twice")
+	}
+}
+
+func TestVerifySecondFactorRejectsWrongCode(t *testing.T) {
+	openTestDB(t)
+	userID := insertTestUser(t, "alice")
+	key := enableTestTOTP(t, userID)
+
+	code := totpCode(key, time.Now().Unix()/totpPeriod-5)
+	if verifySecondFactor(userID, code) {
+		t.Fatal("code outside the skew window was accepted")
+	}
+	if verifySecondFactor(userID, "") {
+		t.Fatal("empty code was accepted")
+	}
+}
+
+func TestPasswordStepKeepsSecondFactorFailures(t *testing.T) {
+	openTestDB(t)
+	userID := insertTestUser(t, "alice")
+	key := enableTestTOTP(t, userID)
+	setTestPassword(t, userID, "secret1")
+	meta := SessionMeta{IPAddress: "192.0.2.1"}
+
+	failures := func(key string) (n int) {
+		core.Db.QueryRow("SELECT failures FROM login_attempts WHERE attempt_key = ?", key).Scan(&n)
+		return n
+	}
+
+	_, pending, err := BeginLogin("alice", "secret1", meta)
+	if err != nil || pending == "" {
+		t.Fatalf("BeginLogin = %q, %v; want a pending token", pending, err)
+	}
+	wrong := totpCode(key, time.Now().Unix()/totpPeriod-5)
+	if _, _, err := CompleteTwoFactorLogin(pending, wrong, meta); err != ErrInvalidTOTPCode {
+		t.Fatalf("wrong code: err = %v, want ErrInvalidTOTPCode", err)
+	}
+
+	// A new password step must not wipe the failed code out
+	_, pending, err = BeginLogin("alice", "secret1", meta)
+	if err != nil {
+		t.Fatalf("second BeginLogin: %v", err)
+	}
+	if n := failures("user:" + userID); n != 1 {
+		t.Fatalf("account failures after a new password step = %d, want 1", n)
+	}
+	if n := failures(ipAttemptKey(meta.IPAddress)); n != 1 {
+		t.Fatalf("IP failures after a wrong code = %d, want 1", n)
+	}
+
+	if _, _, err := CompleteTwoFactorLogin(pending, totpCode(key, time.Now().Unix()/totpPeriod), meta); err != nil {
+		t.Fatalf("valid code: %v", err)
+	}
+	if n := failures("user:" + userID); n != 0 {
+		t.Fatalf("account failures after a full login = %d, want 0", n)
+	}
+}
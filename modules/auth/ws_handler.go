@@ -2,11 +2,17 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"real-time-forum/modules/chat"
+	"real-time-forum/modules/core"
+	"real-time-forum/modules/metrics"
 
 	"github.com/gorilla/websocket"
 )
@@ -30,9 +36,17 @@ type UserPayload struct {
 		Age             int    `json:"age,omitempty"`
 		Gender          string `json:"gender,omitempty"`
 		Password        string `json:"password,omitempty"`
+		RememberMe      bool   `json:"remember_me,omitempty"`
+		Role            string `json:"role,omitempty"`
 	} `json:"user"`
 }
 
+// TwoFactorPayload: Second login step and TOTP enrollment/disable confirmations
+type TwoFactorPayload struct {
+	PendingToken string `json:"pending_token,omitempty"`
+	Code         string `json:"code"`
+}
+
 type TypingInProgressPayload struct {
 	IsTyping       bool   `json:"istyping,omitempty"`
 	WhoIsTyping    string `json:"whoIsTyping,omitempty"`
@@ -45,23 +59,79 @@ type WSResponse struct {
 	Status string      `json:"status"`
 	Error  string      `json:"error,omitempty"`
 	Data   interface{} `json:"data,omitempty"`
+	Seq    int64       `json:"seq,omitempty"` // durable events only; acknowledged with "ack"
 }
 
-// clients: Map[userID] -> list of active WebSocket connections (supports multiple tabs)
-var (
-	clients = make(map[string][]*websocket.Conn)
-	mutex   = &sync.RWMutex{}
+// Application close codes sent when the server ends a connection's session
+const (
+	CloseSessionRevoked = 4001
+	CloseSessionExpired = 4002
+	CloseRateLimited    = 4003
+	CloseOriginRejected = 4004
+	CloseTooManyForUser = 4005
+	CloseTooManyForIP   = 4006
+	CloseSlowConsumer   = 4007
+	CloseSuspended      = 4008 // the reason carries the suspension message
 )
 
-// upgrader: Configures WebSocket handshake - allows all origins in dev
+// wsReadLimit: Largest accepted frame - a maximum-length private message where every
+// character needs a 6-byte JSON escape, plus room for the envelope
+const wsReadLimit = int64(chat.MaxMessageLength)*6 + 1024
+
+// sessionState: Expiry of the session bound to a connection, shared with its expiry watcher
+type sessionState struct {
+	mu          sync.Mutex
+	sessionID   string
+	expiresAt   time.Time
+	warned      bool
+	lastRefresh time.Time
+}
+
+// set: Binds (or re-binds) the connection to a session and its current expiry
+func (s *sessionState) set(sessionID string, expiresAt time.Time) {
+	s.mu.Lock()
+	s.sessionID = sessionID
+	s.expiresAt = expiresAt
+	s.warned = false
+	s.lastRefresh = time.Now()
+	s.mu.Unlock()
+}
+
+// extend: Updates the expiry after a refresh; re-arms the warning if it moved forward
+func (s *sessionState) extend(expiresAt time.Time) {
+	s.mu.Lock()
+	if expiresAt.After(s.expiresAt) {
+		s.warned = false
+	}
+	s.expiresAt = expiresAt
+	s.lastRefresh = time.Now()
+	s.mu.Unlock()
+}
+
+// upgrader: Origins are checked in WebSocketHandler instead, after the handshake, so a
+// rejected page gets a close code rather than an opaque handshake failure
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 	CheckOrigin: func(r *http.Request) bool {
-		return true // Restrict in production
+		return true
 	},
 }
 
+// originAllowed: Same-origin pages, non-browser clients and the configured allowlist
+func originAllowed(r *http.Request) bool {
+	if sameOrigin(r) {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	for _, allowed := range core.AppConfig.WSAllowedOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
 // decodeMessage: Generic helper to unmarshal raw JSON into typed struct
 func decodeMessage[T any](raw json.RawMessage) (T, error) {
 	var data T
@@ -69,18 +139,23 @@ func decodeMessage[T any](raw json.RawMessage) (T, error) {
 	return data, err
 }
 
-// writeResponse: Sends structured JSON response over WebSocket
-func writeResponse(conn *websocket.Conn, msgType string, status string, data interface{}, errMsg string) {
+// writeResponse: Queues a structured JSON response for the client's writer
+func writeResponse(c *Client, msgType string, status string, data interface{}, errMsg string) {
 	response := WSResponse{
 		Type:   msgType,
 		Status: status,
 		Data:   data,
 		Error:  errMsg,
 	}
-	err := conn.WriteJSON(response)
+	if status == "error" {
+		c.errorReplies.Add(1)
+	}
+	msg, err := json.Marshal(response)
 	if err != nil {
-		fmt.Printf("[WS] WriteJSON FAILED: %v (conn closed? %v)\n", err, conn == nil)
+		c.logger().Error("Failed to encode WS message", "type", msgType, "err", err)
+		return
 	}
+	c.queue(msg)
 }
 
 // WebSocketHandler: Main entry - upgrades HTTP to WS and handles full client lifecycle
@@ -91,35 +166,43 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Browsers send the session cookie on the upgrade; only honour it for same-origin pages
+	// so a third-party site cannot ride the cookie (cross-site WebSocket hijacking)
+	var cookieSessionID string
+	if cookie, err := r.Cookie(SessionCookieName); err == nil && sameOrigin(r) {
+		cookieSessionID = cookie.Value
+	}
+
 	// Upgrade connection to WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		http.Error(w, "Could not open websocket connection", http.StatusBadRequest)
 		return
 	}
-	defer conn.Close()
+	client := newClient(conn, clientIP(r))
+	client.logger().Info("ws connected", "transport", "websocket", "ip", client.ip)
+	go client.writePump()
+	defer client.closeWith(websocket.CloseNormalClosure, "")
 
-	var currentUserID string
+	if !originAllowed(r) {
+		client.closeWith(CloseOriginRejected, "origin not allowed")
+		return
+	}
+	if !hub.trackIP(client.ip) {
+		client.closeWith(CloseTooManyForIP, "too many connections from this address")
+		return
+	}
+	defer hub.untrackIP(client.ip)
 
-	// Cleanup: Remove connection from clients map on disconnect
-	defer func() {
-		mutex.Lock()
-		if currentUserID != "" {
-			conns := clients[currentUserID]
-			for i, c := range conns {
-				if c == conn {
-					clients[currentUserID] = append(conns[:i], conns[i+1:]...)
-					break
-				}
-			}
+	// Keepalive: writePump pings, every pong pushes the read deadline forward
+	conn.SetReadLimit(wsReadLimit)
+	conn.SetReadDeadline(time.Now().Add(core.AppConfig.WSPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(core.AppConfig.WSPongWait))
+	})
 
-			if len(clients[currentUserID]) == 0 {
-				delete(clients, currentUserID)
-			}
-		}
-		mutex.Unlock()
-		broadcastUsersList()
-	}()
+	wc := newWSConn(client, r, cookieSessionID)
+	defer wc.shutdown()
 
 	// Main message loop - reads and dispatches client messages
 	for {
@@ -129,201 +212,557 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			break // Client disconnected or error
 		}
-		switch msg.Type {
-		case "session_check":
-			// Validate session token and restore user context
-			session, err := decodeMessage[UserPayload](msg.Data)
-			if err != nil {
-				writeResponse(conn, "session_check_result", "error", "", "Invalid session data format")
-				continue
-			}
-			sessionData, err := GetUserFromSessionID(session.User.SessionID)
-			if err != nil {
-				writeResponse(conn, "session_check_result", "error", nil, "Session invalid or expired. Please log in again")
-				continue
+		if !wc.handle(msg) {
+			break
+		}
+	}
+}
+
+// wsConn: State of one real-time connection and the dispatch of its messages
+// Transport-independent: WebSocketHandler feeds it frames, the SSE fallback feeds it POSTs
+type wsConn struct {
+	client          *Client
+	request         *http.Request // the upgrade or stream request (session metadata)
+	cookieSessionID string
+
+	userID    string
+	sessionID string
+	session   *sessionState
+	limiter   *connLimiter
+	done      chan struct{}
+}
+
+func newWSConn(client *Client, r *http.Request, cookieSessionID string) *wsConn {
+	wc := &wsConn{
+		client:          client,
+		request:         r,
+		cookieSessionID: cookieSessionID,
+		session:         &sessionState{},
+		limiter:         newConnLimiter(),
+		done:            make(chan struct{}),
+	}
+	go watchSessionExpiry(client, wc.session, wc.done)
+	return wc
+}
+
+// shutdown: Removes the client from the hub and stops the expiry watcher
+func (wc *wsConn) shutdown() {
+	hub.announce(hub.unregister(wc.client)...)
+	close(wc.done)
+}
+
+// completeLogin: Final login step shared by password-only and 2FA logins
+func (wc *wsConn) completeLogin(user UserPayload, meta SessionMeta) {
+	if hub.userConnCount(user.User.UserID) >= core.AppConfig.WSMaxConnsPerUser {
+		wc.client.closeWith(CloseTooManyForUser, "too many connections for this account")
+		return
+	}
+	sessionID, err := CreateSession(user.User.UserID, meta)
+	if err != nil {
+		var response UserPayload
+		response.User.Email = user.User.Email
+		writeResponse(wc.client, "login_result", "error", response, sessionErrorMessage(err))
+		return
+	}
+
+	// Success: send full user + session
+	var response UserPayload
+	response.User.SessionID = sessionID
+	response.User.UserID = user.User.UserID
+	response.User.Nickname = user.User.Nickname
+	response.User.FirstName = user.User.FirstName
+	response.User.LastName = user.User.LastName
+	response.User.Email = user.User.Email
+	response.User.Age = user.User.Age
+	response.User.Gender = user.User.Gender
+	response.User.Role = user.User.Role
+
+	registered, changes := hub.register(wc.client, user.User.UserID, sessionID)
+	hub.announce(changes...)
+	if !registered {
+		// Lost a race with another tab: drop the session that was just created
+		core.Db.Exec("DELETE FROM sessions WHERE session_id = ?", sessionID)
+		wc.client.closeWith(CloseTooManyForUser, "too many connections for this account")
+		return
+	}
+	wc.userID = user.User.UserID
+	wc.sessionID = sessionID
+	if expiresAt, err := SessionExpiry(sessionID); err == nil {
+		wc.session.set(sessionID, expiresAt)
+	}
+	writeResponse(wc.client, "login_result", "ok", response, "")
+	replayMissed(wc.client, wc.userID)
+}
+
+// handle: Processes one client message; false means the connection must end
+func (wc *wsConn) handle(msg ClientWSMessage) bool {
+	// Any message counts as activity: slide the session expiry (throttled)
+	if wc.sessionID != "" {
+		wc.session.mu.Lock()
+		due := time.Since(wc.session.lastRefresh) >= core.AppConfig.SessionRefreshInterval
+		wc.session.mu.Unlock()
+		if due {
+			if expiresAt, err := RefreshSession(wc.sessionID, false); err == nil {
+				wc.session.extend(expiresAt)
 			}
+		}
+	}
 
-			// Rebuild user payload and register connection
-			var response UserPayload
+	log := wc.client.logger()
+	log.Debug("ws message", "type", msg.Type)
+
+	// Outcome metric: "error" when the message got an error reply or closed the connection
+	typeLabel, outcome := msg.Type, "ok"
+	errorsBefore := wc.client.errorReplies.Load()
+	defer func() {
+		if wc.client.errorReplies.Load() > errorsBefore {
+			outcome = "error"
+		}
+		metrics.WSMessages.Inc(typeLabel, outcome)
+	}()
+
+	// Flood protection: over-limit messages are rejected, repeat offenders disconnected
+	if retryAfter, ok := wc.limiter.allow(wc.userID, msg.Type); !ok {
+		log.Warn("ws message rate limited", "type", msg.Type, "retry_after_ms", retryAfter.Milliseconds())
+		if wc.limiter.strike() {
+			wc.client.closeWith(CloseRateLimited, "rate limit exceeded")
+			outcome = "error"
+			return false
+		}
+		writeResponse(wc.client, "rate_limited", "error", map[string]interface{}{
+			"type":           msg.Type,
+			"retry_after_ms": retryAfter.Milliseconds(),
+		}, "Too many requests, please slow down")
+		return true
+	}
+
+	switch msg.Type {
+	case "session_check":
+		// Validate session token and restore user context
+		sessionPayload, err := decodeMessage[UserPayload](msg.Data)
+		if err != nil {
+			writeResponse(wc.client, "session_check_result", "error", "", "Invalid session data format")
+			return true
+		}
+		// No token in the payload: fall back to the HttpOnly cookie sent with the upgrade
+		sessionID := sessionPayload.User.SessionID
+		viaCookie := sessionID == ""
+		if viaCookie {
+			sessionID = wc.cookieSessionID
+		}
+		sessionData, expiresAt, err := ResolveSession(sessionID)
+		var suspended *AccountSuspendedError
+		if errors.As(err, &suspended) {
+			writeResponse(wc.client, "session_check_result", "error", nil, err.Error())
+			return true
+		}
+		if err != nil {
+			writeResponse(wc.client, "session_check_result", "error", nil, "Session invalid or expired. Please log in again")
+			return true
+		}
+
+		// Rebuild user payload and register connection
+		// Cookie sessions never expose the token to JavaScript
+		var response UserPayload
+		if !viaCookie {
 			response.User.SessionID = sessionData.User.SessionID
-			response.User.UserID = sessionData.User.UserID
-			response.User.Nickname = sessionData.User.Nickname
-			response.User.FirstName = sessionData.User.FirstName
-			response.User.LastName = sessionData.User.LastName
-			response.User.Email = sessionData.User.Email
-			response.User.Age = sessionData.User.Age
-			response.User.Gender = sessionData.User.Gender
-			currentUserID = sessionData.User.UserID
-			mutex.Lock()
-			clients[currentUserID] = append(clients[currentUserID], conn)
-			mutex.Unlock()
-			broadcastUsersList()
-
-			writeResponse(conn, "session_check_result", "ok", response, "")
-
-		case "register":
-			// Handle new user registration
-			registerData, err := decodeMessage[UserPayload](msg.Data)
-			if err != nil {
-				writeResponse(conn, "register_result", "error", nil, "Invalid register data format")
-				continue
-			}
+		}
+		response.User.UserID = sessionData.User.UserID
+		response.User.Nickname = sessionData.User.Nickname
+		response.User.FirstName = sessionData.User.FirstName
+		response.User.LastName = sessionData.User.LastName
+		response.User.Email = sessionData.User.Email
+		response.User.Age = sessionData.User.Age
+		response.User.Gender = sessionData.User.Gender
+		response.User.Role = sessionData.User.Role
+		registered, changes := hub.register(wc.client, sessionData.User.UserID, sessionData.User.SessionID)
+		hub.announce(changes...)
+		if !registered {
+			wc.client.closeWith(CloseTooManyForUser, "too many connections for this account")
+			return true
+		}
+		wc.userID = sessionData.User.UserID
+		wc.sessionID = sessionData.User.SessionID
+		wc.session.set(wc.sessionID, expiresAt)
 
-			err = RegisterUser(registerData)
-			var response UserPayload
-			status := "ok"
-			errMsg := ""
-			if err != nil {
-				status = "error"
-				errMsg = err.Error()
-			}
+		writeResponse(wc.client, "session_check_result", "ok", response, "")
+		replayMissed(wc.client, wc.userID)
 
-			response.User.Email = registerData.User.Email
-			writeResponse(conn, "register_result", status, response, errMsg)
-		case "login":
-			// Authenticate user and create session
-			loginData, err := decodeMessage[UserPayload](msg.Data)
-			if err != nil {
-				writeResponse(conn, "login_result", "error", nil, "Invalid login data format")
-				continue
-			}
+	case "register":
+		// Handle new user registration
+		registerData, err := decodeMessage[UserPayload](msg.Data)
+		if err != nil {
+			writeResponse(wc.client, "register_result", "error", nil, "Invalid register data format")
+			return true
+		}
 
-			user, err := LoginUser(loginData.User.EmailOrNickname, loginData.User.Password)
-			status := "ok"
-			errMsg := ""
+		err = RegisterUser(registerData, SessionMetaFromRequest(wc.request))
+		var response UserPayload
+		status := "ok"
+		errMsg := ""
+		if err != nil {
+			status = "error"
+			errMsg = err.Error()
+		}
+
+		response.User.Email = registerData.User.Email
+		writeResponse(wc.client, "register_result", status, response, errMsg)
+	case "login":
+		// Password step - accounts with 2FA get a pending token instead of a session
+		loginData, err := decodeMessage[UserPayload](msg.Data)
+		if err != nil {
+			writeResponse(wc.client, "login_result", "error", nil, "Invalid login data format")
+			return true
+		}
+
+		meta := SessionMetaFromRequest(wc.request)
+		meta.RememberMe = loginData.User.RememberMe
+		user, pendingToken, err := BeginLogin(loginData.User.EmailOrNickname, loginData.User.Password, meta)
+		if err != nil {
 			var response UserPayload
+			response.User.EmailOrNickname = loginData.User.EmailOrNickname
+			writeResponse(wc.client, "login_result", "error", response, err.Error())
+			return true
+		}
+		if pendingToken != "" {
+			writeResponse(wc.client, "login_2fa_required", "ok", map[string]interface{}{
+				"pending_token": pendingToken,
+				"expires_in":    int(core.AppConfig.PendingLoginTTL.Seconds()),
+			}, "")
+			return true
+		}
+		wc.completeLogin(user, meta)
+	case "login_2fa":
+		// Second step - redeem the pending token with a TOTP or recovery code
+		twoFactorData, err := decodeMessage[TwoFactorPayload](msg.Data)
+		if err != nil {
+			writeResponse(wc.client, "login_result", "error", nil, "Invalid verification data format")
+			return true
+		}
 
-			if err != nil {
-				response.User.EmailOrNickname = loginData.User.EmailOrNickname
-				status = "error"
-				errMsg = err.Error()
-			} else {
-				sessionID, err := CreateSession(user.User.UserID)
-				if err != nil {
-					status = "error"
-					errMsg = "Cannot create session"
-					response.User.EmailOrNickname = loginData.User.EmailOrNickname
-				} else {
-					// Success: send full user + session
-					response.User.SessionID = sessionID
-					response.User.UserID = user.User.UserID
-					response.User.Nickname = user.User.Nickname
-					response.User.FirstName = user.User.FirstName
-					response.User.LastName = user.User.LastName
-					response.User.Email = user.User.Email
-					response.User.Age = user.User.Age
-					response.User.Gender = user.User.Gender
-
-					currentUserID = user.User.UserID
-					mutex.Lock()
-					clients[currentUserID] = append(clients[currentUserID], conn)
-					mutex.Unlock()
-					broadcastUsersList()
-				}
-			}
-			writeResponse(conn, "login_result", status, response, errMsg)
-		case "private_message":
-			// Route private message through chat module
-			if currentUserID == "" {
-				writeResponse(conn, "private_message", "error", nil, "You must be logged in to send messages")
-				continue
-			}
+		meta := SessionMetaFromRequest(wc.request)
+		user, rememberMe, err := CompleteTwoFactorLogin(twoFactorData.PendingToken, twoFactorData.Code, meta)
+		if err != nil {
+			writeResponse(wc.client, "login_2fa_result", "error", nil, err.Error())
+			return true
+		}
+		meta.RememberMe = rememberMe
+		wc.completeLogin(user, meta)
+	case "totp_setup":
+		if wc.userID == "" {
+			writeResponse(wc.client, "totp_setup_result", "error", nil, "You must be logged in to set up two-factor authentication")
+			return true
+		}
+		setup, err := BeginTOTPSetup(wc.userID)
+		if err != nil {
+			writeResponse(wc.client, "totp_setup_result", "error", nil, err.Error())
+			return true
+		}
+		writeResponse(wc.client, "totp_setup_result", "ok", setup, "")
+	case "totp_enable":
+		if wc.userID == "" {
+			writeResponse(wc.client, "totp_enable_result", "error", nil, "You must be logged in to enable two-factor authentication")
+			return true
+		}
+		codeData, err := decodeMessage[TwoFactorPayload](msg.Data)
+		if err != nil {
+			writeResponse(wc.client, "totp_enable_result", "error", nil, "Invalid verification data format")
+			return true
+		}
+		codes, err := ConfirmTOTPSetup(wc.userID, codeData.Code)
+		if err != nil {
+			writeResponse(wc.client, "totp_enable_result", "error", nil, err.Error())
+			return true
+		}
+		writeResponse(wc.client, "totp_enable_result", "ok", map[string][]string{"recovery_codes": codes}, "")
+	case "totp_disable":
+		if wc.userID == "" {
+			writeResponse(wc.client, "totp_disable_result", "error", nil, "You must be logged in to disable two-factor authentication")
+			return true
+		}
+		codeData, err := decodeMessage[TwoFactorPayload](msg.Data)
+		if err != nil {
+			writeResponse(wc.client, "totp_disable_result", "error", nil, "Invalid verification data format")
+			return true
+		}
+		if err := DisableTOTP(wc.userID, codeData.Code); err != nil {
+			writeResponse(wc.client, "totp_disable_result", "error", nil, err.Error())
+			return true
+		}
+		writeResponse(wc.client, "totp_disable_result", "ok", nil, "")
+	case "totp_status":
+		if wc.userID == "" {
+			writeResponse(wc.client, "totp_status_result", "error", nil, "You must be logged in")
+			return true
+		}
+		status, err := GetTOTPStatus(wc.userID)
+		if err != nil {
+			writeResponse(wc.client, "totp_status_result", "error", nil, "Failed to load two-factor status")
+			return true
+		}
+		writeResponse(wc.client, "totp_status_result", "ok", status, "")
+	case "refresh_session":
+		// Explicit "stay signed in" - extends the session up to its absolute lifetime
+		if wc.sessionID == "" {
+			writeResponse(wc.client, "refresh_session_result", "error", nil, "You must be logged in to refresh your session")
+			return true
+		}
+		expiresAt, err := RefreshSession(wc.sessionID, true)
+		if err != nil {
+			writeResponse(wc.client, "refresh_session_result", "error", nil, "Session invalid or expired. Please log in again")
+			return true
+		}
+		wc.session.extend(expiresAt)
+		writeResponse(wc.client, "refresh_session_result", "ok", map[string]time.Time{"expires_at": expiresAt}, "")
+	case "list_sessions":
+		// List every active session (device) of the current user
+		if wc.userID == "" {
+			writeResponse(wc.client, "list_sessions_result", "error", nil, "You must be logged in to manage sessions")
+			return true
+		}
+		sessions, err := ListSessions(wc.userID, wc.sessionID)
+		if err != nil {
+			writeResponse(wc.client, "list_sessions_result", "error", nil, "Unable to load sessions. Please try again")
+			return true
+		}
+		writeResponse(wc.client, "list_sessions_result", "ok", sessions, "")
+	case "revoke_session":
+		// Log out a single device and drop its live connections
+		if wc.userID == "" {
+			writeResponse(wc.client, "revoke_session_result", "error", nil, "You must be logged in to manage sessions")
+			return true
+		}
+		var payload struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(msg.Data, &payload); err != nil || payload.ID == "" {
+			writeResponse(wc.client, "revoke_session_result", "error", nil, "Invalid session data format")
+			return true
+		}
+		revokedID, err := RevokeSession(wc.userID, payload.ID)
+		if err != nil {
+			writeResponse(wc.client, "revoke_session_result", "error", nil, "Session not found")
+			return true
+		}
+		writeResponse(wc.client, "revoke_session_result", "ok", payload, "")
+		closeSessionConns(revokedID)
+	case "revoke_other_sessions":
+		// Log out every device except this one
+		if wc.userID == "" {
+			writeResponse(wc.client, "revoke_other_sessions_result", "error", nil, "You must be logged in to manage sessions")
+			return true
+		}
+		revoked, err := RevokeOtherSessions(wc.userID, wc.sessionID)
+		if err != nil {
+			writeResponse(wc.client, "revoke_other_sessions_result", "error", nil, "Unable to revoke sessions. Please try again")
+			return true
+		}
+		writeResponse(wc.client, "revoke_other_sessions_result", "ok", map[string]int{"revoked": len(revoked)}, "")
+		closeSessionConns(revoked...)
+	case "private_message":
+		// Route private message through chat module
+		if wc.userID == "" {
+			writeResponse(wc.client, "private_message", "error", nil, "You must be logged in to send messages")
+			return true
+		}
 
-			preparedMsg, err := chat.ProcessPrivateMessage(currentUserID, msg.Data)
-			if err != nil {
-				writeResponse(conn, "private_message", "error", nil, fmt.Sprintf("Message could not be sent: %v", err))
-				continue
-			}
+		preparedMsg, err := chat.ProcessPrivateMessage(log, wc.userID, msg.Data)
+		if err != nil {
+			writeResponse(wc.client, "private_message", "error", nil, fmt.Sprintf("Message could not be sent: %v", err))
+			return true
+		}
 
-			// Deliver to recipient
-			mutex.RLock()
-			if recipientConns, ok := clients[preparedMsg.RecipientID]; ok {
-				for _, c := range recipientConns {
-					writeResponse(c, "private_message", "ok", preparedMsg, "")
-				}
-			}
-			mutex.RUnlock()
-
-			// Echo to sender (all tabs)
-			mutex.RLock()
-			if senderConns, ok := clients[currentUserID]; ok {
-				for _, c := range senderConns {
-					writeResponse(c, "private_message", "ok", preparedMsg, "")
-				}
-			}
-			mutex.RUnlock()
-		case "get_chat_history":
-			// Fetch paginated chat history between two users
-			if currentUserID == "" {
-				writeResponse(conn, "chat_history_result", "error", nil, "You must be logged in to view chat history")
-				continue
-			}
-			var payload struct {
-				WithUserID string `json:"with_user_id"`
-				Limit      int    `json:"limit"`
-				Offset     int    `json:"offset"`
-			}
-			if err := json.Unmarshal(msg.Data, &payload); err != nil {
-				writeResponse(conn, "chat_history_result", "error", nil, "Failed to fetch chat history: invalid request")
-				continue
-			}
-			if payload.Limit == 0 {
-				payload.Limit = 10
-			} // Default to 10
-			history, err := chat.GetChatHistory(currentUserID, payload.WithUserID, payload.Limit, payload.Offset)
-			if err != nil {
-				writeResponse(conn, "chat_history_result", "error", nil, "Unable to retrieve chat history. Please try again")
-				continue
-			}
-			writeResponse(conn, "chat_history_result", "ok", history, "")
-		case "users_list":
-			if currentUserID == "" {
-				continue
-			}
-			sendUsersList(conn, currentUserID)
-		case "typing":
-			var S TypingInProgressPayload
-			S, err := decodeMessage[TypingInProgressPayload](msg.Data)
-			if err != nil {
-				writeResponse(conn, "typing_result", "error", nil, "Invalid typingInProgress data")
-				continue
-			}
-			// Deliver to recipient
-			mutex.RLock()
-			if recipientConns, ok := clients[S.WhoIsReceiving]; ok {
-				for _, c := range recipientConns {
-					writeResponse(c, "typing_result", "ok", S, "")
-				}
-			}
-			mutex.RUnlock()
+		// Deliver to recipient and echo to sender (all tabs); both copies are kept for
+		// replay, so devices that are offline right now catch up when they reconnect
+		delivered := WSResponse{Type: "private_message", Status: "ok", Data: preparedMsg}
+		sendDurable(preparedMsg.RecipientID, delivered)
+		sendDurable(wc.userID, delivered)
+	case "edit_message", "delete_message":
+		// Sender-only changes within MessageEditWindow, pushed to both parties
+		if wc.userID == "" {
+			writeResponse(wc.client, msg.Type+"_result", "error", nil, "You must be logged in to change messages")
+			return true
+		}
+		var (
+			changed   *chat.PrivateMessagePayload
+			err       error
+			eventType string
+		)
+		if msg.Type == "edit_message" {
+			changed, err = chat.EditPrivateMessage(wc.userID, msg.Data)
+			eventType = "message_edited"
+		} else {
+			changed, err = chat.DeletePrivateMessage(wc.userID, msg.Data)
+			eventType = "message_deleted"
+		}
+		if err != nil {
+			writeResponse(wc.client, msg.Type+"_result", "error", nil, fmt.Sprintf("Message could not be changed: %v", err))
+			return true
+		}
+		event := WSResponse{Type: eventType, Status: "ok", Data: changed}
+		sendDurable(changed.RecipientID, event)
+		sendDurable(wc.userID, event)
+	case "react_message", "unreact_message":
+		// Either participant toggles an allowlisted emoji; both get the new totals
+		if wc.userID == "" {
+			writeResponse(wc.client, msg.Type+"_result", "error", nil, "You must be logged in to react to messages")
+			return true
+		}
+		reactions, err := chat.ReactToMessage(wc.userID, msg.Data, msg.Type == "react_message")
+		if err != nil {
+			writeResponse(wc.client, msg.Type+"_result", "error", nil, fmt.Sprintf("Reaction could not be saved: %v", err))
+			return true
+		}
+		event := WSResponse{Type: "message_reactions", Status: "ok", Data: reactions}
+		sendDurable(reactions.SenderID, event)
+		sendDurable(reactions.RecipientID, event)
+	case "get_chat_history":
+		// Fetch paginated chat history between two users
+		if wc.userID == "" {
+			writeResponse(wc.client, "chat_history_result", "error", nil, "You must be logged in to view chat history")
+			return true
+		}
+		var payload struct {
+			WithUserID string `json:"with_user_id"`
+			Limit      int    `json:"limit"`
+			Offset     int    `json:"offset"`
+		}
+		if err := json.Unmarshal(msg.Data, &payload); err != nil {
+			writeResponse(wc.client, "chat_history_result", "error", nil, "Failed to fetch chat history: invalid request")
+			return true
+		}
+		if payload.Limit == 0 {
+			payload.Limit = 10
+		} // Default to 10
+		history, err := chat.GetChatHistory(wc.userID, payload.WithUserID, payload.Limit, payload.Offset)
+		if err != nil {
+			writeResponse(wc.client, "chat_history_result", "error", nil, "Unable to retrieve chat history. Please try again")
+			return true
+		}
+		writeResponse(wc.client, "chat_history_result", "ok", history, "")
+	case "users_list":
+		if wc.userID == "" {
+			return true
+		}
+		sendUsersList(wc.client, wc.userID)
+	case "ack":
+		// Everything up to seq was processed: it will not be replayed again
+		if wc.userID == "" {
+			return true
+		}
+		ack, err := decodeMessage[AckPayload](msg.Data)
+		if err != nil || ack.Seq <= 0 {
+			writeResponse(wc.client, "ack_result", "error", nil, "Invalid ack data format")
+			return true
+		}
+		if err := AckEvents(wc.userID, ack.Seq); err != nil {
+			writeResponse(wc.client, "ack_result", "error", nil, "Unable to record acknowledgement")
+		}
+	case "replay":
+		// Next batch of missed events (after "replay_done" with more=true)
+		if wc.userID == "" {
+			return true
 		}
+		replayMissed(wc.client, wc.userID)
+	case "typing":
+		var S TypingInProgressPayload
+		S, err := decodeMessage[TypingInProgressPayload](msg.Data)
+		if err != nil {
+			writeResponse(wc.client, "typing_result", "error", nil, "Invalid typingInProgress data")
+			return true
+		}
+		// Deliver to recipient
+		hub.sendToUser(S.WhoIsReceiving, WSResponse{Type: "typing_result", Status: "ok", Data: S})
+	default:
+		// Ignored; counted under one label so clients cannot create metric series
+		typeLabel = "unknown"
 	}
+	return true
 }
 
-func sendUsersList(conn *websocket.Conn, userID string) {
-	users, _ := chat.GetUsers(userID)
-	mutex.RLock()
-	for i := range users {
-		if _, ok := clients[users[i].ID]; ok {
-			users[i].IsOnline = true
-		}
+// closeSessionConns: Closes every live connection bound to the given sessions, on every node
+// The read loop of each connection then runs its normal cleanup
+func closeSessionConns(sessionIDs ...string) {
+	hub.closeSessions(sessionIDs...)
+}
+
+// closeSuspendedConns: Closes the connections of a suspended user's sessions, on every node,
+// with CloseSuspended and the suspension message as the close reason
+func closeSuspendedConns(s *Suspension, sessionIDs ...string) {
+	hub.closeSessionsWith(CloseSuspended, closeReason(s.Message()), sessionIDs...)
+}
+
+// closeReason: Close frame reasons are limited to 123 bytes; cut at a rune boundary
+func closeReason(reason string) string {
+	const max = 123
+	if len(reason) <= max {
+		return reason
+	}
+	cut := max - len("…")
+	for cut > 0 && !utf8.RuneStart(reason[cut]) {
+		cut--
 	}
-	mutex.RUnlock()
-	writeResponse(conn, "users_list", "ok", users, "")
+	return reason[:cut] + "…"
 }
 
-func broadcastUsersList() {
-	mutex.RLock()
-	defer mutex.RUnlock()
-	for userID, conns := range clients {
-		users, _ := chat.GetUsers(userID)
-		for i := range users {
-			if _, ok := clients[users[i].ID]; ok {
-				users[i].IsOnline = true
-			}
+// watchSessionExpiry: Warns the client before its session expires and closes the
+// connection once it has, instead of letting the next action fail silently
+func watchSessionExpiry(client *Client, session *sessionState, done <-chan struct{}) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		session.mu.Lock()
+		sessionID, expiresAt, warned := session.sessionID, session.expiresAt, session.warned
+		session.mu.Unlock()
+		if sessionID == "" || time.Until(expiresAt) > core.AppConfig.SessionExpiryWarning {
+			continue
+		}
+
+		// Another tab or device may have kept the session alive in the meantime
+		latest, err := SessionExpiry(sessionID)
+		if err == nil && latest.After(expiresAt) {
+			session.extend(latest)
+			continue
+		}
+
+		if errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrSessionNotFound) || (err == nil && !time.Now().Before(latest)) {
+			writeResponse(client, "session_expired", "ok", nil, "Your session has expired. Please log in again")
+			client.closeWith(CloseSessionExpired, "session expired")
+			return
 		}
-		for _, conn := range conns {
-			writeResponse(conn, "users_list", "ok", users, "")
+
+		if err == nil && !warned {
+			session.mu.Lock()
+			session.warned = true
+			session.mu.Unlock()
+			writeResponse(client, "session_expiring", "ok", map[string]interface{}{
+				"expires_at":   latest,
+				"seconds_left": int(time.Until(latest).Seconds()),
+			}, "")
 		}
 	}
 }
+
+// sendUsersList: Full list with conversation previews - only sent when the client asks
+func sendUsersList(client *Client, userID string) {
+	users, _ := chat.GetUsers(userID)
+	for i := range users {
+		users[i].IsOnline = hub.isOnline(users[i].ID)
+	}
+	writeResponse(client, "users_list", "ok", users, "")
+}
+
+// PresencePayload: Body of "presence_changed" - one user came online or went offline
+// Clients merge it into the list they fetched with "users_list"
+type PresencePayload struct {
+	UserID   string `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Online   bool   `json:"online"`
+}
@@ -1,7 +1,10 @@
 package chat
 
 import (
+	"time"
+
 	"real-time-forum/modules/core"
+	"real-time-forum/modules/metrics"
 )
 
 // User: Public user representation for chat UI (includes last message preview)
@@ -16,36 +19,57 @@ type User struct {
 }
 
 // GetUsers fetches all users with last message for currentUserID
+// One query: the newest message of every conversation is picked with a window function
+// instead of a GetLastMessage round-trip per user
 func GetUsers(currentUserID string) ([]User, error) {
-	users, err := GetOnlyUsers()
+	defer metrics.DBQueryDuration.Since(time.Now(), "GetUsers")
+	rows, err := core.Db.Query(`
+		WITH conversations AS (
+			SELECT
+				CASE WHEN sender_id = ? THEN recipient_id ELSE sender_id END AS other_id,
+				CASE WHEN deleted_at IS NOT NULL THEN ? ELSE content END AS content,
+				created_at, sender_id, recipient_id,
+				ROW_NUMBER() OVER (
+					PARTITION BY CASE WHEN sender_id = ? THEN recipient_id ELSE sender_id END
+					ORDER BY created_at DESC
+				) AS rn
+			FROM private_messages
+			WHERE sender_id = ? OR recipient_id = ?
+		)
+		SELECT u.user_id, u.nickname,
+			COALESCE(c.content, ''), COALESCE(c.created_at, ''),
+			COALESCE(c.sender_id, ''), COALESCE(c.recipient_id, '')
+		FROM users u
+		LEFT JOIN conversations c ON c.other_id = u.user_id AND c.rn = 1 AND u.user_id != ?
+		WHERE u.user_id != ?
+		ORDER BY u.nickname ASC
+	`, currentUserID, DeletedMessagePreview, currentUserID, currentUserID, currentUserID, currentUserID, core.DeletedUserID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Enrich each user with last message data
-	for i := range users {
-		if users[i].ID == currentUserID {
-			continue // Skip current user
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Nickname, &u.LastMsg, &u.Created_at, &u.SenderID, &u.RecipientID); err != nil {
+			return nil, err
 		}
-		last, timeStamp, sender_id, recipient_id := GetLastMessage(currentUserID, users[i].ID)
-		users[i].LastMsg = last
-		users[i].Created_at = timeStamp
-		users[i].SenderID = sender_id
-		users[i].RecipientID = recipient_id
+		users = append(users, u)
 	}
-	return users, err
+	return users, rows.Err()
 }
 
-// GetLastMessage returns the last message between two users
+// GetLastMessage returns the last message between two users (unsent ones as DeletedMessagePreview)
 func GetLastMessage(currentUserID, targetUserID string) (string, string, string, string) {
 	query := `
-		SELECT content, created_at, sender_id, recipient_id
+		SELECT CASE WHEN deleted_at IS NOT NULL THEN ? ELSE content END, created_at, sender_id, recipient_id
 		FROM private_messages
 		WHERE (sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?)
 		ORDER BY created_at DESC LIMIT 1
 	`
 	var lastMsg, created_at, sender_id, recipient_id string
-	err := core.Db.QueryRow(query, currentUserID, targetUserID, targetUserID, currentUserID).Scan(
+	err := core.Db.QueryRow(query, DeletedMessagePreview, currentUserID, targetUserID, targetUserID, currentUserID).Scan(
 		&lastMsg, &created_at, &sender_id, &recipient_id)
 	if err != nil {
 		return "", "", "", ""
@@ -58,8 +82,9 @@ func GetOnlyUsers() ([]User, error) {
 	rows, err := core.Db.Query(`
 		SELECT user_id, nickname
 		FROM users
+		WHERE user_id != ?
 		ORDER BY nickname ASC
-	`)
+	`, core.DeletedUserID)
 	if err != nil {
 		return nil, err
 	}
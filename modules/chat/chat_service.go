@@ -1,55 +1,98 @@
 package chat
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"real-time-forum/modules/core"
+	"real-time-forum/modules/metrics"
 
 	"github.com/google/uuid"
 )
 
+// MaxMessageLength: Longest accepted private message content (bytes)
+const MaxMessageLength = 1000
+
+// ErrUnknownRecipient: The recipient has no account, or it was deleted
+var ErrUnknownRecipient = errors.New("recipient not found")
+
 // PrivateMessagePayload defines the structure for sending and receiving private messages.
 type PrivateMessagePayload struct {
+	MessageID      string `json:"message_id,omitempty"`
 	RecipientID    string `json:"recipient_id"`
 	Content        string `json:"content"`
 	SenderID       string `json:"sender_id,omitempty"`
 	SenderNickname string `json:"sender_nickname,omitempty"`
 	CreatedAt      string `json:"created_at,omitempty"`
+	EditedAt       string `json:"edited_at,omitempty"`
+	DeletedAt      string `json:"deleted_at,omitempty"` // content is cleared when set
+	EditableUntil  int64  `json:"editable_until,omitempty"`
+
+	Reactions []MessageReaction `json:"reactions,omitempty"`
+
+	ReplyToMessageID string        `json:"reply_to_message_id,omitempty"` // optional quoted message of the same conversation
+	ReplyTo          *ReplyPreview `json:"reply_to,omitempty"`
 }
 
 // ProcessPrivateMessage: Validates, enriches, saves, and returns a private message
-// Called from WebSocket handler; prepares message for delivery to both parties
-func ProcessPrivateMessage(senderID string, rawPayload json.RawMessage) (*PrivateMessagePayload, error) {
+// Called from WebSocket handler with the connection's logger; prepares message for delivery to both parties
+func ProcessPrivateMessage(log *slog.Logger, senderID string, rawPayload json.RawMessage) (*PrivateMessagePayload, error) {
 	var pm PrivateMessagePayload
 	if err := json.Unmarshal(rawPayload, &pm); err != nil {
-		fmt.Printf("Error decoding private message: %v\n", err)
+		log.Warn("Invalid private message payload", "err", err)
 		return nil, err
 	}
-	if len(pm.Content) > 1000 {
-		return nil, fmt.Errorf("private message exceeds maximum length of 1000 characters")
+	if len(pm.Content) > MaxMessageLength {
+		return nil, fmt.Errorf("private message exceeds maximum length of %d characters", MaxMessageLength)
+	}
+	// The deleted-user placeholder only signs old messages; nobody reads its inbox
+	if pm.RecipientID == core.DeletedUserID {
+		return nil, ErrUnknownRecipient
+	}
+	var exists int
+	err := core.Db.QueryRow("SELECT 1 FROM users WHERE user_id = ?", pm.RecipientID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownRecipient
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	// Fetch sender's nickname
 	senderNickname, err := GetNicknameByUserID(senderID)
 	if err != nil {
-		fmt.Printf("Could not get nickname for sender %s: %v\n", senderID, err)
+		log.Warn("Could not get nickname for sender", "err", err)
 		senderNickname = "Unknown"
 	}
 
+	// A quoted message must come from this conversation
+	pm.ReplyTo = nil
+	if pm.ReplyToMessageID != "" {
+		pm.ReplyTo, err = loadReplyPreview(pm.ReplyToMessageID, senderID, pm.RecipientID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Generate unique ID and timestamp
 	messageID := uuid.New().String()
+	pm.MessageID = messageID
 	pm.SenderID = senderID
 	pm.SenderNickname = senderNickname
-	pm.CreatedAt = fmt.Sprintf("%d", time.Now().UnixMilli())
+	now := time.Now()
+	pm.CreatedAt = fmt.Sprintf("%d", now.UnixMilli())
+	pm.EditableUntil = now.Add(core.AppConfig.MessageEditWindow).UnixMilli()
 
 	_, err = core.Db.Exec(
-		"INSERT INTO private_messages (message_id, sender_id, recipient_id, content, created_at) VALUES (?, ?, ?, ?, ?)",
-		messageID, pm.SenderID, pm.RecipientID, pm.Content, pm.CreatedAt,
+		"INSERT INTO private_messages (message_id, sender_id, recipient_id, content, created_at, reply_to_message_id) VALUES (?, ?, ?, ?, ?, NULLIF(?, ''))",
+		messageID, pm.SenderID, pm.RecipientID, pm.Content, pm.CreatedAt, pm.ReplyToMessageID,
 	)
 	if err != nil {
-		fmt.Printf("Error saving private message to DB: %v\n", err)
+		log.Error("Failed to save private message", "err", err)
 		return nil, err
 	}
 	return &pm, nil
@@ -67,10 +110,16 @@ func GetNicknameByUserID(userID string) (string, error) {
 
 // GetChatHistory: Fetches paginated chat between two users (newest first)
 func GetChatHistory(user1ID, user2ID string, limit, offset int) ([]PrivateMessagePayload, error) {
+	defer metrics.DBQueryDuration.Since(time.Now(), "GetChatHistory")
 	query := `
-        SELECT m.sender_id, u.nickname, m.content, m.created_at
+        SELECT m.message_id, m.sender_id, u.nickname, m.content, m.created_at,
+            COALESCE(m.edited_at, ''), COALESCE(m.deleted_at, ''),
+            COALESCE(r.message_id, ''), COALESCE(r.sender_id, ''), COALESCE(ru.nickname, ''),
+            COALESCE(r.content, ''), r.deleted_at IS NOT NULL
         FROM private_messages m
         JOIN users u ON u.user_id = m.sender_id
+        LEFT JOIN private_messages r ON r.message_id = m.reply_to_message_id
+        LEFT JOIN users ru ON ru.user_id = r.sender_id
         WHERE (m.sender_id = ? AND m.recipient_id = ?) OR (m.sender_id = ? AND m.recipient_id = ?)
         ORDER BY m.created_at DESC
 		LIMIT ? OFFSET ?
@@ -84,10 +133,34 @@ func GetChatHistory(user1ID, user2ID string, limit, offset int) ([]PrivateMessag
 	var messages []PrivateMessagePayload
 	for rows.Next() {
 		var msg PrivateMessagePayload
-		if err := rows.Scan(&msg.SenderID, &msg.SenderNickname, &msg.Content, &msg.CreatedAt); err != nil {
+		var reply ReplyPreview
+		if err := rows.Scan(&msg.MessageID, &msg.SenderID, &msg.SenderNickname, &msg.Content, &msg.CreatedAt,
+			&msg.EditedAt, &msg.DeletedAt,
+			&reply.MessageID, &reply.SenderID, &reply.SenderNickname, &reply.Content, &reply.Deleted); err != nil {
 			return nil, err
 		}
+		if reply.MessageID != "" && msg.DeletedAt == "" {
+			reply.Content = truncatePreview(reply.Content)
+			msg.ReplyToMessageID, msg.ReplyTo = reply.MessageID, &reply
+		}
+		msg.EditableUntil = editableUntil(msg.CreatedAt, msg.DeletedAt)
 		messages = append(messages, msg)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Reaction counts for the whole page in one query
+	messageIDs := make([]string, len(messages))
+	for i, msg := range messages {
+		messageIDs[i] = msg.MessageID
+	}
+	reactions, err := getMessageReactions(messageIDs...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].MessageID]
+	}
 	return messages, nil
 }
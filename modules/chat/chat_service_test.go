@@ -0,0 +1,27 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"real-time-forum/modules/core"
+)
+
+func TestProcessPrivateMessageRejectsUnknownRecipient(t *testing.T) {
+	openTestDB(t)
+	alice := insertTestUser(t, "alice")
+
+	for _, recipient := range []string{core.DeletedUserID, "no-such-user"} {
+		raw, _ := json.Marshal(PrivateMessagePayload{RecipientID: recipient, Content: "hello"})
+		if _, err := ProcessPrivateMessage(slog.Default(), alice, raw); !errors.Is(err, ErrUnknownRecipient) {
+			t.Fatalf("send to %q: err = %v, want %v", recipient, err, ErrUnknownRecipient)
+		}
+	}
+	var stored int
+	core.Db.QueryRow("SELECT COUNT(*) FROM private_messages").Scan(&stored)
+	if stored != 0 {
+		t.Fatalf("%d messages were saved for recipients without an account", stored)
+	}
+}
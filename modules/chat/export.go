@@ -0,0 +1,226 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+// Export formats of a conversation
+const (
+	ExportJSON = "json"
+	ExportHTML = "html"
+	ExportText = "txt" // mbox-like: one "From " block per message
+)
+
+var ErrUnknownExportFormat = errors.New("format must be json, html or txt")
+
+// ExportContentType: MIME type of an export format
+func ExportContentType(format string) (string, error) {
+	switch format {
+	case ExportJSON:
+		return "application/json; charset=utf-8", nil
+	case ExportHTML:
+		return "text/html; charset=utf-8", nil
+	case ExportText:
+		return "text/plain; charset=utf-8", nil
+	}
+	return "", ErrUnknownExportFormat
+}
+
+// ExportedMessage: One message of a conversation export
+type ExportedMessage struct {
+	MessageID        string `json:"message_id"`
+	Sender           string `json:"sender"`
+	Recipient        string `json:"recipient"`
+	SentAt           string `json:"sent_at"` // ISO-8601, UTC
+	EditedAt         string `json:"edited_at,omitempty"`
+	Deleted          bool   `json:"deleted,omitempty"`
+	ReplyToMessageID string `json:"reply_to_message_id,omitempty"`
+	Content          string `json:"content"`
+}
+
+// ExportConversation: Writes the whole conversation between userID and otherID, oldest first
+// Rows are encoded as they are read, so memory stays flat however long the history is
+func ExportConversation(w io.Writer, format, userID, otherID string) error {
+	if _, err := ExportContentType(format); err != nil {
+		return err
+	}
+	nicknames := make(map[string]string, 2)
+	for _, id := range []string{userID, otherID} {
+		nickname, err := GetNicknameByUserID(id)
+		if err != nil {
+			return err
+		}
+		nicknames[id] = nickname
+	}
+
+	rows, err := core.Db.Query(`
+        SELECT message_id, sender_id, recipient_id, content, created_at,
+            COALESCE(edited_at, ''), deleted_at IS NOT NULL, COALESCE(reply_to_message_id, '')
+        FROM private_messages
+        WHERE (sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?)
+        ORDER BY created_at, message_id
+    `, userID, otherID, otherID, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	out := bufio.NewWriter(w)
+	enc := exportEncoders[format]
+	if err := enc.begin(out, nicknames[userID], nicknames[otherID]); err != nil {
+		return err
+	}
+	for i := 0; rows.Next(); i++ {
+		var msg ExportedMessage
+		var senderID, recipientID, createdAt, editedAt string
+		if err := rows.Scan(&msg.MessageID, &senderID, &recipientID, &msg.Content, &createdAt,
+			&editedAt, &msg.Deleted, &msg.ReplyToMessageID); err != nil {
+			return err
+		}
+		msg.Sender, msg.Recipient = nicknames[senderID], nicknames[recipientID]
+		msg.SentAt = isoTimestamp(createdAt)
+		msg.EditedAt = isoTimestamp(editedAt)
+		if err := enc.message(out, i, msg); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := enc.end(out); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// isoTimestamp: Millisecond created_at / edited_at string as ISO-8601 (UTC), "" when unset
+func isoTimestamp(millis string) string {
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return time.UnixMilli(ms).UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// exportEncoder: Writes one format piece by piece
+type exportEncoder struct {
+	begin   func(w *bufio.Writer, user, other string) error
+	message func(w *bufio.Writer, index int, msg ExportedMessage) error
+	end     func(w *bufio.Writer) error
+}
+
+var exportEncoders = map[string]exportEncoder{
+	ExportJSON: {
+		begin: func(w *bufio.Writer, user, other string) error {
+			header, err := json.Marshal(map[string]interface{}{
+				"participants": []string{user, other},
+				"exported_at":  time.Now().UTC().Format(time.RFC3339),
+			})
+			if err != nil {
+				return err
+			}
+			// Reopen the header object to append the streamed messages array
+			_, err = fmt.Fprintf(w, "%s,\"messages\":[\n", header[:len(header)-1])
+			return err
+		},
+		message: func(w *bufio.Writer, index int, msg ExportedMessage) error {
+			if index > 0 {
+				w.WriteString(",\n")
+			}
+			line, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(line)
+			return err
+		},
+		end: func(w *bufio.Writer) error {
+			_, err := w.WriteString("\n]}\n")
+			return err
+		},
+	},
+	ExportHTML: {
+		begin: func(w *bufio.Writer, user, other string) error {
+			title := html.EscapeString(fmt.Sprintf("Conversation between %s and %s", user, other))
+			_, err := fmt.Fprintf(w, `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>%[1]s</title>
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; }
+.message { border-bottom: 1px solid #ddd; padding: 0.5rem 0; }
+.meta { color: #666; font-size: 0.85rem; }
+.content { white-space: pre-wrap; margin: 0.25rem 0 0; }
+</style>
+</head>
+<body>
+<h1>%[1]s</h1>
+`, title)
+			return err
+		},
+		message: func(w *bufio.Writer, index int, msg ExportedMessage) error {
+			content := html.EscapeString(msg.Content)
+			if msg.Deleted {
+				content = "<em>Message deleted</em>"
+			}
+			var notes string
+			if msg.EditedAt != "" && !msg.Deleted {
+				notes += " (edited " + msg.EditedAt + ")"
+			}
+			if msg.ReplyToMessageID != "" {
+				notes += ` - reply to <a href="#` + html.EscapeString(msg.ReplyToMessageID) + `">earlier message</a>`
+			}
+			_, err := fmt.Fprintf(w, `<div class="message" id="%s">
+<div class="meta"><strong>%s</strong> <time datetime="%[3]s">%[3]s</time>%s</div>
+<p class="content">%s</p>
+</div>
+`, html.EscapeString(msg.MessageID), html.EscapeString(msg.Sender), msg.SentAt, notes, content)
+			return err
+		},
+		end: func(w *bufio.Writer) error {
+			_, err := w.WriteString("</body>\n</html>\n")
+			return err
+		},
+	},
+	ExportText: {
+		begin: func(w *bufio.Writer, user, other string) error { return nil },
+		message: func(w *bufio.Writer, index int, msg ExportedMessage) error {
+			fmt.Fprintf(w, "From %s %s\n", msg.Sender, msg.SentAt)
+			fmt.Fprintf(w, "From: %s\nTo: %s\nDate: %s\nMessage-ID: <%s>\n", msg.Sender, msg.Recipient, msg.SentAt, msg.MessageID)
+			if msg.ReplyToMessageID != "" {
+				fmt.Fprintf(w, "In-Reply-To: <%s>\n", msg.ReplyToMessageID)
+			}
+			if msg.EditedAt != "" && !msg.Deleted {
+				fmt.Fprintf(w, "Edited: %s\n", msg.EditedAt)
+			}
+			w.WriteString("\n")
+			if msg.Deleted {
+				w.WriteString("[message deleted]\n")
+			}
+			for _, line := range strings.Split(msg.Content, "\n") {
+				if msg.Deleted {
+					break
+				}
+				// mbox quoting: a body line starting with "From " would read as a new message
+				if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+					line = ">" + line
+				}
+				w.WriteString(line + "\n")
+			}
+			_, err := w.WriteString("\n")
+			return err
+		},
+		end: func(w *bufio.Writer) error { return nil },
+	},
+}
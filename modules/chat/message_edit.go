@@ -0,0 +1,224 @@
+package chat
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/modules/audit"
+	"real-time-forum/modules/core"
+)
+
+// DeletedMessagePreview: Sidebar preview of a conversation whose last message was unsent
+const DeletedMessagePreview = "Message deleted"
+
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrNotMessageSender = errors.New("only the sender can change this message")
+	ErrEditWindowClosed = errors.New("this message can no longer be changed")
+	ErrMessageDeleted   = errors.New("this message was deleted")
+)
+
+// EditMessagePayload: Body of "edit_message"
+type EditMessagePayload struct {
+	MessageID string `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+// DeleteMessagePayload: Body of "delete_message"
+type DeleteMessagePayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// EditPrivateMessage: Replaces the content of the sender's own message within MessageEditWindow
+// Returns the updated message for the "message_edited" event
+func EditPrivateMessage(senderID string, rawPayload json.RawMessage) (*PrivateMessagePayload, error) {
+	var edit EditMessagePayload
+	if err := json.Unmarshal(rawPayload, &edit); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(edit.Content) == "" {
+		return nil, errors.New("message cannot be empty")
+	}
+	if len(edit.Content) > MaxMessageLength {
+		return nil, fmt.Errorf("private message exceeds maximum length of %d characters", MaxMessageLength)
+	}
+
+	pm, err := loadOwnMessage(senderID, edit.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	editedAt := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	tx, err := core.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(
+		"UPDATE private_messages SET content = ?, edited_at = ? WHERE message_id = ? AND deleted_at IS NULL",
+		edit.Content, editedAt, pm.MessageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrMessageDeleted // unsent from another tab in the meantime
+	}
+	pm.Content = edit.Content
+	pm.EditedAt = editedAt
+	if err := rewriteStoredEvents(tx, pm); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	// Private content stays out of the audit log
+	audit.Record(audit.Event{ActorID: senderID, Action: "message_edited", Target: "message:" + pm.MessageID})
+	// Reactions survive an edit; the client re-renders the whole bubble from this event
+	reactions, err := getMessageReactions(pm.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	pm.Reactions = reactions[pm.MessageID]
+	// Keep the quote in the re-rendered bubble
+	if pm.ReplyToMessageID != "" {
+		if pm.ReplyTo, err = loadReplyPreview(pm.ReplyToMessageID, pm.SenderID, pm.RecipientID); err != nil {
+			return nil, err
+		}
+	}
+	return pm, nil
+}
+
+// DeletePrivateMessage: Unsends the sender's own message within MessageEditWindow
+// The row stays (history keeps its place) but the content is erased
+func DeletePrivateMessage(senderID string, rawPayload json.RawMessage) (*PrivateMessagePayload, error) {
+	var del DeleteMessagePayload
+	if err := json.Unmarshal(rawPayload, &del); err != nil {
+		return nil, err
+	}
+
+	pm, err := loadOwnMessage(senderID, del.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	deletedAt := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	tx, err := core.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(
+		"UPDATE private_messages SET content = '', deleted_at = ? WHERE message_id = ? AND deleted_at IS NULL",
+		deletedAt, pm.MessageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrMessageDeleted
+	}
+	// Nothing left to react to
+	if _, err := tx.Exec("DELETE FROM message_reactions WHERE message_id = ?", pm.MessageID); err != nil {
+		return nil, err
+	}
+	pm.Content = ""
+	pm.ReplyToMessageID = "" // the quote goes with the content
+	pm.DeletedAt = deletedAt
+	pm.EditableUntil = 0
+	if err := rewriteStoredEvents(tx, pm); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	audit.Record(audit.Event{ActorID: senderID, Action: "message_unsent", Target: "message:" + pm.MessageID})
+	return pm, nil
+}
+
+// rewriteStoredEvents: Brings the copies of a message kept for replay (user_events) in line
+// with its row, so a device catching up never gets text that was since edited or unsent
+// Quotes of the message in later replies are updated the same way
+func rewriteStoredEvents(tx *sql.Tx, pm *PrivateMessagePayload) error {
+	// Only the two participants' outboxes can hold the message
+	const scope = `user_id IN (?, ?) AND type IN ('private_message', 'message_edited')`
+	if pm.DeletedAt != "" {
+		if _, err := tx.Exec(`
+			UPDATE user_events
+			SET payload = json_remove(json_set(payload, '$.content', '', '$.deleted_at', ?),
+				'$.editable_until', '$.reply_to_message_id', '$.reply_to', '$.reactions')
+			WHERE `+scope+` AND json_extract(payload, '$.message_id') = ?
+		`, pm.DeletedAt, pm.SenderID, pm.RecipientID, pm.MessageID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			UPDATE user_events
+			SET payload = json_set(payload, '$.reply_to.content', '', '$.reply_to.deleted', json('true'))
+			WHERE `+scope+` AND json_extract(payload, '$.reply_to.message_id') = ?
+		`, pm.SenderID, pm.RecipientID, pm.MessageID)
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE user_events
+		SET payload = json_set(payload, '$.content', ?, '$.edited_at', ?)
+		WHERE `+scope+` AND json_extract(payload, '$.message_id') = ?
+	`, pm.Content, pm.EditedAt, pm.SenderID, pm.RecipientID, pm.MessageID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		UPDATE user_events
+		SET payload = json_set(payload, '$.reply_to.content', ?)
+		WHERE `+scope+` AND json_extract(payload, '$.reply_to.message_id') = ?
+	`, truncatePreview(pm.Content), pm.SenderID, pm.RecipientID, pm.MessageID)
+	return err
+}
+
+// loadOwnMessage: Fetches a message and checks that senderID may still change it
+func loadOwnMessage(senderID, messageID string) (*PrivateMessagePayload, error) {
+	var pm PrivateMessagePayload
+	var deletedAt, editedAt, replyTo sql.NullString
+	err := core.Db.QueryRow(`
+		SELECT m.message_id, m.sender_id, m.recipient_id, u.nickname, m.content, m.created_at, m.edited_at, m.deleted_at,
+			m.reply_to_message_id
+		FROM private_messages m
+		JOIN users u ON u.user_id = m.sender_id
+		WHERE m.message_id = ?
+	`, messageID).Scan(&pm.MessageID, &pm.SenderID, &pm.RecipientID, &pm.SenderNickname, &pm.Content, &pm.CreatedAt, &editedAt, &deletedAt,
+		&replyTo)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pm.SenderID != senderID {
+		return nil, ErrNotMessageSender
+	}
+	if deletedAt.Valid {
+		return nil, ErrMessageDeleted
+	}
+	pm.EditedAt = editedAt.String
+	pm.ReplyToMessageID = replyTo.String
+	pm.EditableUntil = editableUntil(pm.CreatedAt, "")
+	if pm.EditableUntil == 0 {
+		return nil, ErrEditWindowClosed
+	}
+	return &pm, nil
+}
+
+// editableUntil: End of the edit window in Unix milliseconds, 0 once closed or deleted
+func editableUntil(createdAt, deletedAt string) int64 {
+	created, err := strconv.ParseInt(createdAt, 10, 64)
+	if err != nil || deletedAt != "" {
+		return 0
+	}
+	until := created + core.AppConfig.MessageEditWindow.Milliseconds()
+	if until <= time.Now().UnixMilli() {
+		return 0
+	}
+	return until
+}
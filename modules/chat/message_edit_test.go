@@ -0,0 +1,129 @@
+package chat
+
+import (
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"real-time-forum/modules/core"
+
+	"github.com/google/uuid"
+)
+
+func openTestDB(t *testing.T) {
+	t.Helper()
+	core.InitDB(filepath.Join(t.TempDir(), "forum.db"))
+	t.Cleanup(func() { core.Db.Close() })
+}
+
+func insertTestUser(t *testing.T, nickname string) string {
+	t.Helper()
+	userID := uuid.New().String()
+	_, err := core.Db.Exec(`INSERT INTO users (user_id, first_name, last_name, nickname, age, gender, email, password, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		userID, "Test", "User", nickname, 30, "", nickname+"@example.com", "")
+	if err != nil {
+		t.Fatalf("insert user %s: %v", nickname, err)
+	}
+	return userID
+}
+
+// sendStored: Sends a message and keeps it in both outboxes, like the WebSocket handler does
+func sendStored(t *testing.T, senderID, recipientID, content, replyTo string) *PrivateMessagePayload {
+	t.Helper()
+	raw, _ := json.Marshal(PrivateMessagePayload{RecipientID: recipientID, Content: content, ReplyToMessageID: replyTo})
+	pm, err := ProcessPrivateMessage(slog.Default(), senderID, raw)
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	payload, _ := json.Marshal(pm)
+	for _, userID := range []string{senderID, recipientID} {
+		_, err := core.Db.Exec("INSERT INTO user_events (user_id, type, payload, created_at) VALUES (?, 'private_message', ?, ?)",
+			userID, string(payload), time.Now())
+		if err != nil {
+			t.Fatalf("store event: %v", err)
+		}
+	}
+	return pm
+}
+
+// storedMessages: Replay copies of the message in the user's outbox
+func storedMessages(t *testing.T, userID, messageID string) []PrivateMessagePayload {
+	t.Helper()
+	rows, err := core.Db.Query("SELECT payload FROM user_events WHERE user_id = ? ORDER BY seq", userID)
+	if err != nil {
+		t.Fatalf("load events: %v", err)
+	}
+	defer rows.Close()
+	var found []PrivateMessagePayload
+	for rows.Next() {
+		var payload string
+		rows.Scan(&payload)
+		var pm PrivateMessagePayload
+		if err := json.Unmarshal([]byte(payload), &pm); err != nil {
+			t.Fatalf("stored payload %s: %v", payload, err)
+		}
+		if pm.MessageID == messageID {
+			found = append(found, pm)
+		}
+	}
+	return found
+}
+
+func TestEditRewritesStoredEvents(t *testing.T) {
+	openTestDB(t)
+	alice, bob := insertTestUser(t, "alice"), insertTestUser(t, "bob")
+	original := sendStored(t, alice, bob, "see you at 5", "")
+	reply := sendStored(t, bob, alice, "ok", original.MessageID)
+
+	raw, _ := json.Marshal(EditMessagePayload{MessageID: original.MessageID, Content: "see you at 6"})
+	edited, err := EditPrivateMessage(alice, raw)
+	if err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+
+	for _, userID := range []string{alice, bob} {
+		stored := storedMessages(t, userID, original.MessageID)
+		if len(stored) != 1 || stored[0].Content != "see you at 6" || stored[0].EditedAt != edited.EditedAt {
+			t.Fatalf("stored copies after edit = %+v, want the edited content", stored)
+		}
+		quoting := storedMessages(t, userID, reply.MessageID)
+		if len(quoting) != 1 || quoting[0].ReplyTo == nil || quoting[0].ReplyTo.Content != "see you at 6" {
+			t.Fatalf("stored reply after edit = %+v, want the edited quote", quoting)
+		}
+	}
+}
+
+func TestDeleteRewritesStoredEvents(t *testing.T) {
+	openTestDB(t)
+	alice, bob := insertTestUser(t, "alice"), insertTestUser(t, "bob")
+	original := sendStored(t, alice, bob, "my password is hunter2", "")
+	reply := sendStored(t, bob, alice, "what?", original.MessageID)
+	other := sendStored(t, alice, bob, "unrelated", "")
+
+	raw, _ := json.Marshal(DeleteMessagePayload{MessageID: original.MessageID})
+	deleted, err := DeletePrivateMessage(alice, raw)
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	var leaks int
+	core.Db.QueryRow("SELECT COUNT(*) FROM user_events WHERE payload LIKE '%hunter2%'").Scan(&leaks)
+	if leaks != 0 {
+		t.Fatalf("%d stored events still carry the unsent text", leaks)
+	}
+	for _, userID := range []string{alice, bob} {
+		stored := storedMessages(t, userID, original.MessageID)
+		if len(stored) != 1 || stored[0].Content != "" || stored[0].DeletedAt != deleted.DeletedAt || stored[0].EditableUntil != 0 {
+			t.Fatalf("stored copies after unsend = %+v, want a deleted message", stored)
+		}
+		quoting := storedMessages(t, userID, reply.MessageID)
+		if len(quoting) != 1 || quoting[0].ReplyTo == nil || !quoting[0].ReplyTo.Deleted || quoting[0].ReplyTo.Content != "" {
+			t.Fatalf("stored reply after unsend = %+v, want a deleted quote", quoting)
+		}
+		if kept := storedMessages(t, userID, other.MessageID); len(kept) != 1 || kept[0].Content != "unrelated" {
+			t.Fatalf("other message changed: %+v", kept)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package chat
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"real-time-forum/modules/core"
+)
+
+var (
+	ErrReactionNotAllowed = errors.New("this reaction is not available")
+	ErrNotParticipant     = errors.New("you are not part of this conversation")
+)
+
+// ReactMessagePayload: Body of "react_message" and "unreact_message"
+type ReactMessagePayload struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// MessageReaction: Aggregated count of one emoji on a message
+// UserIDs lets each participant tell whether the reaction is theirs
+type MessageReaction struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"user_ids"`
+}
+
+// MessageReactionsEvent: Body of "message_reactions" - the full reaction set of a message after a change
+type MessageReactionsEvent struct {
+	MessageID   string            `json:"message_id"`
+	SenderID    string            `json:"sender_id"`
+	RecipientID string            `json:"recipient_id"`
+	Reactions   []MessageReaction `json:"reactions"`
+}
+
+// ReactToMessage: Adds (add=true) or removes one of the user's reactions on a message of their conversation
+// Returns the message's reactions after the change, for both participants
+func ReactToMessage(userID string, rawPayload json.RawMessage, add bool) (*MessageReactionsEvent, error) {
+	var react ReactMessagePayload
+	if err := json.Unmarshal(rawPayload, &react); err != nil {
+		return nil, err
+	}
+	if !reactionAllowed(react.Emoji) {
+		return nil, ErrReactionNotAllowed
+	}
+
+	event := MessageReactionsEvent{MessageID: react.MessageID}
+	var deletedAt sql.NullString
+	err := core.Db.QueryRow(
+		"SELECT sender_id, recipient_id, deleted_at FROM private_messages WHERE message_id = ?",
+		react.MessageID,
+	).Scan(&event.SenderID, &event.RecipientID, &deletedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if userID != event.SenderID && userID != event.RecipientID {
+		return nil, ErrNotParticipant
+	}
+	if deletedAt.Valid {
+		return nil, ErrMessageDeleted
+	}
+
+	if add {
+		_, err = core.Db.Exec(
+			"INSERT OR IGNORE INTO message_reactions (message_id, user_id, emoji, created_at) VALUES (?, ?, ?, ?)",
+			react.MessageID, userID, react.Emoji, time.Now().UnixMilli(),
+		)
+	} else {
+		_, err = core.Db.Exec(
+			"DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? AND emoji = ?",
+			react.MessageID, userID, react.Emoji,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reactions, err := getMessageReactions(react.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	event.Reactions = reactions[react.MessageID]
+	if event.Reactions == nil {
+		event.Reactions = []MessageReaction{} // the client replaces its list, so send [] rather than null
+	}
+	return &event, nil
+}
+
+// getMessageReactions: Reactions of the given messages, grouped per message in order of first use
+func getMessageReactions(messageIDs ...string) (map[string][]MessageReaction, error) {
+	grouped := make(map[string][]MessageReaction)
+	if len(messageIDs) == 0 {
+		return grouped, nil
+	}
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	rows, err := core.Db.Query(`
+		SELECT message_id, emoji, user_id FROM message_reactions
+		WHERE message_id IN (?`+strings.Repeat(", ?", len(messageIDs)-1)+`)
+		ORDER BY created_at, user_id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, emoji, userID string
+		if err := rows.Scan(&messageID, &emoji, &userID); err != nil {
+			return nil, err
+		}
+		reactions := grouped[messageID]
+		found := false
+		for i := range reactions {
+			if reactions[i].Emoji == emoji {
+				reactions[i].Count++
+				reactions[i].UserIDs = append(reactions[i].UserIDs, userID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			reactions = append(reactions, MessageReaction{Emoji: emoji, Count: 1, UserIDs: []string{userID}})
+		}
+		grouped[messageID] = reactions
+	}
+	return grouped, rows.Err()
+}
+
+func reactionAllowed(emoji string) bool {
+	for _, allowed := range core.AppConfig.MessageReactions {
+		if emoji == allowed {
+			return true
+		}
+	}
+	return false
+}
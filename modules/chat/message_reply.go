@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"database/sql"
+	"errors"
+
+	"real-time-forum/modules/core"
+)
+
+// ReplyPreviewLength: Characters of the quoted message kept in a reply preview
+const ReplyPreviewLength = 100
+
+var ErrReplyNotInConversation = errors.New("the quoted message is not part of this conversation")
+
+// ReplyPreview: Quoted message embedded in a reply, as it looks now
+// Content is truncated and empty once the quoted message was unsent
+type ReplyPreview struct {
+	MessageID      string `json:"message_id"`
+	SenderID       string `json:"sender_id"`
+	SenderNickname string `json:"sender_nickname"`
+	Content        string `json:"content"`
+	Deleted        bool   `json:"deleted"`
+}
+
+// loadReplyPreview: Preview of messageID, which must belong to the conversation of userA and userB
+func loadReplyPreview(messageID, userA, userB string) (*ReplyPreview, error) {
+	var preview ReplyPreview
+	var recipientID string
+	var deletedAt sql.NullString
+	err := core.Db.QueryRow(`
+		SELECT m.message_id, m.sender_id, m.recipient_id, u.nickname, m.content, m.deleted_at
+		FROM private_messages m
+		JOIN users u ON u.user_id = m.sender_id
+		WHERE m.message_id = ?
+	`, messageID).Scan(&preview.MessageID, &preview.SenderID, &recipientID, &preview.SenderNickname, &preview.Content, &deletedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrReplyNotInConversation
+	}
+	if err != nil {
+		return nil, err
+	}
+	sameConversation := (preview.SenderID == userA && recipientID == userB) ||
+		(preview.SenderID == userB && recipientID == userA)
+	if !sameConversation {
+		return nil, ErrReplyNotInConversation
+	}
+	preview.Deleted = deletedAt.Valid
+	preview.Content = truncatePreview(preview.Content)
+	return &preview, nil
+}
+
+// truncatePreview: Cuts on a character boundary and marks the cut
+func truncatePreview(content string) string {
+	runes := []rune(content)
+	if len(runes) <= ReplyPreviewLength {
+		return content
+	}
+	return string(runes[:ReplyPreviewLength]) + "…"
+}
@@ -1,13 +1,245 @@
 package core
 
+import (
+	"os"
+	"strings"
+	"time"
+)
+
 type Config struct {
-    ServerPort   string
-    DatabasePath string
+	ServerPort   string
+	DatabasePath string
+
+	LogLevel  string // debug, info, warn or error
+	LogFormat string // text or json
+
+	MetricsToken string // bearer token required on /metrics; open when empty
+
+	// Sessions slide forward on activity until they hit their absolute lifetime
+	SessionIdleTimeout     time.Duration
+	SessionMaxLifetime     time.Duration
+	RememberMeIdleTimeout  time.Duration
+	RememberMeMaxLifetime  time.Duration
+	SessionRefreshInterval time.Duration // min delay between two expiry extensions (limits DB writes)
+	SessionExpiryWarning   time.Duration // how long before expiry the client gets "session_expiring"
+
+	CookieSecure bool // Secure flag on auth cookies (browsers accept it on http://localhost)
+
+	AuthCacheTTL time.Duration // how long the REST middleware trusts a resolved session
+
+	AdminUsers []string // nicknames or emails promoted to the admin role at startup
+
+	// Login brute-force protection: failures within the window count towards a lockout
+	// that doubles with every extra failure, from LoginLockoutBase up to LoginLockoutMax
+	LoginMaxAccountFailures int
+	LoginMaxIPFailures      int
+	LoginFailureWindow      time.Duration
+	LoginLockoutBase        time.Duration
+	LoginLockoutMax         time.Duration
+
+	TOTPIssuer           string        // label shown in authenticator apps
+	PendingLoginTTL      time.Duration // lifetime of the token between password and 2FA steps
+	PendingLoginMaxTries int
+
+	OIDCProviders []OIDCProviderConfig // external login providers (company SSO)
+	OAuthStateTTL time.Duration        // how long an authorization request may take
+
+	// WebSocket flood protection: token buckets per message type, per connection and per user
+	// (all tabs of a user share the user bucket). Types without an entry are not limited
+	WSRateLimits map[string]WSMessageLimit
+	// A connection collecting WSRateLimitStrikes rejected messages within the window is closed
+	WSRateLimitStrikes      int
+	WSRateLimitStrikeWindow time.Duration
+
+	// WebSocket connection hardening
+	WSAllowedOrigins  []string      // cross-origin pages allowed to connect (same origin always is)
+	WSPongWait        time.Duration // a connection silent for this long (no pong) is dropped
+	WSPingPeriod      time.Duration // must be shorter than WSPongWait
+	WSWriteWait       time.Duration // max time for a single write
+	WSMaxConnsPerUser int
+	WSMaxConnsPerIP   int
+	WSSendBufferSize  int // queued outgoing messages per connection before it is dropped as too slow
+
+	// Real-time backplane for running several instances: with BrokerURL set
+	// (redis://[:password@]host:port), WebSocket events and presence go through Redis pub/sub
+	BrokerURL            string
+	BrokerChannel        string
+	PresenceSyncInterval time.Duration // nodes re-announce their users; silent nodes expire after 3 intervals
+
+	MessageEditWindow time.Duration // how long the sender may edit or unsend a private message
+	MessageReactions  []string      // emoji allowed as private message reactions (mirrored in web/js/components.js)
+
+	// Post and comment reactions; keys are what the database stores, so keep them stable
+	// once used (removing an entry hides its existing reactions instead of deleting them)
+	Reactions []ReactionType
+
+	// Offline delivery: durable events are kept per user and replayed after reconnecting
+	DeliveryReplayBatch int           // events per replay round (below WSSendBufferSize)
+	DeliveryRetention   time.Duration // events older than this are pruned, acknowledged or not
+
+	AuditRetention time.Duration // audit events older than this are pruned
+}
+
+// RateLimit: Token bucket - Burst messages at once, refilled at Rate messages per second
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// WSMessageLimit: Limits for one WebSocket message type
+type WSMessageLimit struct {
+	PerConnection RateLimit
+	PerUser       RateLimit
+}
+
+// ReactionType: One entry of the post/comment reaction registry
+// Score keeps the like/dislike totals: +1 counts as a like, -1 as a dislike, 0 only shows up per type
+type ReactionType struct {
+	Key   string `json:"key"`
+	Emoji string `json:"emoji"`
+	Label string `json:"label"`
+	Score int    `json:"score"`
+}
+
+// Reaction: Looks up a registry entry by key
+func (c *Config) Reaction(key string) (ReactionType, bool) {
+	for _, r := range c.Reactions {
+		if r.Key == key {
+			return r, true
+		}
+	}
+	return ReactionType{}, false
 }
 
+// OIDCProviderConfig: One OpenID Connect identity provider
+type OIDCProviderConfig struct {
+	Name         string // URL segment: /api/auth/oidc/{name}/login
+	DisplayName  string // button label on the login page
+	Issuer       string // discovery is read from {Issuer}/.well-known/openid-configuration
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// AppConfig: Shared configuration used by every module
+var AppConfig = LoadConfig()
+
 func LoadConfig() *Config {
-    return &Config{
-        ServerPort  : ":8080",
-        DatabasePath: "./r-forum.db",
-    }
+	return &Config{
+		ServerPort:   envOr("SERVER_PORT", ":8080"),
+		DatabasePath: "./r-forum.db",
+
+		LogLevel:  envOr("LOG_LEVEL", "info"),
+		LogFormat: envOr("LOG_FORMAT", "text"),
+
+		MetricsToken: os.Getenv("METRICS_TOKEN"),
+
+		SessionIdleTimeout:     24 * time.Hour,
+		SessionMaxLifetime:     7 * 24 * time.Hour,
+		RememberMeIdleTimeout:  30 * 24 * time.Hour,
+		RememberMeMaxLifetime:  90 * 24 * time.Hour,
+		SessionRefreshInterval: 5 * time.Minute,
+		SessionExpiryWarning:   5 * time.Minute,
+
+		CookieSecure: true,
+
+		AuthCacheTTL: 30 * time.Second,
+
+		AdminUsers: splitList(os.Getenv("ADMIN_USERS")),
+
+		LoginMaxAccountFailures: 5,
+		LoginMaxIPFailures:      20,
+		LoginFailureWindow:      15 * time.Minute,
+		LoginLockoutBase:        30 * time.Second,
+		LoginLockoutMax:         time.Hour,
+
+		TOTPIssuer:           "RealTimeForum",
+		PendingLoginTTL:      5 * time.Minute,
+		PendingLoginMaxTries: 5,
+
+		OIDCProviders: oidcProvidersFromEnv(),
+		OAuthStateTTL: 10 * time.Minute,
+
+		WSRateLimits: map[string]WSMessageLimit{
+			"private_message":  {PerConnection: RateLimit{Rate: 1, Burst: 5}, PerUser: RateLimit{Rate: 2, Burst: 10}},
+			"typing":           {PerConnection: RateLimit{Rate: 2, Burst: 5}, PerUser: RateLimit{Rate: 4, Burst: 10}},
+			"users_list":       {PerConnection: RateLimit{Rate: 0.2, Burst: 3}, PerUser: RateLimit{Rate: 0.5, Burst: 6}},
+			"get_chat_history": {PerConnection: RateLimit{Rate: 1, Burst: 5}, PerUser: RateLimit{Rate: 2, Burst: 10}},
+			"edit_message":     {PerConnection: RateLimit{Rate: 0.5, Burst: 5}, PerUser: RateLimit{Rate: 1, Burst: 10}},
+			"delete_message":   {PerConnection: RateLimit{Rate: 0.5, Burst: 5}, PerUser: RateLimit{Rate: 1, Burst: 10}},
+			"react_message":    {PerConnection: RateLimit{Rate: 1, Burst: 10}, PerUser: RateLimit{Rate: 2, Burst: 20}},
+			"unreact_message":  {PerConnection: RateLimit{Rate: 1, Burst: 10}, PerUser: RateLimit{Rate: 2, Burst: 20}},
+			"ack":              {PerConnection: RateLimit{Rate: 5, Burst: 20}, PerUser: RateLimit{Rate: 10, Burst: 40}},
+			"replay":           {PerConnection: RateLimit{Rate: 1, Burst: 5}, PerUser: RateLimit{Rate: 2, Burst: 10}},
+		},
+		WSRateLimitStrikes:      20,
+		WSRateLimitStrikeWindow: time.Minute,
+
+		WSAllowedOrigins:  splitList(os.Getenv("WS_ALLOWED_ORIGINS")),
+		WSPongWait:        60 * time.Second,
+		WSPingPeriod:      50 * time.Second,
+		WSWriteWait:       10 * time.Second,
+		WSMaxConnsPerUser: 10,
+		WSMaxConnsPerIP:   30,
+		WSSendBufferSize:  256,
+
+		BrokerURL:            os.Getenv("BROKER_URL"),
+		BrokerChannel:        envOr("BROKER_CHANNEL", "real-time-forum:ws"),
+		PresenceSyncInterval: 15 * time.Second,
+
+		MessageEditWindow: 15 * time.Minute,
+		MessageReactions:  []string{"👍", "❤️", "😂", "😮", "😢", "🎉"},
+
+		Reactions: []ReactionType{
+			{Key: "like", Emoji: "👍", Label: "Like", Score: 1},
+			{Key: "dislike", Emoji: "👎", Label: "Dislike", Score: -1},
+			{Key: "love", Emoji: "❤️", Label: "Love"},
+			{Key: "haha", Emoji: "😂", Label: "Haha"},
+			{Key: "wow", Emoji: "😮", Label: "Wow"},
+			{Key: "sad", Emoji: "😢", Label: "Sad"},
+		},
+
+		DeliveryReplayBatch: 100,
+		DeliveryRetention:   14 * 24 * time.Hour,
+
+		AuditRetention: 180 * 24 * time.Hour,
+	}
+}
+
+// oidcProvidersFromEnv: The company identity provider is configured through OIDC_* variables
+// (credentials stay out of the source); no OIDC_ISSUER means SSO is disabled
+func oidcProvidersFromEnv() []OIDCProviderConfig {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	name := envOr("OIDC_PROVIDER_NAME", "sso")
+	return []OIDCProviderConfig{{
+		Name:         name,
+		DisplayName:  envOr("OIDC_DISPLAY_NAME", "Company SSO"),
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  envOr("OIDC_REDIRECT_URL", "http://localhost:8080/api/auth/oidc/"+name+"/callback"),
+		Scopes:       []string{"openid", "email", "profile"},
+	}}
+}
+
+// splitList: Comma-separated environment value, blanks dropped
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
 }
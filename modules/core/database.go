@@ -2,7 +2,9 @@ package core
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
+	"log/slog"
 
 	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
@@ -10,6 +12,13 @@ import (
 
 var Db *sql.DB
 
+// Shared author of the posts, comments and private messages left behind by deleted accounts
+// Its empty password hash never matches, so nobody can log in as it
+const (
+	DeletedUserID       = "deleted-user"
+	DeletedUserNickname = "deleted user"
+)
+
 func InitDB(path string) {
 	var err error
 	Db, err = sql.Open("sqlite3", path)
@@ -31,6 +40,16 @@ func InitDB(path string) {
 	createCommentsReactionsTable()
 	createPostsReactionsTable()
 	createSessionsTable()
+	createLoginAttemptsTable()
+	createAuditEventsTable()
+	createRecoveryCodesTable()
+	createPendingLoginsTable()
+	createUserIdentitiesTable()
+	createOAuthStatesTable()
+	createUserEventsTable()
+	createDeliveryCursorsTable()
+	createMessageReactionsTable()
+	createUserSuspensionsTable()
 }
 
 func createUsersTable() {
@@ -43,13 +62,31 @@ func createUsersTable() {
         age INTEGER NOT NULL,
 		gender TEXT,
         email TEXT NOT NULL UNIQUE,
-        password TEXT NOT NULL
+        password TEXT NOT NULL,
+        role TEXT NOT NULL DEFAULT 'user',
+        totp_secret TEXT NOT NULL DEFAULT '',
+        totp_enabled INTEGER NOT NULL DEFAULT 0,
+        totp_last_step INTEGER NOT NULL DEFAULT 0,
+        created_at DATETIME
     );`
 
 	_, err := Db.Exec(query)
 	if err != nil {
 		log.Fatalf("Failed to create users table: %v", err)
 	}
+
+	ensureColumn("users", "role", "TEXT NOT NULL DEFAULT 'user'")
+	ensureColumn("users", "totp_secret", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn("users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn("users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0")
+	// Registration time, set on insert; accounts older than the column have none
+	ensureColumn("users", "created_at", "DATETIME")
+
+	_, err = Db.Exec(`INSERT OR IGNORE INTO users (user_id, first_name, last_name, nickname, age, gender, email, password)
+        VALUES (?, 'Deleted', 'User', ?, 0, '', 'deleted-user@invalid', '')`, DeletedUserID, DeletedUserNickname)
+	if err != nil {
+		log.Fatalf("Failed to create the deleted user placeholder: %v", err)
+	}
 }
 
 func createCategoriesTable() {
@@ -70,7 +107,7 @@ func createCategoriesTable() {
 		_, err := Db.Exec("INSERT OR IGNORE INTO categories (category_id, category_name) VALUES (?, ?)",
 			uuid.NewString(), cat)
 		if err != nil {
-			log.Printf("Warning: Failed to insert default category %s: %v", cat, err)
+			slog.Warn("Failed to insert default category", "category", cat, "err", err)
 		}
 	}
 }
@@ -107,21 +144,25 @@ func createPostsTable() {
 	}
 }
 
+// createPostsReactionsTable: One reaction per user and post; reaction is a key of AppConfig.Reactions
 func createPostsReactionsTable() {
 	query := `
-    CREATE TABLE IF NOT EXISTS posts_reactions(
+    CREATE TABLE IF NOT EXISTS %s(
         post_id TEXT NOT NULL,
         user_id TEXT NOT NULL,
-        reaction_type INTEGER NOT NULL DEFAULT 0,
+        reaction TEXT NOT NULL,
+        created_at DATETIME,
         PRIMARY KEY (post_id, user_id),
         FOREIGN KEY (user_id) REFERENCES users(user_id),
         FOREIGN KEY (post_id) REFERENCES posts(post_id)              
     );`
 
-	_, err := Db.Exec(query)
+	_, err := Db.Exec(fmt.Sprintf(query, "posts_reactions"))
 	if err != nil {
 		log.Fatalf("Failed to create posts_reactions table: %v", err)
 	}
+	migrateReactionType("posts_reactions", "post_id", query)
+	ensureColumn("posts_reactions", "created_at", "DATETIME")
 }
 
 func createCommentsTable() {
@@ -142,21 +183,62 @@ func createCommentsTable() {
 	}
 }
 
+// createCommentsReactionsTable: One reaction per user and comment; reaction is a key of AppConfig.Reactions
 func createCommentsReactionsTable() {
 	query := `
-    CREATE TABLE IF NOT EXISTS comments_reactions(
+    CREATE TABLE IF NOT EXISTS %s(
         comment_id TEXT NOT NULL,
         user_id TEXT NOT NULL,
-        reaction_type INTEGER NOT NULL DEFAULT 0,
+        reaction TEXT NOT NULL,
+        created_at DATETIME,
         PRIMARY KEY (comment_id, user_id),
         FOREIGN KEY (comment_id) REFERENCES comments(comment_id),
         FOREIGN KEY (user_id) REFERENCES users(user_id)               
         );`
 
-	_, err := Db.Exec(query)
+	_, err := Db.Exec(fmt.Sprintf(query, "comments_reactions"))
 	if err != nil {
 		log.Fatalf("Failed to create comments_reactions table: %v", err)
 	}
+	migrateReactionType("comments_reactions", "comment_id", query)
+	ensureColumn("comments_reactions", "created_at", "DATETIME")
+}
+
+// migrateReactionType: Rebuilds a reactions table created with the old integer reaction_type
+// column (1 = like, -1 = dislike) into the keyed schema, in one transaction
+// createQuery is the current schema with %s in place of the table name
+func migrateReactionType(table, idColumn, createQuery string) {
+	var legacy bool
+	err := Db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = 'reaction_type'", table).Scan(&legacy)
+	if err != nil {
+		log.Fatalf("Failed to inspect %s table: %v", table, err)
+	}
+	if !legacy {
+		return
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		log.Fatalf("Failed to migrate %s: %v", table, err)
+	}
+	defer tx.Rollback()
+	steps := []string{
+		fmt.Sprintf(createQuery, table+"_new"),
+		fmt.Sprintf(`INSERT INTO %[1]s_new (%[2]s, user_id, reaction)
+            SELECT %[2]s, user_id, CASE reaction_type WHEN 1 THEN 'like' ELSE 'dislike' END
+            FROM %[1]s WHERE reaction_type IN (1, -1)`, table, idColumn),
+		"DROP TABLE " + table,
+		fmt.Sprintf("ALTER TABLE %[1]s_new RENAME TO %[1]s", table),
+	}
+	for _, step := range steps {
+		if _, err := tx.Exec(step); err != nil {
+			log.Fatalf("Failed to migrate %s: %v", table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("Failed to migrate %s: %v", table, err)
+	}
+	slog.Info("Migrated table to keyed reactions", "table", table)
 }
 
 func createSessionsTable() {
@@ -166,6 +248,12 @@ func createSessionsTable() {
         created_at DATETIME DEFAULT CURRENT_TIMESTAMP, 
         expires_at DATETIME,
         user_id TEXT NOT NULL,
+        device_label TEXT NOT NULL DEFAULT '',
+        user_agent TEXT NOT NULL DEFAULT '',
+        ip_address TEXT NOT NULL DEFAULT '',
+        last_used_at DATETIME,
+        absolute_expires_at DATETIME,
+        remember_me INTEGER NOT NULL DEFAULT 0,
         FOREIGN KEY (user_id) REFERENCES users(user_id)
     );`
 
@@ -174,14 +262,236 @@ func createSessionsTable() {
 		log.Fatalf("Failed to create sessions table: %v", err)
 	}
 
+	// Databases created before multi-device sessions lack the metadata columns
+	ensureColumn("sessions", "device_label", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn("sessions", "user_agent", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn("sessions", "ip_address", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn("sessions", "last_used_at", "DATETIME")
+	ensureColumn("sessions", "absolute_expires_at", "DATETIME")
+	ensureColumn("sessions", "remember_me", "INTEGER NOT NULL DEFAULT 0")
+
 	indexQuery := `
-    CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);`
+    CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+    CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);`
 	_, err = Db.Exec(indexQuery)
 	if err != nil {
 		log.Fatalf("Failed to create index on sessions: %v", err)
 	}
 }
 
+func createLoginAttemptsTable() {
+	query := `
+    CREATE TABLE IF NOT EXISTS login_attempts(
+        attempt_key TEXT PRIMARY KEY,
+        failures INTEGER NOT NULL DEFAULT 0,
+        last_failure_at DATETIME,
+        locked_until DATETIME
+    );`
+
+	_, err := Db.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create login_attempts table: %v", err)
+	}
+}
+
+func createRecoveryCodesTable() {
+	query := `
+    CREATE TABLE IF NOT EXISTS recovery_codes(
+        user_id TEXT NOT NULL,
+        code_hash TEXT NOT NULL,
+        used_at DATETIME,
+        PRIMARY KEY (user_id, code_hash),
+        FOREIGN KEY (user_id) REFERENCES users(user_id)
+    );`
+
+	_, err := Db.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create recovery_codes table: %v", err)
+	}
+}
+
+func createPendingLoginsTable() {
+	query := `
+    CREATE TABLE IF NOT EXISTS pending_logins(
+        token TEXT PRIMARY KEY,
+        user_id TEXT NOT NULL,
+        remember_me INTEGER NOT NULL DEFAULT 0,
+        attempts INTEGER NOT NULL DEFAULT 0,
+        expires_at DATETIME NOT NULL,
+        FOREIGN KEY (user_id) REFERENCES users(user_id)
+    );`
+
+	_, err := Db.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create pending_logins table: %v", err)
+	}
+}
+
+// createUserIdentitiesTable: Links an external login (provider + subject) to a local user
+func createUserIdentitiesTable() {
+	query := `
+    CREATE TABLE IF NOT EXISTS user_identities(
+        provider TEXT NOT NULL,
+        subject TEXT NOT NULL,
+        user_id TEXT NOT NULL,
+        email TEXT NOT NULL DEFAULT '',
+        created_at DATETIME NOT NULL,
+        PRIMARY KEY (provider, subject),
+        FOREIGN KEY (user_id) REFERENCES users(user_id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);`
+
+	_, err := Db.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create user_identities table: %v", err)
+	}
+}
+
+// createOAuthStatesTable: In-flight authorization requests (state, PKCE verifier, nonce)
+func createOAuthStatesTable() {
+	query := `
+    CREATE TABLE IF NOT EXISTS oauth_states(
+        state TEXT PRIMARY KEY,
+        provider TEXT NOT NULL,
+        code_verifier TEXT NOT NULL,
+        nonce TEXT NOT NULL,
+        remember_me INTEGER NOT NULL DEFAULT 0,
+        expires_at DATETIME NOT NULL
+    );`
+
+	_, err := Db.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create oauth_states table: %v", err)
+	}
+}
+
+// createUserEventsTable: Per-user outbox of durable real-time events (private messages, ...)
+// seq orders a user's events; clients acknowledge it so reconnects replay only what they missed
+func createUserEventsTable() {
+	query := `
+    CREATE TABLE IF NOT EXISTS user_events(
+        seq INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id TEXT NOT NULL,
+        type TEXT NOT NULL,
+        payload TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        FOREIGN KEY (user_id) REFERENCES users(user_id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_user_events_user_seq ON user_events(user_id, seq);
+    CREATE INDEX IF NOT EXISTS idx_user_events_created_at ON user_events(created_at);`
+
+	_, err := Db.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create user_events table: %v", err)
+	}
+}
+
+// createDeliveryCursorsTable: Highest user_events seq each user has acknowledged
+func createDeliveryCursorsTable() {
+	query := `
+    CREATE TABLE IF NOT EXISTS delivery_cursors(
+        user_id TEXT PRIMARY KEY,
+        acked_seq INTEGER NOT NULL DEFAULT 0,
+        updated_at DATETIME NOT NULL,
+        FOREIGN KEY (user_id) REFERENCES users(user_id)
+    );`
+
+	_, err := Db.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create delivery_cursors table: %v", err)
+	}
+}
+
+// createMessageReactionsTable: Emoji reactions on private messages, one row per user and emoji
+func createMessageReactionsTable() {
+	query := `
+    CREATE TABLE IF NOT EXISTS message_reactions(
+        message_id TEXT NOT NULL,
+        user_id TEXT NOT NULL,
+        emoji TEXT NOT NULL,
+        created_at BIGINT NOT NULL,
+        PRIMARY KEY (message_id, user_id, emoji),
+        FOREIGN KEY (message_id) REFERENCES private_messages(message_id),
+        FOREIGN KEY (user_id) REFERENCES users(user_id)
+    );`
+
+	_, err := Db.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create message_reactions table: %v", err)
+	}
+}
+
+// createUserSuspensionsTable: At most one restriction per user, set by an admin
+// expires_at NULL is a permanent ban; an expired row no longer restricts anything
+func createUserSuspensionsTable() {
+	query := `
+    CREATE TABLE IF NOT EXISTS user_suspensions(
+        user_id TEXT PRIMARY KEY,
+        reason TEXT NOT NULL,
+        expires_at DATETIME,
+        created_by TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        FOREIGN KEY (user_id) REFERENCES users(user_id)
+    );`
+
+	_, err := Db.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create user_suspensions table: %v", err)
+	}
+}
+
+// createAuditEventsTable: Append-only log - updates are rejected, rows only leave through retention pruning
+func createAuditEventsTable() {
+	query := `
+    CREATE TABLE IF NOT EXISTS audit_events(
+        event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+        created_at DATETIME NOT NULL,
+        actor_id TEXT NOT NULL DEFAULT '',
+        action TEXT NOT NULL,
+        target TEXT NOT NULL DEFAULT '',
+        ip_address TEXT NOT NULL DEFAULT '',
+        user_agent TEXT NOT NULL DEFAULT '',
+        details TEXT NOT NULL DEFAULT '{}'
+    );
+    CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+    CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action, created_at);
+    CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor_id, created_at);
+    CREATE TRIGGER IF NOT EXISTS audit_events_append_only BEFORE UPDATE ON audit_events
+    BEGIN
+        SELECT RAISE(ABORT, 'audit_events is append-only');
+    END;`
+
+	_, err := Db.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create audit_events table: %v", err)
+	}
+}
+
+// ensureColumn: Adds a column to an existing table if it is missing (lightweight migration)
+func ensureColumn(table, column, definition string) {
+	rows, err := Db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		log.Fatalf("Failed to inspect %s table: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			log.Fatalf("Failed to inspect %s table: %v", table, err)
+		}
+		if name == column {
+			return
+		}
+	}
+	rows.Close()
+
+	_, err = Db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	if err != nil {
+		log.Fatalf("Failed to add column %s.%s: %v", table, column, err)
+	}
+}
+
 func createPrvMsgsTable() {
     query := `
     CREATE TABLE IF NOT EXISTS private_messages (
@@ -197,4 +507,9 @@ func createPrvMsgsTable() {
     if err != nil {
         log.Fatalf("Failed to create messages table: %v", err)
     }
+    // Unix milliseconds like created_at; NULL until the sender edits / unsends the message
+    ensureColumn("private_messages", "edited_at", "BIGINT")
+    ensureColumn("private_messages", "deleted_at", "BIGINT")
+    // Quoted message of the same conversation, NULL for plain messages
+    ensureColumn("private_messages", "reply_to_message_id", "TEXT")
 }
\ No newline at end of file
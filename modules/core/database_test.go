@@ -0,0 +1,114 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// legacyReactionsDB: A database whose reaction tables still use the integer reaction_type
+func legacyReactionsDB(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "forum.db")
+	InitDB(path)
+	steps := []string{
+		`INSERT INTO users (user_id, first_name, last_name, nickname, age, gender, email, password)
+			VALUES ('u1', 'Ann', 'Lee', 'ann', 30, '', 'ann@example.com', ''),
+			       ('u2', 'Bob', 'Ray', 'bob', 30, '', 'bob@example.com', ''),
+			       ('u3', 'Cid', 'Moe', 'cid', 30, '', 'cid@example.com', '')`,
+		`INSERT INTO posts (post_id, content, user_id) VALUES ('p1', 'hello', 'u1')`,
+		`INSERT INTO comments (comment_id, content, user_id, post_id) VALUES ('c1', 'hi', 'u2', 'p1')`,
+		`DROP TABLE posts_reactions`,
+		`CREATE TABLE posts_reactions(
+			post_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			reaction_type INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (post_id, user_id),
+			FOREIGN KEY (user_id) REFERENCES users(user_id),
+			FOREIGN KEY (post_id) REFERENCES posts(post_id))`,
+		`INSERT INTO posts_reactions (post_id, user_id, reaction_type) VALUES ('p1', 'u1', 1), ('p1', 'u2', -1), ('p1', 'u3', 0)`,
+		`DROP TABLE comments_reactions`,
+		`CREATE TABLE comments_reactions(
+			comment_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			reaction_type INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (comment_id, user_id),
+			FOREIGN KEY (comment_id) REFERENCES comments(comment_id),
+			FOREIGN KEY (user_id) REFERENCES users(user_id))`,
+		`INSERT INTO comments_reactions (comment_id, user_id, reaction_type) VALUES ('c1', 'u1', -1)`,
+	}
+	for _, step := range steps {
+		if _, err := Db.Exec(step); err != nil {
+			t.Fatalf("legacy schema: %v\n%s", err, step)
+		}
+	}
+	Db.Close()
+	return path
+}
+
+// reactionsOf: user_id -> reaction for every row of a migrated table
+func reactionsOf(t *testing.T, table string) map[string]string {
+	t.Helper()
+	rows, err := Db.Query("SELECT user_id, reaction FROM " + table)
+	if err != nil {
+		t.Fatalf("read %s: %v", table, err)
+	}
+	defer rows.Close()
+	got := make(map[string]string)
+	for rows.Next() {
+		var userID, reaction string
+		rows.Scan(&userID, &reaction)
+		got[userID] = reaction
+	}
+	return got
+}
+
+func TestMigrateReactionType(t *testing.T) {
+	path := legacyReactionsDB(t)
+	InitDB(path)
+	t.Cleanup(func() { Db.Close() })
+
+	for _, table := range []string{"posts_reactions", "comments_reactions"} {
+		var legacy bool
+		Db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = 'reaction_type'", table).Scan(&legacy)
+		if legacy {
+			t.Fatalf("%s still has reaction_type", table)
+		}
+	}
+
+	// Neutral (0) rows carried no reaction and are dropped
+	posts := reactionsOf(t, "posts_reactions")
+	if len(posts) != 2 || posts["u1"] != "like" || posts["u2"] != "dislike" {
+		t.Fatalf("posts_reactions = %v, want u1 like and u2 dislike", posts)
+	}
+	comments := reactionsOf(t, "comments_reactions")
+	if len(comments) != 1 || comments["u1"] != "dislike" {
+		t.Fatalf("comments_reactions = %v, want u1 dislike", comments)
+	}
+
+	// The rebuilt table keeps its key and accepts the new columns
+	if _, err := Db.Exec("INSERT INTO posts_reactions (post_id, user_id, reaction) VALUES ('p1', 'u1', 'love')"); err == nil {
+		t.Fatal("duplicate (post, user) reaction accepted after migration")
+	}
+	if _, err := Db.Exec("UPDATE posts_reactions SET reaction = 'love', created_at = CURRENT_TIMESTAMP WHERE user_id = 'u1'"); err != nil {
+		t.Fatalf("update migrated row: %v", err)
+	}
+}
+
+func TestMigrateReactionTypeKeyedSchemaUntouched(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forum.db")
+	InitDB(path)
+	Db.Exec(`INSERT INTO users (user_id, first_name, last_name, nickname, age, gender, email, password)
+		VALUES ('u1', 'Ann', 'Lee', 'ann', 30, '', 'ann@example.com', '')`)
+	Db.Exec(`INSERT INTO posts (post_id, content, user_id) VALUES ('p1', 'hello', 'u1')`)
+	if _, err := Db.Exec("INSERT INTO posts_reactions (post_id, user_id, reaction) VALUES ('p1', 'u1', 'love')"); err != nil {
+		t.Fatalf("insert reaction: %v", err)
+	}
+	Db.Close()
+
+	// Starting again on an up-to-date database keeps every reaction
+	InitDB(path)
+	t.Cleanup(func() { Db.Close() })
+	if got := reactionsOf(t, "posts_reactions"); len(got) != 1 || got["u1"] != "love" {
+		t.Fatalf("posts_reactions after restart = %v, want u1 love", got)
+	}
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/modules/metrics"
+)
+
+// RequestIDHeader: Returned on every /api response; a well-formed incoming value is kept
+// so a proxy's ID follows the request into our logs
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// InitLogging: Installs the process-wide slog logger (LOG_LEVEL, LOG_FORMAT)
+// The standard log package writes through it too, so nothing bypasses the format
+func InitLogging(cfg *Config) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stdout, opts)
+	if strings.EqualFold(cfg.LogFormat, "json") {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+type loggerKey struct{}
+
+// WithLogger: Attaches a request-scoped logger to the context
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger: The request-scoped logger (request ID, user ID), or the default one
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RequestLogger: Gives every /api request an ID (X-Request-ID) and a logger carrying it,
+// logs one line per request with its status and duration and counts it in the request
+// metrics. Other paths pass through
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		logger := slog.Default().With("request_id", requestID)
+
+		rec := &StatusRecorder{ResponseWriter: w}
+		start := time.Now()
+		req := r.WithContext(WithLogger(r.Context(), logger))
+		next.ServeHTTP(rec, req)
+		elapsed := time.Since(start)
+
+		logger.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.Status(),
+			"duration_ms", elapsed.Milliseconds(),
+			"ip", r.RemoteAddr,
+		)
+
+		// The mux stores the matched pattern on the request it routed
+		route, method := routeLabel(req.Pattern), methodLabel(r.Method)
+		metrics.HTTPRequests.Inc(route, method, strconv.Itoa(rec.Status()))
+		metrics.HTTPDuration.Observe(elapsed.Seconds(), route, method)
+	})
+}
+
+// routeLabel: The route pattern without its method ("GET /api/admin/users/{user_id}"),
+// so paths with IDs share one series; requests no route matched share "unmatched"
+func routeLabel(pattern string) string {
+	if pattern == "" {
+		return "unmatched"
+	}
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		pattern = pattern[i+1:]
+	}
+	return pattern
+}
+
+// methodLabel: Clients can send any method name; only the standard ones get their own series
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// StatusRecorder: ResponseWriter that remembers the status code
+// Flush and Unwrap keep streaming responses (SSE) and http.ResponseController working
+type StatusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *StatusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *StatusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *StatusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *StatusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Status: The status written so far (200 when the handler wrote nothing)
+func (s *StatusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
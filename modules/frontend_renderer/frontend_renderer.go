@@ -2,10 +2,10 @@ package frontend_renderer
 
 import (
 	"bytes"
-	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"log/slog"
 )
 
 // tmpl holds the parsed SPA template (index.html) - global for reuse
@@ -29,7 +29,7 @@ func Exec(wr io.Writer, name string, data any) error {
 	// Execute template into buffer first - avoids partial writes
 	err := tmpl.ExecuteTemplate(&buffer, name, data)
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("Template execution failed", "template", name, "err", err)
 		return err
 	}
 
@@ -0,0 +1,274 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics of the forum, exposed on /metrics in the Prometheus text format
+// Label values must come from a fixed set (route patterns, message types, outcomes)
+// so a client cannot create series at will
+var (
+	HTTPRequests = NewCounterVec("forum_http_requests_total",
+		"API requests by route pattern, method and status code", "route", "method", "status")
+	HTTPDuration = NewHistogramVec("forum_http_request_duration_seconds",
+		"API request latency by route pattern and method", DefaultBuckets, "route", "method")
+	WSMessages = NewCounterVec("forum_ws_messages_total",
+		"Real-time messages handled, by type and outcome (ok or error)", "type", "outcome")
+	DBQueryDuration = NewHistogramVec("forum_db_query_duration_seconds",
+		"Duration of the hot database queries", DBBuckets, "query")
+	Logins = NewCounterVec("forum_logins_total",
+		"Login attempts by outcome (success or failure) and failure reason", "outcome", "reason")
+	SessionsCleaned = NewCounterVec("forum_sessions_cleaned_total",
+		"Expired sessions deleted by the periodic cleanup or when found expired")
+)
+
+// Histogram buckets in seconds
+var (
+	DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	DBBuckets      = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+)
+
+// collector: One metric family, written in registration order
+type collector interface {
+	write(b *strings.Builder)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	registry = append(registry, c)
+	registryMu.Unlock()
+}
+
+// Handler: GET /metrics - every registered metric in the Prometheus text exposition format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	registryMu.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMu.Unlock()
+
+	var b strings.Builder
+	for _, c := range collectors {
+		c.write(&b)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(b.String()))
+}
+
+// Protect: Requires "Authorization: Bearer <token>" on the handler; no check when token is empty
+func Protect(token string, next http.HandlerFunc) http.HandlerFunc {
+	if token == "" {
+		return next
+	}
+	want := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// CounterVec: Monotonic counters, one per combination of label values
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, series: make(map[string]*counterSeries)}
+	register(c)
+	return c
+}
+
+// Inc: Adds one to the series of the given label values (in declaration order)
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add: Adds n (not negative) to the series of the given label values
+func (c *CounterVec) Add(n float64, values ...string) {
+	if n < 0 || len(values) != len(c.labels) {
+		return
+	}
+	key := seriesKey(values)
+	c.mu.Lock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: values}
+		c.series[key] = s
+	}
+	s.value += n
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(b *strings.Builder) {
+	writeHeader(b, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.labels) == 0 && len(c.series) == 0 {
+		// A plain counter is reported from the start
+		writeSample(b, c.name, nil, nil, 0)
+		return
+	}
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		writeSample(b, c.name, c.labels, s.values, s.value)
+	}
+}
+
+// HistogramVec: Observations counted into cumulative buckets, one histogram per label combination
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	register(h)
+	return h
+}
+
+// Observe: Records one value (seconds) for the given label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	if len(values) != len(h.labels) {
+		return
+	}
+	key := seriesKey(values)
+	h.mu.Lock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+	h.mu.Unlock()
+}
+
+// Since: Observes the time elapsed since start - `defer DBQueryDuration.Since(time.Now(), "GetPosts")`
+func (h *HistogramVec) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *HistogramVec) write(b *strings.Builder) {
+	writeHeader(b, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		values := append(append([]string(nil), s.values...), "")
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			values[len(values)-1] = formatFloat(upper)
+			writeSample(b, h.name+"_bucket", labels, values, float64(cumulative))
+		}
+		values[len(values)-1] = "+Inf"
+		writeSample(b, h.name+"_bucket", labels, values, float64(s.count))
+		writeSample(b, h.name+"_sum", h.labels, s.values, s.sum)
+		writeSample(b, h.name+"_count", h.labels, s.values, float64(s.count))
+	}
+}
+
+// GaugeFunc: A value read when /metrics is scraped (e.g. open connections)
+type GaugeFunc struct {
+	name, help string
+	read       func() float64
+}
+
+func NewGaugeFunc(name, help string, read func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, read: read}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(b *strings.Builder) {
+	writeHeader(b, g.name, g.help, "gauge")
+	writeSample(b, g.name, nil, nil, g.read())
+}
+
+func writeHeader(b *strings.Builder, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(b *strings.Builder, name string, labels, values []string, v float64) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label)
+			b.WriteString(`="`)
+			b.WriteString(labelEscaper.Replace(values[i]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(v))
+	b.WriteByte('\n')
+}
+
+// labelEscaper: Label values escape backslash, double quote and line feed
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// seriesKey: Label values joined with a separator that cannot appear in UTF-8 text
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys: Series in a stable order, so consecutive scrapes are easy to compare
+func sortedKeys[T any](series map[string]T) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// render: Text exposition of one collector
+func render(c collector) string {
+	var b strings.Builder
+	c.write(&b)
+	return b.String()
+}
+
+func TestHistogramOutput(t *testing.T) {
+	h := &HistogramVec{name: "test_duration_seconds", help: "Test latency", labels: []string{"route"},
+		buckets: []float64{0.1, 0.5, 1}, series: make(map[string]*histogramSeries)}
+	h.Observe(0.05, "/b")
+	h.Observe(0.1, "/a") // on a bound: counted in that bucket (le is inclusive)
+	h.Observe(0.3, "/a")
+	h.Observe(2, "/a")          // above every bound: only in +Inf
+	h.Observe(1, "/a", "extra") // wrong label count: ignored
+
+	want := `# HELP test_duration_seconds Test latency
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/a",le="0.1"} 1
+test_duration_seconds_bucket{route="/a",le="0.5"} 2
+test_duration_seconds_bucket{route="/a",le="1"} 2
+test_duration_seconds_bucket{route="/a",le="+Inf"} 3
+test_duration_seconds_sum{route="/a"} 2.4
+test_duration_seconds_count{route="/a"} 3
+test_duration_seconds_bucket{route="/b",le="0.1"} 1
+test_duration_seconds_bucket{route="/b",le="0.5"} 1
+test_duration_seconds_bucket{route="/b",le="1"} 1
+test_duration_seconds_bucket{route="/b",le="+Inf"} 1
+test_duration_seconds_sum{route="/b"} 0.05
+test_duration_seconds_count{route="/b"} 1
+`
+	if got := render(h); got != want {
+		t.Fatalf("histogram output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterOutput(t *testing.T) {
+	plain := &CounterVec{name: "test_cleaned_total", help: "Cleaned", series: make(map[string]*counterSeries)}
+	if got := render(plain); !strings.HasSuffix(got, "\ntest_cleaned_total 0\n") {
+		t.Fatalf("unlabelled counter before any Inc:\n%s", got)
+	}
+
+	c := &CounterVec{name: "test_messages_total", help: "Messages", labels: []string{"type", "outcome"},
+		series: make(map[string]*counterSeries)}
+	c.Inc("typing", "ok")
+	c.Add(2, "typing", "ok")
+	c.Add(-1, "typing", "ok") // counters never go down
+	c.Inc("say \"hi\"\n\\", "error")
+
+	want := `# HELP test_messages_total Messages
+# TYPE test_messages_total counter
+test_messages_total{type="say \"hi\"\n\\",outcome="error"} 1
+test_messages_total{type="typing",outcome="ok"} 3
+`
+	if got := render(c); got != want {
+		t.Fatalf("counter output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestProtect(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	cases := []struct {
+		token, header string
+		want          int
+	}{
+		{"", "", http.StatusOK},
+		{"s3cret", "", http.StatusUnauthorized},
+		{"s3cret", "Bearer wrong", http.StatusUnauthorized},
+		{"s3cret", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		Protect(tc.token, ok)(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("token %q, header %q: status %d, want %d", tc.token, tc.header, rec.Code, tc.want)
+		}
+	}
+}
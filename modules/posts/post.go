@@ -14,6 +14,8 @@ type Post struct {
 	DislikeCount int            `json:"dislike_count"`
 	Reactions    []PostReaction `json:"reactions,omitempty"`
 	CommentCount int            `json:"comment_count,omitempty"`
+
+	ReactionCounts map[string]int `json:"reaction_counts"` // every registry key, 0 when unused
 }
 
 type Comment struct {
@@ -26,6 +28,8 @@ type Comment struct {
 	LikeCount    int               `json:"like_count"`
 	DislikeCount int               `json:"dislike_count"`
 	Reactions    []CommentReaction `json:"reactions,omitempty"`
+
+	ReactionCounts map[string]int `json:"reaction_counts"`
 }
 
 // Category represents a post category
@@ -39,15 +43,15 @@ type Author struct {
 }
 
 type PostReaction struct {
-	PostID       string `json:"post_id"`
-	UserID       string `json:"user_id"`
-	ReactionType int    `json:"reaction_type"`
+	PostID   string `json:"post_id"`
+	UserID   string `json:"user_id"`
+	Reaction string `json:"reaction"`
 }
 
 type CommentReaction struct {
-	CommentID    string `json:"comment_id"`
-	UserID       string `json:"user_id"`
-	ReactionType int    `json:"reaction_type"`
+	CommentID string `json:"comment_id"`
+	UserID    string `json:"user_id"`
+	Reaction  string `json:"reaction"`
 }
 
 type NewPost struct {
@@ -60,8 +64,18 @@ type NewComment struct {
 	Content string `json:"content"`
 }
 
+// NewReaction: Body of POST /api/reactions - Reaction is a registry key; clients that
+// predate the registry send ReactionType 1 (like) or -1 (dislike) instead
 type NewReaction struct {
     PostID       string `json:"post_id,omitempty"`
     CommentID    string `json:"comment_id,omitempty"`
-    ReactionType int    `json:"reaction_type"`
+    Reaction     string `json:"reaction,omitempty"`
+    ReactionType int    `json:"reaction_type,omitempty"`
+}
+
+// ReactionSummary: Reaction totals of one post or comment
+type ReactionSummary struct {
+    LikeCount      int            `json:"like_count"`
+    DislikeCount   int            `json:"dislike_count"`
+    ReactionCounts map[string]int `json:"reaction_counts"`
 }
\ No newline at end of file
@@ -5,10 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 
+	"real-time-forum/modules/auth"
 	"real-time-forum/modules/core"
 )
 
@@ -26,19 +26,11 @@ func SetCommentService(service *CommentService) {
 	commentService = service
 }
 
+// PostsHandler: Creates and lists posts (wrapped by auth.RequireAuth)
 func PostsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	sessionID := r.Header.Get("Session-ID")
-	if sessionID == "" {
-		http.Error(w, "Session required", http.StatusUnauthorized)
-		return
-	}
-	var userID string
-	err := core.Db.QueryRow("SELECT user_id FROM sessions WHERE session_id = ? AND expires_at > CURRENT_TIMESTAMP", sessionID).Scan(&userID)
-	if err != nil {
-		http.Error(w, "Invalid session", http.StatusUnauthorized)
-		return
-	}
+	user, _ := auth.UserFromContext(r.Context())
+	userID := user.ID
 	switch r.Method {
 	case http.MethodPost:
 		var newPost *NewPost
@@ -119,10 +111,10 @@ func PostsHandler(w http.ResponseWriter, r *http.Request) {
 				_ = PostId
 			}
 
-			// Use the authenticated userID from session check (already done at top)
+			// Use the authenticated userID resolved by auth.RequireAuth
 			posts, err := postService.GetFilteredPosts(userID, categories, onlyMyPosts, onlyMyLikedPosts, PostId)
 			if err != nil {
-				log.Printf("❌ Filter posts error: %v", err)
+				core.Logger(r.Context()).Error("Filter posts failed", "err", err)
 				http.Error(w, `Failed to fetch filtered posts`, http.StatusInternalServerError)
 				return
 			}
@@ -137,7 +129,6 @@ func PostsHandler(w http.ResponseWriter, r *http.Request) {
 			})
 		}
 	default:
-		fmt.Println("TTT")
 		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
 	}
 }
@@ -145,31 +136,21 @@ func PostsHandler(w http.ResponseWriter, r *http.Request) {
 // CommentHandler handles the creation and retrieval of comments
 func CommentHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	sessionID := r.Header.Get("Session-ID")
-	if sessionID == "" {
-		http.Error(w, "Session required", http.StatusUnauthorized)
-		return
-	}
-	var userID string
-	err := core.Db.QueryRow("SELECT user_id FROM sessions WHERE session_id = ? AND expires_at > CURRENT_TIMESTAMP", sessionID).Scan(&userID)
-	if err != nil {
-		log.Printf("Session error for session_id %s: %v", sessionID, err)
-		http.Error(w, "Invalid session", http.StatusUnauthorized)
-		return
-	}
+	user, _ := auth.UserFromContext(r.Context())
+	userID := user.ID
 
 	switch r.Method {
 	case http.MethodPost:
 		if r.Header.Get("request-type") == "create_comment" {
 			var newComment *NewComment
 			if err := json.NewDecoder(r.Body).Decode(&newComment); err != nil {
-				log.Printf("❌ Invalid JSON: %v", err)
+				core.Logger(r.Context()).Warn("Invalid comment JSON", "err", err)
 				http.Error(w, "Invalid JSON", http.StatusBadRequest)
 				return
 			}
 			comment, err := commentService.CreateComment(userID, newComment.PostID, newComment.Content)
 			if err != nil {
-				log.Printf("❌ Create comment error: %v", err)
+				core.Logger(r.Context()).Warn("Create comment failed", "post_id", newComment.PostID, "err", err)
 				http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
 				return
 			}
@@ -180,35 +161,30 @@ func CommentHandler(w http.ResponseWriter, r *http.Request) {
 			})
 		}
 	default:
-		fmt.Println("TTT")
 		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
 	}
 }
 
-// In modules/posts/post_handler.go
+// ReactionHandler: Reaction toggle for posts and comments (wrapped by auth.RequireAuth)
+// GET lists the reaction registry, POST toggles one reaction and returns the new totals
 func ReactionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(core.AppConfig.Reactions)
+		return
+	}
 	if r.Method != http.MethodPost {
 		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
 		return
 	}
 
-	sessionID := r.Header.Get("Session-ID")
-	if sessionID == "" {
-		http.Error(w, `{"error": "Session required"}`, http.StatusUnauthorized)
-		return
-	}
-	var userID string
-	err := core.Db.QueryRow("SELECT user_id FROM sessions WHERE session_id = ? AND expires_at > CURRENT_TIMESTAMP", sessionID).Scan(&userID)
-	if err != nil {
-		log.Printf("Session error for session_id %s: %v", sessionID, err)
-		http.Error(w, `{"error": "Invalid session"}`, http.StatusUnauthorized)
-		return
-	}
+	user, _ := auth.UserFromContext(r.Context())
+	userID := user.ID
+	var err error
 
 	var reaction NewReaction
 	if err := json.NewDecoder(r.Body).Decode(&reaction); err != nil {
-		log.Printf("Invalid JSON: %v", err)
+		core.Logger(r.Context()).Warn("Invalid reaction JSON", "err", err)
 		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
 		return
 	}
@@ -222,42 +198,33 @@ func ReactionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var updatedCounts struct {
-		LikeCount    int `json:"like_count"`
-		DislikeCount int `json:"dislike_count"`
-	}
+	var updatedCounts ReactionSummary
 
 	if reaction.PostID != "" {
-		err = postService.AddOrUpdatePostReaction(userID, reaction.PostID, reaction.ReactionType)
+		err = postService.AddOrUpdatePostReaction(userID, reaction.PostID, reactionKey(reaction))
 		if err != nil {
-			log.Printf("Post reaction error: %v", err)
+			core.Logger(r.Context()).Warn("Post reaction failed", "post_id", reaction.PostID, "err", err)
 			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
 			return
 		}
 		// Fetch updated counts
-		err = postService.db.QueryRow(`
-            SELECT COALESCE(SUM(CASE WHEN reaction_type = 1 THEN 1 ELSE 0 END), 0) AS like_count,
-                   COALESCE(SUM(CASE WHEN reaction_type = -1 THEN 1 ELSE 0 END), 0) AS dislike_count
-            FROM posts_reactions WHERE post_id = ?`, reaction.PostID).Scan(&updatedCounts.LikeCount, &updatedCounts.DislikeCount)
+		updatedCounts, err = postService.PostReactionSummary(reaction.PostID)
 		if err != nil {
-			log.Printf("Failed to fetch post reaction counts: %v", err)
+			core.Logger(r.Context()).Error("Failed to fetch post reaction counts", "post_id", reaction.PostID, "err", err)
 			http.Error(w, `{"error": "Failed to fetch reaction counts"}`, http.StatusInternalServerError)
 			return
 		}
 	} else {
-		err = postService.AddOrUpdateCommentReaction(userID, reaction.CommentID, reaction.ReactionType)
+		err = postService.AddOrUpdateCommentReaction(userID, reaction.CommentID, reactionKey(reaction))
 		if err != nil {
-			log.Printf("Comment reaction error: %v", err)
+			core.Logger(r.Context()).Warn("Comment reaction failed", "comment_id", reaction.CommentID, "err", err)
 			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
 			return
 		}
 		// Fetch updated counts
-		err = postService.db.QueryRow(`
-            SELECT COALESCE(SUM(CASE WHEN reaction_type = 1 THEN 1 ELSE 0 END), 0) AS like_count,
-                   COALESCE(SUM(CASE WHEN reaction_type = -1 THEN 1 ELSE 0 END), 0) AS dislike_count
-            FROM comments_reactions WHERE comment_id = ?`, reaction.CommentID).Scan(&updatedCounts.LikeCount, &updatedCounts.DislikeCount)
+		updatedCounts, err = postService.CommentReactionSummary(reaction.CommentID)
 		if err != nil {
-			log.Printf("Failed to fetch comment reaction counts: %v", err)
+			core.Logger(r.Context()).Error("Failed to fetch comment reaction counts", "comment_id", reaction.CommentID, "err", err)
 			http.Error(w, `{"error": "Failed to fetch reaction counts"}`, http.StatusInternalServerError)
 			return
 		}
@@ -265,8 +232,9 @@ func ReactionHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":        "ok",
-		"like_count":    updatedCounts.LikeCount,
-		"dislike_count": updatedCounts.DislikeCount,
+		"status":          "ok",
+		"like_count":      updatedCounts.LikeCount,
+		"dislike_count":   updatedCounts.DislikeCount,
+		"reaction_counts": updatedCounts.ReactionCounts,
 	})
 }
@@ -6,6 +6,10 @@ import (
 	"strings"
 	"time"
 
+	"real-time-forum/modules/audit"
+	"real-time-forum/modules/core"
+	"real-time-forum/modules/metrics"
+
 	"github.com/google/uuid"
 )
 
@@ -72,6 +76,8 @@ func (ps *PostService) CreatePost(userID string, newPost *NewPost) (*Post, error
 	if err = tx.Commit(); err != nil {
 		return &Post{}, fmt.Errorf("commit error: %v", err)
 	}
+	audit.Record(audit.Event{ActorID: userID, Action: "post_created", Target: "post:" + postID,
+		Details: map[string]interface{}{"categories": newPost.Categories}})
 
 	return &Post{
 		PostID:       postID,
@@ -81,19 +87,19 @@ func (ps *PostService) CreatePost(userID string, newPost *NewPost) (*Post, error
 		Categories:   newPost.Categories,
 		LikeCount:    0,
 		DislikeCount: 0,
+
+		ReactionCounts: reactionCounts(nil),
 	}, nil
 }
 
 // GetPosts: Infinite scroll - fetches 3 newest posts after lastPostID
 func (ps *PostService) GetPosts(lastPostID string) ([]Post, error) {
+	defer metrics.DBQueryDuration.Since(time.Now(), "GetPosts")
 	limit := 3
 	baseQuery := `
-        SELECT p.post_id, p.user_id, p.content, p.created_at, u.nickname,
-               COALESCE(SUM(CASE WHEN pr.reaction_type = 1 THEN 1 ELSE 0 END),0) AS like_count,
-               COALESCE(SUM(CASE WHEN pr.reaction_type = -1 THEN 1 ELSE 0 END),0) AS dislike_count
+        SELECT p.post_id, p.user_id, p.content, p.created_at, u.nickname
         FROM posts p
         JOIN users u ON p.user_id = u.user_id
-        LEFT JOIN posts_reactions pr ON p.post_id = pr.post_id
     `
 
 	var whereQu string
@@ -108,7 +114,7 @@ func (ps *PostService) GetPosts(lastPostID string) ([]Post, error) {
 		args = append(args, lastCreatedAt)
 	}
 
-	query := fmt.Sprintf("%s%s GROUP BY p.post_id ORDER BY p.created_at DESC LIMIT ?", baseQuery, whereQu)
+	query := fmt.Sprintf("%s%s ORDER BY p.created_at DESC LIMIT ?", baseQuery, whereQu)
 	args = append(args, limit)
 	rows, err := ps.db.Query(query, args...)
 	if err != nil {
@@ -119,7 +125,7 @@ func (ps *PostService) GetPosts(lastPostID string) ([]Post, error) {
 	var posts []Post
 	for rows.Next() {
 		var p Post
-		if err := rows.Scan(&p.PostID, &p.UserID, &p.Content, &p.CreatedAt, &p.Author.Nickname, &p.LikeCount, &p.DislikeCount); err != nil {
+		if err := rows.Scan(&p.PostID, &p.UserID, &p.Content, &p.CreatedAt, &p.Author.Nickname); err != nil {
 			return nil, fmt.Errorf("scan post: %w", err)
 		}
 
@@ -158,6 +164,9 @@ func (ps *PostService) GetPosts(lastPostID string) ([]Post, error) {
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows error: %v", err)
 	}
+	if err := ps.attachPostReactions(posts); err != nil {
+		return nil, err
+	}
 	if lastPostID != "" && len(posts) > 0 {
 		return posts[1:], nil // Skip duplicate on pagination
 	}
@@ -166,15 +175,13 @@ func (ps *PostService) GetPosts(lastPostID string) ([]Post, error) {
 
 // GetFilteredPosts: Advanced filtering with pagination
 func (ps *PostService) GetFilteredPosts(userID string, categories []string, onlyMyPosts, onlyMyLikedPosts bool, lastPostID string) ([]Post, error) {
+	defer metrics.DBQueryDuration.Since(time.Now(), "GetFilteredPosts")
 	limit := 3
 
 	baseQuery := `
-        SELECT DISTINCT p.post_id, p.user_id, p.content, p.created_at, u.nickname,
-               COALESCE(SUM(CASE WHEN pr.reaction_type = 1 THEN 1 ELSE 0 END), 0) AS like_count,
-               COALESCE(SUM(CASE WHEN pr.reaction_type = -1 THEN 1 ELSE 0 END), 0) AS dislike_count
+        SELECT DISTINCT p.post_id, p.user_id, p.content, p.created_at, u.nickname
         FROM posts p
         JOIN users u ON p.user_id = u.user_id
-        LEFT JOIN posts_reactions pr ON p.post_id = pr.post_id
     `
 
 	var whereClauses []string
@@ -213,12 +220,19 @@ func (ps *PostService) GetFilteredPosts(userID string, categories []string, only
 		args = append(args, userID)
 	}
 
-	// Liked posts filter
+	// Liked posts filter: any reaction that scores as a like
 	if onlyMyLikedPosts {
-		whereClauses = append(whereClauses, `
-            EXISTS (SELECT 1 FROM posts_reactions pr2 WHERE pr2.post_id = p.post_id AND pr2.user_id = ? AND pr2.reaction_type = 1)
-        `)
+		likeKeys := reactionKeysScoring(1)
+		if len(likeKeys) == 0 {
+			likeKeys = []string{""} // nothing counts as a like: match no post
+		}
+		whereClauses = append(whereClauses, fmt.Sprintf(`
+            EXISTS (SELECT 1 FROM posts_reactions pr2 WHERE pr2.post_id = p.post_id AND pr2.user_id = ? AND pr2.reaction IN (%s))
+        `, strings.TrimSuffix(strings.Repeat("?,", len(likeKeys)), ",")))
 		args = append(args, userID)
+		for _, key := range likeKeys {
+			args = append(args, key)
+		}
 	}
 
 	// Pagination
@@ -237,7 +251,7 @@ func (ps *PostService) GetFilteredPosts(userID string, categories []string, only
 	if len(whereClauses) > 0 {
 		where = " WHERE " + strings.Join(whereClauses, " AND ")
 	}
-	query := fmt.Sprintf("%s%s GROUP BY p.post_id ORDER BY p.created_at DESC LIMIT ?", baseQuery, where)
+	query := fmt.Sprintf("%s%s ORDER BY p.created_at DESC LIMIT ?", baseQuery, where)
 	args = append(args, limit)
 
 	rows, err := ps.db.Query(query, args...)
@@ -249,7 +263,7 @@ func (ps *PostService) GetFilteredPosts(userID string, categories []string, only
 	var posts []Post
 	for rows.Next() {
 		var p Post
-		if err := rows.Scan(&p.PostID, &p.UserID, &p.Content, &p.CreatedAt, &p.Author.Nickname, &p.LikeCount, &p.DislikeCount); err != nil {
+		if err := rows.Scan(&p.PostID, &p.UserID, &p.Content, &p.CreatedAt, &p.Author.Nickname); err != nil {
 			return nil, fmt.Errorf("scan filtered post: %w", err)
 		}
 
@@ -288,6 +302,9 @@ func (ps *PostService) GetFilteredPosts(userID string, categories []string, only
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows error: %v", err)
 	}
+	if err := ps.attachPostReactions(posts); err != nil {
+		return nil, err
+	}
 
 	if lastPostID != "" && len(posts) > 0 {
 		return posts[1:], nil // Skip the last post if paginating
@@ -295,10 +312,10 @@ func (ps *PostService) GetFilteredPosts(userID string, categories []string, only
 	return posts, nil
 }
 
-// AddOrUpdatePostReaction: Like/dislike toggle with upsert logic
-func (ps *PostService) AddOrUpdatePostReaction(userID, postID string, reactionType int) error {
-	if reactionType != 1 && reactionType != -1 {
-		return fmt.Errorf("invalid reaction_type: must be 1 (like) or -1 (dislike)")
+// AddOrUpdatePostReaction: Reaction toggle with upsert logic - same reaction removes it, another one replaces it
+func (ps *PostService) AddOrUpdatePostReaction(userID, postID, reaction string) error {
+	if _, ok := core.AppConfig.Reaction(reaction); !ok {
+		return fmt.Errorf("invalid reaction: not one of the available reactions")
 	}
 
 	// Check if post exists
@@ -309,13 +326,13 @@ func (ps *PostService) AddOrUpdatePostReaction(userID, postID string, reactionTy
 	}
 
 	// Check if user already has a reaction
-	var currentReaction int
-	err = ps.db.QueryRow("SELECT reaction_type FROM posts_reactions WHERE post_id = ? AND user_id = ?", postID, userID).Scan(&currentReaction)
+	var currentReaction string
+	err = ps.db.QueryRow("SELECT reaction FROM posts_reactions WHERE post_id = ? AND user_id = ?", postID, userID).Scan(&currentReaction)
 	if err == sql.ErrNoRows {
 		// No existing reaction, insert new
 		_, err = ps.db.Exec(
-			"INSERT INTO posts_reactions (post_id, user_id, reaction_type) VALUES (?, ?, ?)",
-			postID, userID, reactionType,
+			"INSERT INTO posts_reactions (post_id, user_id, reaction, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
+			postID, userID, reaction,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to insert post reaction: %w", err)
@@ -326,7 +343,7 @@ func (ps *PostService) AddOrUpdatePostReaction(userID, postID string, reactionTy
 	}
 
 	// Existing reaction found
-	if currentReaction == reactionType {
+	if currentReaction == reaction {
 		// Same reaction, remove it (toggle off)
 		_, err = ps.db.Exec(
 			"DELETE FROM posts_reactions WHERE post_id = ? AND user_id = ?",
@@ -338,8 +355,8 @@ func (ps *PostService) AddOrUpdatePostReaction(userID, postID string, reactionTy
 	} else {
 		// Different reaction, update it
 		_, err = ps.db.Exec(
-			"UPDATE posts_reactions SET reaction_type = ? WHERE post_id = ? AND user_id = ?",
-			reactionType, postID, userID,
+			"UPDATE posts_reactions SET reaction = ? WHERE post_id = ? AND user_id = ?",
+			reaction, postID, userID,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to update post reaction: %w", err)
@@ -401,6 +418,8 @@ func (cm *CommentService) CreateComment(userID, postID, content string) (*Commen
 	if err != nil {
 		return &Comment{}, fmt.Errorf("commit error: %v", err)
 	}
+	audit.Record(audit.Event{ActorID: userID, Action: "comment_created", Target: "comment:" + commentID,
+		Details: map[string]interface{}{"post_id": postID}})
 
 	return &Comment{
 		CommentID: commentID,
@@ -409,18 +428,17 @@ func (cm *CommentService) CreateComment(userID, postID, content string) (*Commen
 		Content:   content,
 		CreatedAt: time.Now(),
 		Author:    Author{Nickname: nickname},
+
+		ReactionCounts: reactionCounts(nil),
 	}, nil
 }
 
 // GetComments: Paginated comment fetch with reaction counts
 func (ps *PostService) GetComments(postID string, lastCommentID string, limit int) ([]Comment, error) {
 	baseQuery := `
-        SELECT c.comment_id, c.post_id, c.user_id, c.content, c.created_at, u.nickname,
-               COALESCE(SUM(CASE WHEN cr.reaction_type = 1 THEN 1 ELSE 0 END), 0) AS like_count,
-               COALESCE(SUM(CASE WHEN cr.reaction_type = -1 THEN 1 ELSE 0 END), 0) AS dislike_count
+        SELECT c.comment_id, c.post_id, c.user_id, c.content, c.created_at, u.nickname
         FROM comments c
         JOIN users u ON c.user_id = u.user_id
-        LEFT JOIN comments_reactions cr ON c.comment_id = cr.comment_id
         WHERE c.post_id = ?
     `
 
@@ -438,7 +456,7 @@ func (ps *PostService) GetComments(postID string, lastCommentID string, limit in
 		args = append(args, lastCreatedAt)
 	}
 
-	query := baseQuery + where + " GROUP BY c.comment_id ORDER BY c.created_at DESC LIMIT ?"
+	query := baseQuery + where + " ORDER BY c.created_at DESC LIMIT ?"
 	args = append(args, limit)
 
 	rows, err := ps.db.Query(query, args...)
@@ -450,7 +468,7 @@ func (ps *PostService) GetComments(postID string, lastCommentID string, limit in
 	var comments []Comment
 	for rows.Next() {
 		var c Comment
-		if err := rows.Scan(&c.CommentID, &c.PostID, &c.UserID, &c.Content, &c.CreatedAt, &c.Author.Nickname, &c.LikeCount, &c.DislikeCount); err != nil {
+		if err := rows.Scan(&c.CommentID, &c.PostID, &c.UserID, &c.Content, &c.CreatedAt, &c.Author.Nickname); err != nil {
 			return nil, fmt.Errorf("comment scan error: %v", err)
 		}
 		comments = append(comments, c)
@@ -458,16 +476,19 @@ func (ps *PostService) GetComments(postID string, lastCommentID string, limit in
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows error: %v", err)
 	}
+	if err := ps.attachCommentReactions(comments); err != nil {
+		return nil, err
+	}
 	if lastCommentID != "" && len(comments) > 0 {
 		return comments[1:], nil // Skip the last post if paginating
 	}
 	return comments, nil
 }
 
-// AddOrUpdateCommentReaction: Toggle like/dislike on comment
-func (ps *PostService) AddOrUpdateCommentReaction(userID, commentID string, reactionType int) error {
-	if reactionType != 1 && reactionType != -1 {
-		return fmt.Errorf("invalid reaction_type: must be 1 (like) or -1 (dislike)")
+// AddOrUpdateCommentReaction: Toggle a reaction on a comment
+func (ps *PostService) AddOrUpdateCommentReaction(userID, commentID, reaction string) error {
+	if _, ok := core.AppConfig.Reaction(reaction); !ok {
+		return fmt.Errorf("invalid reaction: not one of the available reactions")
 	}
 
 	// Check if comment exists
@@ -478,13 +499,13 @@ func (ps *PostService) AddOrUpdateCommentReaction(userID, commentID string, reac
 	}
 
 	// Check if user already has a reaction
-	var currentReaction int
-	err = ps.db.QueryRow("SELECT reaction_type FROM comments_reactions WHERE comment_id = ? AND user_id = ?", commentID, userID).Scan(&currentReaction)
+	var currentReaction string
+	err = ps.db.QueryRow("SELECT reaction FROM comments_reactions WHERE comment_id = ? AND user_id = ?", commentID, userID).Scan(&currentReaction)
 	if err == sql.ErrNoRows {
 		// No existing reaction, insert new
 		_, err = ps.db.Exec(
-			"INSERT INTO comments_reactions (comment_id, user_id, reaction_type) VALUES (?, ?, ?)",
-			commentID, userID, reactionType,
+			"INSERT INTO comments_reactions (comment_id, user_id, reaction, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
+			commentID, userID, reaction,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to insert comment reaction: %w", err)
@@ -495,7 +516,7 @@ func (ps *PostService) AddOrUpdateCommentReaction(userID, commentID string, reac
 	}
 
 	// Existing reaction found
-	if currentReaction == reactionType {
+	if currentReaction == reaction {
 		// Same reaction, remove it
 		_, err = ps.db.Exec(
 			"DELETE FROM comments_reactions WHERE comment_id = ? AND user_id = ?",
@@ -507,8 +528,8 @@ func (ps *PostService) AddOrUpdateCommentReaction(userID, commentID string, reac
 	} else {
 		// Different reaction, update it
 		_, err = ps.db.Exec(
-			"UPDATE comments_reactions SET reaction_type = ? WHERE comment_id = ? AND user_id = ?",
-			reactionType, commentID, userID,
+			"UPDATE comments_reactions SET reaction = ? WHERE comment_id = ? AND user_id = ?",
+			reaction, commentID, userID,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to update comment reaction: %w", err)
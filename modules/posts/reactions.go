@@ -0,0 +1,144 @@
+package posts
+
+import (
+	"fmt"
+	"strings"
+
+	"real-time-forum/modules/core"
+)
+
+// reactionKey: Registry key requested by a NewReaction, accepting the legacy reaction_type too
+func reactionKey(reaction NewReaction) string {
+	if reaction.Reaction != "" {
+		return reaction.Reaction
+	}
+	switch reaction.ReactionType {
+	case 1:
+		return "like"
+	case -1:
+		return "dislike"
+	}
+	return ""
+}
+
+// reactionKeysScoring: Registry keys with the given score (1 = likes, -1 = dislikes)
+func reactionKeysScoring(score int) []string {
+	var keys []string
+	for _, r := range core.AppConfig.Reactions {
+		if r.Score == score {
+			keys = append(keys, r.Key)
+		}
+	}
+	return keys
+}
+
+// reactionCounts: Per-type counts with every registry key present; keys no longer in the
+// registry are dropped so retired reactions disappear from the UI
+func reactionCounts(stored map[string]int) map[string]int {
+	counts := make(map[string]int, len(core.AppConfig.Reactions))
+	for _, r := range core.AppConfig.Reactions {
+		counts[r.Key] = stored[r.Key]
+	}
+	return counts
+}
+
+// summarize: Like/dislike totals from per-type counts, using the registry scores
+func summarize(stored map[string]int) ReactionSummary {
+	summary := ReactionSummary{ReactionCounts: reactionCounts(stored)}
+	for _, r := range core.AppConfig.Reactions {
+		switch {
+		case r.Score > 0:
+			summary.LikeCount += stored[r.Key]
+		case r.Score < 0:
+			summary.DislikeCount += stored[r.Key]
+		}
+	}
+	return summary
+}
+
+// countReactions: Per-type counts for a batch of posts or comments in one query
+// table and idColumn are constants from this package, never user input
+func (ps *PostService) countReactions(table, idColumn string, ids []string) (map[string]map[string]int, error) {
+	counts := make(map[string]map[string]int, len(ids))
+	if len(ids) == 0 {
+		return counts, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := fmt.Sprintf(
+		"SELECT %[2]s, reaction, COUNT(*) FROM %[1]s WHERE %[2]s IN (%[3]s) GROUP BY %[2]s, reaction",
+		table, idColumn, strings.TrimSuffix(strings.Repeat("?,", len(ids)), ","),
+	)
+	rows, err := ps.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("reaction count query error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, reaction string
+		var n int
+		if err := rows.Scan(&id, &reaction, &n); err != nil {
+			return nil, fmt.Errorf("reaction count scan error: %w", err)
+		}
+		if counts[id] == nil {
+			counts[id] = make(map[string]int)
+		}
+		counts[id][reaction] = n
+	}
+	return counts, rows.Err()
+}
+
+// attachPostReactions: Fills the reaction counts and like/dislike totals of a page of posts
+func (ps *PostService) attachPostReactions(posts []Post) error {
+	ids := make([]string, len(posts))
+	for i := range posts {
+		ids[i] = posts[i].PostID
+	}
+	counts, err := ps.countReactions("posts_reactions", "post_id", ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		summary := summarize(counts[posts[i].PostID])
+		posts[i].LikeCount, posts[i].DislikeCount, posts[i].ReactionCounts = summary.LikeCount, summary.DislikeCount, summary.ReactionCounts
+	}
+	return nil
+}
+
+// attachCommentReactions: Same as attachPostReactions for comments
+func (ps *PostService) attachCommentReactions(comments []Comment) error {
+	ids := make([]string, len(comments))
+	for i := range comments {
+		ids[i] = comments[i].CommentID
+	}
+	counts, err := ps.countReactions("comments_reactions", "comment_id", ids)
+	if err != nil {
+		return err
+	}
+	for i := range comments {
+		summary := summarize(counts[comments[i].CommentID])
+		comments[i].LikeCount, comments[i].DislikeCount, comments[i].ReactionCounts = summary.LikeCount, summary.DislikeCount, summary.ReactionCounts
+	}
+	return nil
+}
+
+// PostReactionSummary: Current totals of one post (response of a reaction toggle)
+func (ps *PostService) PostReactionSummary(postID string) (ReactionSummary, error) {
+	counts, err := ps.countReactions("posts_reactions", "post_id", []string{postID})
+	if err != nil {
+		return ReactionSummary{}, err
+	}
+	return summarize(counts[postID]), nil
+}
+
+// CommentReactionSummary: Current totals of one comment
+func (ps *PostService) CommentReactionSummary(commentID string) (ReactionSummary, error) {
+	counts, err := ps.countReactions("comments_reactions", "comment_id", []string{commentID})
+	if err != nil {
+		return ReactionSummary{}, err
+	}
+	return summarize(counts[commentID]), nil
+}
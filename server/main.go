@@ -1,14 +1,16 @@
 package main
 
 import (
-	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"real-time-forum/modules/audit"
 	"real-time-forum/modules/auth"
 	"real-time-forum/modules/core"
 	"real-time-forum/modules/frontend_renderer"
+	"real-time-forum/modules/metrics"
 	"real-time-forum/modules/posts"
 )
 
@@ -34,27 +36,39 @@ func mainHandler(w http.ResponseWriter, r *http.Request) {
 	// Render the main HTML template; return 500 on failure
 	if frontend_renderer.Exec(w, "index.html", nil) != nil {
 		http.Error(w, "Could not execute template", 500)
-		fmt.Println("Error executing template.")
+		slog.Error("Could not execute template", "template", "index.html")
 		return
 	}
 }
 
-// StartSessionCleanup: Launches a background goroutine that deletes expired sessions
+// StartSessionCleanup: Launches a background goroutine that deletes expired sessions,
+// unfinished two-factor / external logins, and undelivered and audit events past their retention
 // Runs every 15 minutes using the indexed 'expires_at' column for efficiency
 func StartSessionCleanup() {
 	go func() {
 		for {
 			time.Sleep(15 * time.Minute)
-			core.Db.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now())
+			if res, err := core.Db.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now()); err == nil {
+				if n, err := res.RowsAffected(); err == nil {
+					metrics.SessionsCleaned.Add(float64(n))
+				}
+			}
+			core.Db.Exec("DELETE FROM pending_logins WHERE expires_at < ?", time.Now())
+			core.Db.Exec("DELETE FROM oauth_states WHERE expires_at < ?", time.Now())
+			core.Db.Exec("DELETE FROM user_events WHERE created_at < ?", time.Now().Add(-core.AppConfig.DeliveryRetention))
+			audit.Prune(time.Now().Add(-core.AppConfig.AuditRetention))
 		}
 	}()
 }
 
 func main() {
+	// Structured logging first, so every later line uses it (LOG_LEVEL, LOG_FORMAT)
+	core.InitLogging(core.AppConfig)
+
 	frontend_renderer.Init()
 
 	// Load config and open SQLite database connection
-	core.InitDB(core.LoadConfig().DatabasePath)
+	core.InitDB(core.AppConfig.DatabasePath)
 
 	// Initialize and register post/comment services with the shared DB
 	postService := posts.NewPostService(core.Db)
@@ -62,24 +76,73 @@ func main() {
 	commentService := posts.NewCommentService(core.Db)
 	posts.SetCommentService(commentService)
 
+	// Admin accounts (ADMIN_USERS)
+	if promoted, err := auth.PromoteAdmins(core.AppConfig.AdminUsers); err != nil {
+		log.Fatal("Admin users:", err)
+	} else if promoted > 0 {
+		slog.Info("Promoted accounts to admin", "count", promoted)
+	}
+
+	// External login providers (OIDC_* environment variables)
+	auth.InitLoginProviders(core.AppConfig.OIDCProviders)
+
+	// Real-time backplane (BROKER_URL); in-process when unset
+	broker, err := auth.NewBroker(core.AppConfig.BrokerURL, core.AppConfig.BrokerChannel)
+	if err != nil {
+		log.Fatal("Broker:", err)
+	}
+	if err := auth.StartHub(broker); err != nil {
+		log.Fatal("Broker:", err)
+	}
+
 	// Serve static assets (CSS, JS) from the web directory
 	http.Handle("/css/", http.StripPrefix("/css/", http.FileServer(http.Dir("./web/css/"))))
 	http.Handle("/js/", http.StripPrefix("/js/", http.FileServer(http.Dir("./web/js/"))))
 
 	// API endpoints
-	http.HandleFunc("/ws", auth.WebSocketHandler)            // WebSocket for real-time chat
-	http.HandleFunc("/api/posts", posts.PostsHandler)        // Some of the CRUD operations for posts
-	http.HandleFunc("/api/comments", posts.CommentHandler)   // Comment management
-	http.HandleFunc("/api/reactions", posts.ReactionHandler) // Like/dislike reactions
-	http.HandleFunc("/", mainHandler)                        // SPA root entry
+	// Every /api route except the login steps, providers, logout and the real-time fallback
+	// goes through auth.RequireAuth (real-time messages are authenticated like /ws)
+	http.HandleFunc("/ws", auth.WebSocketHandler)                                       // WebSocket for real-time chat
+	http.HandleFunc("GET /api/events", auth.EventStreamHandler)                         // SSE fallback: server events
+	http.HandleFunc("POST /api/events/send", auth.EventSendHandler)                     // SSE fallback: client messages
+	http.HandleFunc("/api/auth/login", auth.LoginHandler)                               // Cookie-based login
+	http.HandleFunc("/api/auth/login/2fa", auth.Login2FAHandler)                        // Second login step (TOTP)
+	http.HandleFunc("/api/auth/providers", auth.ProvidersHandler)                       // External login options
+	http.HandleFunc("GET /api/auth/oidc/{provider}/login", auth.OIDCLoginHandler)       // Redirect to identity provider
+	http.HandleFunc("GET /api/auth/oidc/{provider}/callback", auth.OIDCCallbackHandler) // Code exchange + session cookie
+	http.HandleFunc("/api/auth/logout", auth.LogoutHandler)                             // Clears session cookies + row, even if expired
+	http.HandleFunc("/api/posts", auth.RequireAuth(posts.PostsHandler))                 // Some of the CRUD operations for posts
+	http.HandleFunc("/api/comments", auth.RequireAuth(posts.CommentHandler))            // Comment management
+	http.HandleFunc("/api/reactions", auth.RequireAuth(posts.ReactionHandler))          // Like/dislike reactions
+	http.HandleFunc("/", mainHandler)                                                   // SPA root entry
+
+	// Chat download (json, html or txt), streamed
+	http.HandleFunc("GET /api/conversations/{user_id}/export", auth.RequireAuth(auth.ConversationExportHandler))
+
+	// Personal data: ZIP export and account deletion
+	http.HandleFunc("GET /api/account/export", auth.RequireAuth(auth.AccountExportHandler))
+	http.HandleFunc("DELETE /api/account", auth.RequireAuth(auth.AccountDeleteHandler))
+
+	// Admin only (role "admin", granted through ADMIN_USERS)
+	http.HandleFunc("GET /api/admin/stats", auth.RequireAdmin(auth.AdminStatsHandler))
+	http.HandleFunc("GET /api/admin/stats/timeseries", auth.RequireAdmin(auth.AdminStatsSeriesHandler))
+	http.HandleFunc("GET /api/admin/suspensions", auth.RequireAdmin(auth.AdminSuspensionsHandler))
+	http.HandleFunc("POST /api/admin/users/{user_id}/suspend", auth.RequireAdmin(auth.AdminSuspendHandler))
+	http.HandleFunc("POST /api/admin/users/{user_id}/ban", auth.RequireAdmin(auth.AdminBanHandler))
+	http.HandleFunc("DELETE /api/admin/users/{user_id}/suspension", auth.RequireAdmin(auth.AdminLiftSuspensionHandler))
+	http.HandleFunc("GET /api/admin/audit", auth.RequireAdmin(auth.AdminAuditHandler))
+
+	// Prometheus scrape endpoint; METRICS_TOKEN, when set, is required as a bearer token
+	http.HandleFunc("GET /metrics", metrics.Protect(core.AppConfig.MetricsToken, metrics.Handler))
 
 	// Start periodic cleanup of expired sessions
 	StartSessionCleanup()
 
-	fmt.Println("Server started at http://localhost:8080/#home")
+	slog.Info("Server started", "url", "http://localhost"+core.AppConfig.ServerPort+"/#home")
 
-	// Start HTTP server on port 8080; fatal on failure
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	// Start HTTP server on the configured port (SERVER_PORT, default :8080); fatal on failure
+	// /api requests get a request ID and an access log line
+	if err := http.ListenAndServe(core.AppConfig.ServerPort, core.RequestLogger(http.DefaultServeMux)); err != nil {
 		log.Fatal("ListenAndServe:", err)
 	}
 }
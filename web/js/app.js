@@ -1,6 +1,7 @@
 import { renders } from './renders.js';
 import { setups } from './setupEvent.js';
-import { throttle } from './utils.js'; // Import throttle from a new utility file
+import { throttle, csrfToken } from './utils.js'; // Import throttle from a new utility file
+import { EventStreamSocket } from './transport.js';
 
 // RealTimeForum: Core SPA controller managing auth, routing, WS, posts, comments, and chat
 class RealTimeForum {
@@ -9,13 +10,21 @@ class RealTimeForum {
         this.isAuthenticated = false;
         this.userData = {};
         this.currentPage = 'home';
-        this.ws = new WebSocket("ws://localhost:8080/ws");
+        this.useEventStream = false; // SSE fallback once a WebSocket upgrade has failed
+        this.ws = this.openSocket();
         this.isLoggingOut = false;
+        this.isRestartingWS = false;
         this.activeFilters = null; // Track active filters
         this.activeChatUserId = null;
+        this.editingMessageId = null; // own message being edited in the chat input
+        this.replyingToMessageId = null; // message quoted by the next one sent
         this.chatOffsets = {}; // Stores message offset for each chat
         this.isLoadingMessages = false; // Flag to prevent multiple loads
         this.userList = [];
+        this.unreadFrom = new Set(); // users with unread messages (kept across sidebar re-renders)
+        this.seenMessageIds = new Set(); // dedup: replays and multi-device echoes repeat messages
+        this.pendingAck = 0; // highest durable seq processed but not yet acknowledged
+        this.ackTimer = null;
         this.chatScrollHandler = throttle(this.handleChatScroll, 200);
         this.typing = null;
         this.typingTimeouts = {};
@@ -29,15 +38,21 @@ class RealTimeForum {
         this.router();
     }
 
+    // openSocket: WebSocket, or the SSE transport for networks that break the upgrade
+    openSocket() {
+        return this.useEventStream ? new EventStreamSocket() : new WebSocket("ws://localhost:8080/ws");
+    }
+
     // setupWS: Configures WebSocket lifecycle events and session validation
     setupWS() {
-        // On open: Validate existing session
+        let opened = false;
+        // On open: Validate existing session (the server reads the HttpOnly session cookie)
         this.ws.addEventListener("open", () => {
-            const session = localStorage.getItem('session_id');
-            if (session) {
+            opened = true;
+            if (localStorage.getItem('logged_in')) {
                 const payload = JSON.stringify({
                     type: "session_check",
-                    data: { user: { session_id: session } }
+                    data: { user: {} }
                 });
                 this.sendWS(payload);
             }
@@ -54,15 +69,60 @@ class RealTimeForum {
                 this.isLoggingOut = false;
                 return;
             }
+            if (this.isRestartingWS) {
+                this.isRestartingWS = false;
+                return;
+            }
+            // Never opened: a proxy may be breaking the upgrade - switch transports and retry
+            // (and back again, so a server that was simply down gets WebSocket once it returns)
+            if (!opened && event.code === 1006) {
+                this.useEventStream = !this.useEventStream;
+                console.warn(`Connection failed, retrying over ${this.useEventStream ? 'Server-Sent Events' : 'WebSocket'}`);
+                setTimeout(() => this.reconnectWS(), this.useEventStream ? 0 : 1000);
+                return;
+            }
+            // 4001: this session was revoked from another device
+            if (event.code === 4001) {
+                this.handleLogout();
+                renders.Error('This session was logged out from another device.');
+                return;
+            }
+            // 4008: an admin suspended or banned the account - the reason says why and until when
+            if (event.code === 4008) {
+                this.handleLogout(false);
+                renders.Error(event.reason || 'Your account has been suspended.');
+                return;
+            }
+            // 4002: session expired (the session_expired event already logged us out)
+            if (event.code === 4002) {
+                if (this.isAuthenticated) this.handleLogout();
+                return;
+            }
+            // 4004: this page's origin may not connect - retrying cannot help
+            if (event.code === 4004) {
+                renders.Error('Connection refused by the server.');
+                return;
+            }
+            // 4003: disconnected for flooding, 4005/4006: too many open connections - reconnect, but slowly
+            const closeMessages = {
+                4003: 'You were disconnected for sending too many messages.',
+                4005: 'Too many open tabs or devices for this account. Close one and try again.',
+                4006: 'Too many connections from your network.',
+            };
+            const delay = closeMessages[event.code] ? 10000 : 1000;
+            if (closeMessages[event.code]) {
+                renders.Error(closeMessages[event.code]);
+            }
             console.warn("WebSocket closed with code:", event.code, "reason:", event.reason);
             setTimeout(() => {
                 console.log("Attempting to reconnect...");
                 this.reconnectWS();
-            }, 1000);
+            }, delay);
         });
 
         // On error: Show UI error and log
         this.ws.addEventListener("error", (err) => {
+            if (!opened && !this.useEventStream) return; // the close handler falls back to SSE
             console.error('WebSocket error:', err);
             renders.Error('Connection to server lost. Please try again.');
         });
@@ -77,13 +137,25 @@ class RealTimeForum {
             this.ws.onerror = null;
             this.ws.close();
         }
-        this.ws = new WebSocket("ws://localhost:8080/ws");
+        this.ws = this.openSocket();
+        this.setupWS();
+    }
+
+    // restartWS: Opens a fresh WebSocket so the upgrade request carries the new session cookie
+    restartWS() {
+        if (this.ws) {
+            this.isRestartingWS = true;
+            this.ws.close();
+        }
+        this.ws = this.openSocket();
         this.setupWS();
     }
 
     // BackToFrontPayload: Central dispatcher for all WebSocket messages
     BackToFrontPayload(event) {
         const data = JSON.parse(event.data);
+        // Durable events carry a seq; acknowledging it stops the server from replaying them
+        if (data.seq) this.scheduleAck(data.seq);
         switch (data.type) {
 
             // register_result: Handle registration success/failure
@@ -101,14 +173,12 @@ class RealTimeForum {
             case "login_result":
                 if (data.status === "ok") {
                     this.userData = {};
-                    localStorage.setItem("session_id", data.data.user.session_id);
-                    this.sessionID = data.data.user.session_id;
+                    localStorage.setItem("logged_in", "1");
                     this.userData = data.data.user;
                     this.isAuthenticated = true;
                     this.router()
                 } else {
-                    localStorage.removeItem("session_id");
-                    this.sessionID = null;
+                    localStorage.removeItem("logged_in");
                     window.location.hash = 'login';
                     renders.Error(data.error)
                 }
@@ -120,13 +190,22 @@ class RealTimeForum {
 
                     this.userList = data.data;
                     renders.Users(this.userList, this.userData);
+                    this.unreadFrom.forEach(userId => this.showNotification(userId));
                 } else { renders.Error(data.error) }
                 break;
 
+            // presence_changed: Merge one user's online state into the list
+            case "presence_changed":
+                if (data.status === "ok") {
+                    this.mergePresence(data.data);
+                }
+                break;
+
             // private_message: Handle incoming chat messages
             case "private_message":
                 if (data.status === "ok") {
                     const msg = data.data;
+                    if (!this.rememberMessage(msg.message_id)) break; // already shown
                     const isOwn = msg.sender_id === this.userData.user_id;
                     const otherUserId = isOwn ? msg.recipient_id : msg.sender_id;
                     // Always update the last message preview in the sidebar
@@ -158,9 +237,10 @@ class RealTimeForum {
                     const oldScrollHeight = chatMessagesContainer.scrollHeight;
 
                     messages.reverse(); // Reverse to prepend in correct order
+                    messages.forEach(msg => this.rememberMessage(msg.message_id));
                     const messagesHTML = messages.map(msg => {
                         const isOwn = msg.sender_id === this.userData.user_id;
-                        return renders.ChatMessage(msg, isOwn);
+                        return renders.ChatMessage(msg, isOwn, this.userData.user_id);
                     }).join('');
 
                     chatMessagesContainer.insertAdjacentHTML('afterbegin', messagesHTML);
@@ -177,6 +257,126 @@ class RealTimeForum {
                     renders.Error(data.error);
                 }
                 break;
+            // session_expiring: Offer to extend the session before it runs out
+            case "session_expiring":
+                renders.SessionExpiring(data.data.seconds_left);
+                break;
+
+            // session_expired: Server closed the connection, back to login
+            case "session_expired":
+                this.handleLogout();
+                renders.Error(data.error || 'Your session has expired. Please log in again');
+                break;
+
+            // refresh_session_result: Session extended, drop the warning
+            case "refresh_session_result":
+                if (data.status === "ok") {
+                    document.querySelector('.session-notice')?.remove();
+                } else {
+                    renders.Error(data.error);
+                }
+                break;
+
+            // rate_limited: The server dropped a message, tell the user to slow down
+            // message_edited / message_deleted: A message in one of our conversations changed
+            case "message_edited":
+            case "message_deleted":
+                if (data.status === "ok") {
+                    this.replaceChatMessage(data.data);
+                }
+                break;
+
+            // message_reactions: Reaction totals of a message changed
+            case "message_reactions":
+                if (data.status === "ok") {
+                    const messageEl = document.querySelector(`.message[data-message-id="${data.data.message_id}"]`);
+                    if (messageEl) renders.MessageReactions(messageEl, data.data.reactions, this.userData.user_id);
+                }
+                break;
+
+            case "edit_message_result":
+            case "delete_message_result":
+            case "react_message_result":
+            case "unreact_message_result":
+                if (data.status !== "ok") renders.Error(data.error);
+                break;
+
+            // replay_done: Missed events were re-sent; fetch the next batch if there is one
+            case "replay_done":
+                if (data.status === "ok" && data.data.more) {
+                    this.flushAck();
+                    this.sendWS(JSON.stringify({ type: "replay" }));
+                }
+                break;
+
+            case "rate_limited":
+                if (data.data.type !== "typing" && data.data.type !== "ack") {
+                    renders.Error(`${data.error} (retry in ${Math.ceil(data.data.retry_after_ms / 1000)}s)`);
+                }
+                break;
+
+            // login_2fa_required: Password accepted, ask for the second factor
+            case "login_2fa_required":
+                renders.TwoFactorLogin(data.data.pending_token);
+                setups.TwoFactorEvents(this);
+                break;
+
+            case "login_2fa_result":
+                renders.Error(data.error);
+                break;
+
+            // totp_*_result: Two-factor management on the profile page
+            case "totp_status_result":
+                if (data.status === "ok") {
+                    renders.TwoFactor('status', data.data);
+                } else {
+                    renders.Error(data.error);
+                }
+                break;
+
+            case "totp_setup_result":
+                if (data.status === "ok") {
+                    renders.TwoFactor('setup', data.data);
+                } else {
+                    renders.Error(data.error);
+                }
+                break;
+
+            case "totp_enable_result":
+                if (data.status === "ok") {
+                    renders.TwoFactor('recovery_codes', data.data.recovery_codes);
+                } else {
+                    renders.Error(data.error);
+                }
+                break;
+
+            case "totp_disable_result":
+                if (data.status === "ok") {
+                    this.sendWS(JSON.stringify({ type: "totp_status" }));
+                } else {
+                    renders.Error(data.error);
+                }
+                break;
+
+            // list_sessions_result: Show logged-in devices on the profile page
+            case "list_sessions_result":
+                if (data.status === "ok") {
+                    renders.Sessions(data.data);
+                } else {
+                    renders.Error(data.error);
+                }
+                break;
+
+            // revoke_session_result / revoke_other_sessions_result: Refresh the sessions list
+            case "revoke_session_result":
+            case "revoke_other_sessions_result":
+                if (data.status === "ok") {
+                    this.sendWS(JSON.stringify({ type: "list_sessions" }));
+                } else {
+                    renders.Error(data.error);
+                }
+                break;
+
             case "typing_result":
                 const typingData = data.data;
                 const typerId = typingData.whoIsTyping
@@ -243,11 +443,11 @@ class RealTimeForum {
         const protectedPages = ['home', 'profile'];
         const authPages = ['login', 'register'];
 
-        if (!localStorage.getItem('session_id') && protectedPages.includes(path) || path === '') {
+        if (!localStorage.getItem('logged_in') && protectedPages.includes(path) || path === '') {
             window.location.hash = 'login'; return;
         }
 
-        if (localStorage.getItem('session_id') && authPages.includes(path)) {
+        if (localStorage.getItem('logged_in') && authPages.includes(path)) {
             window.location.hash = 'home'; return;
         }
 
@@ -271,6 +471,14 @@ class RealTimeForum {
             case 'login':
                 renders.Login(this.userData)
                 setups.AuthEvents('login', this);
+                this.loadLoginProviders();
+                break;
+
+            // sso-complete: Back from the identity provider with a session cookie
+            case 'sso-complete':
+                localStorage.setItem("logged_in", "1");
+                this.restartWS();
+                window.location.hash = 'home';
                 break;
 
             // register: Render registration form
@@ -282,6 +490,10 @@ class RealTimeForum {
             // profile: Show user profile
             case 'profile':
                 renders.Profile(this.userData);
+                if (this.isAuthenticated) {
+                    this.sendWS(JSON.stringify({ type: "list_sessions" }));
+                    this.sendWS(JSON.stringify({ type: "totp_status" }));
+                }
                 break;
 
             // logout: Trigger logout flow
@@ -303,17 +515,13 @@ class RealTimeForum {
             this.router();
         });
 
-        // storage: Sync session across tabs
+        // storage: Sync login state across tabs (the session cookie itself is shared)
         window.addEventListener('storage', (event) => {
-            if (event.key === 'session_id' && event.newValue) {
-                const sessionPayload = JSON.stringify({
-                    type: "session_check",
-                    data: { user: { session_id: event.newValue } }
-                });
-                this.sendWS(sessionPayload);
+            if (event.key === 'logged_in' && event.newValue && !this.isAuthenticated) {
+                this.restartWS();
             }
-            if (event.key === 'session_id' && (!event.newValue || event.newValue !== this.sessionID && this.sessionID != null)) {                
-                this.handleLogout();
+            if (event.key === 'logged_in' && !event.newValue && this.isAuthenticated) {
+                this.handleLogout(false);
             }
         });
 
@@ -328,6 +536,81 @@ class RealTimeForum {
             if (e.target.closest('.close-btn')) this.closeChat();
             // Send message button
             if (e.target.closest('#send-message-btn')) this.sendMessage();
+            // Reply to a chat message / jump to a quoted one
+            const replyBtn = e.target.closest('.message-reply-btn');
+            if (replyBtn) this.startReply(replyBtn.closest('.message'));
+            if (e.target.closest('#cancel-reply')) this.stopReply();
+            const quote = e.target.closest('.message-quote');
+            if (quote) {
+                const original = document.querySelector(`.message[data-message-id="${quote.getAttribute('data-reply-to')}"]`);
+                original?.scrollIntoView({ behavior: 'smooth', block: 'center' });
+            }
+            // Edit / unsend own chat messages
+            const editBtn = e.target.closest('.message-edit-btn');
+            if (editBtn) this.startEditingMessage(editBtn.closest('.message'));
+            // Message reactions: picker toggle, picking an emoji, toggling an existing chip
+            const pickerToggle = e.target.closest('.reaction-picker-toggle');
+            if (pickerToggle) {
+                pickerToggle.nextElementSibling.classList.toggle('hidden');
+            }
+            const reactionBtn = e.target.closest('.reaction-option, .reaction-chip');
+            if (reactionBtn) {
+                const remove = reactionBtn.getAttribute('data-mine') === 'true';
+                this.sendWS(JSON.stringify({
+                    type: remove ? "unreact_message" : "react_message",
+                    data: {
+                        message_id: reactionBtn.closest('.message').getAttribute('data-message-id'),
+                        emoji: reactionBtn.getAttribute('data-emoji')
+                    }
+                }));
+                reactionBtn.closest('.reaction-picker-options')?.classList.add('hidden');
+            }
+            const deleteBtn = e.target.closest('.message-delete-btn');
+            if (deleteBtn && confirm('Delete this message for everyone?')) {
+                this.sendWS(JSON.stringify({
+                    type: "delete_message",
+                    data: { message_id: deleteBtn.closest('.message').getAttribute('data-message-id') }
+                }));
+            }
+            // Session management (profile page)
+            const revokeBtn = e.target.closest('.revoke-session-btn');
+            if (revokeBtn) {
+                this.sendWS(JSON.stringify({
+                    type: "revoke_session",
+                    data: { id: revokeBtn.getAttribute('data-session-id') }
+                }));
+            }
+            if (e.target.closest('.refresh-session-btn')) {
+                this.sendWS(JSON.stringify({ type: "refresh_session" }));
+            }
+            if (e.target.closest('.revoke-other-sessions-btn')) {
+                this.sendWS(JSON.stringify({ type: "revoke_other_sessions" }));
+            }
+            if (e.target.closest('.delete-account-btn')) {
+                this.deleteAccount();
+            }
+            // External login: carry the remember-me choice through the provider round trip
+            const ssoBtn = e.target.closest('.sso-login-btn');
+            if (ssoBtn) {
+                const rememberMe = document.getElementById('remember_me')?.checked;
+                window.location.href = ssoBtn.getAttribute('data-login-url') + (rememberMe ? '?remember_me=1' : '');
+            }
+            // Two-factor authentication (profile page)
+            if (e.target.closest('.totp-setup-btn')) {
+                this.sendWS(JSON.stringify({ type: "totp_setup" }));
+            }
+            if (e.target.closest('.totp-enable-btn')) {
+                this.sendWS(JSON.stringify({
+                    type: "totp_enable",
+                    data: { code: document.getElementById('totp-enable-code').value }
+                }));
+            }
+            if (e.target.closest('.totp-disable-btn')) {
+                this.sendWS(JSON.stringify({
+                    type: "totp_disable",
+                    data: { code: document.getElementById('totp-disable-code').value }
+                }));
+            }
         });
 
         document.addEventListener('input', (e) => {
@@ -362,7 +645,7 @@ class RealTimeForum {
         } else {
 
             // create new WS if needed
-            this.ws = new WebSocket("ws://localhost:8080/ws");
+            this.ws = this.openSocket();
 
             // setup all events on the new ws
             this.setupWS();
@@ -374,15 +657,77 @@ class RealTimeForum {
         }
     }
 
-    // handleLogin: Submit login credentials via WebSocket
-    handleLogin() {
+    // handleLogin: Submit credentials over REST; the session comes back as an HttpOnly cookie
+    async handleLogin() {
         const emailOrNickname = document.getElementById('email_or_nickname').value;
         const password = document.getElementById('password').value;
-        const loginPayload = JSON.stringify({
-            type: "login",
-            data: { user: { email_or_nickname: emailOrNickname, password: password } }
-        });
-        this.sendWS(loginPayload);
+        const rememberMe = document.getElementById('remember_me')?.checked || false;
+        try {
+            const response = await fetch('/api/auth/login', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ user: { email_or_nickname: emailOrNickname, password: password, remember_me: rememberMe } })
+            });
+            const data = await response.json();
+            if (!response.ok) {
+                throw new Error(data.error || `Login failed: ${response.status}`);
+            }
+            if (data.status === '2fa_required') {
+                renders.TwoFactorLogin(data.pending_token);
+                setups.TwoFactorEvents(this);
+                return;
+            }
+            localStorage.setItem("logged_in", "1");
+            // Reconnect so the WebSocket is bound to the new session cookie
+            this.restartWS();
+        } catch (err) {
+            renders.Error(err.message);
+        }
+    }
+
+    // loadLoginProviders: Show external login buttons, any error from a failed SSO attempt,
+    // or the code form when a 2FA account comes back from its provider
+    async loadLoginProviders() {
+        const params = new URLSearchParams(window.location.search);
+        if (params.has('sso_error')) {
+            renders.Error(params.get('sso_error'));
+            history.replaceState(null, '', '/' + window.location.hash);
+        }
+        // sso_2fa: The provider login went through, the account still needs its second factor
+        // The pending token is in an HttpOnly cookie the server reads on /api/auth/login/2fa
+        if (params.has('sso_2fa')) {
+            history.replaceState(null, '', '/' + window.location.hash);
+            renders.TwoFactorLogin('');
+            setups.TwoFactorEvents(this);
+            return;
+        }
+        try {
+            const response = await fetch('/api/auth/providers');
+            if (!response.ok) return;
+            renders.LoginProviders(await response.json());
+        } catch (err) {
+            console.error('Failed to load login providers:', err);
+        }
+    }
+
+    // handleLogin2FA: Redeem the pending login with an authenticator or recovery code
+    async handleLogin2FA(pendingToken) {
+        const code = document.getElementById('totp_code').value;
+        try {
+            const response = await fetch('/api/auth/login/2fa', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ pending_token: pendingToken, code: code })
+            });
+            const data = await response.json();
+            if (!response.ok) {
+                throw new Error(data.error || `Verification failed: ${response.status}`);
+            }
+            localStorage.setItem("logged_in", "1");
+            this.restartWS();
+        } catch (err) {
+            renders.Error(err.message);
+        }
     }
 
     // handleRegister: Submit registration data via WebSocket
@@ -405,13 +750,50 @@ class RealTimeForum {
         this.sendWS(registerPayload);
     }
 
-    // handleLogout: Clear session, close WS, redirect to login
-    handleLogout() {
+    // deleteAccount: Confirm with the password (nickname for single sign-on accounts), then delete
+    // The server closes our connection with 4001; isLoggingOut keeps that from showing an error
+    async deleteAccount() {
+        const confirmation = prompt('This permanently deletes your account. Enter your password to confirm (or your nickname if you sign in with an external provider):');
+        if (!confirmation) return;
+        this.isLoggingOut = true;
+        try {
+            const response = await fetch('/api/account', {
+                method: 'DELETE',
+                headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() },
+                body: JSON.stringify({ confirmation })
+            });
+            if (!response.ok) {
+                this.isLoggingOut = false;
+                const data = await response.json().catch(() => ({}));
+                renders.Error(data.error || 'Unable to delete account');
+                return;
+            }
+            this.handleLogout(false);
+        } catch (err) {
+            this.isLoggingOut = false;
+            console.error('Account deletion error:', err);
+            renders.Error('Unable to delete account');
+        }
+    }
+
+    // handleLogout: End the session server-side, close WS, redirect to login
+    // notifyServer is false when another tab already logged out (cookie is gone)
+    handleLogout(notifyServer = true) {
+        if (notifyServer) {
+            fetch('/api/auth/logout', {
+                method: 'POST',
+                headers: { 'X-CSRF-Token': csrfToken() }
+            }).catch(err => console.error('Logout error:', err));
+        }
         this.isAuthenticated = false;
         this.isLoggingOut = true;
         this.userData = {};
-        this.sessionID = null;
-        localStorage.removeItem('session_id');
+        this.unreadFrom.clear();
+        this.seenMessageIds.clear();
+        clearTimeout(this.ackTimer);
+        this.ackTimer = null;
+        this.pendingAck = 0;
+        localStorage.removeItem('logged_in');
         if (this.ws) {
             this.ws.close();
             this.ws = null;
@@ -428,9 +810,7 @@ class RealTimeForum {
         const content = postCreateForm.querySelector('textarea[name="content"]').value;
         const categories = [...postCreateForm.querySelectorAll('input[name="categories"]:checked')].map(input => input.value);
 
-        const sessionId = this.sessionID || localStorage.getItem('session_id');
-
-        if (!sessionId) {
+        if (!localStorage.getItem('logged_in')) {
             renders.Error('No session found. Please log in again.');
             window.location.hash = 'login';
             return;
@@ -441,7 +821,7 @@ class RealTimeForum {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
-                    'Session-ID': sessionId,
+                    'X-CSRF-Token': csrfToken(),
                     'request-type': 'create_post'
                 },
                 body: JSON.stringify({ content, categories })
@@ -489,7 +869,6 @@ class RealTimeForum {
                 method: 'GET',
                 headers: {
                     'Content-Type': 'application/json',
-                    'Session-ID': localStorage.getItem('session_id'),
                     'request-type': 'filter_posts'
                 }
             });
@@ -523,7 +902,7 @@ class RealTimeForum {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
-                    'Session-ID': localStorage.getItem('session_id'),
+                    'X-CSRF-Token': csrfToken(),
                     'request-type': 'create_comment'
                 },
                 body: JSON.stringify({ post_id, content })
@@ -561,7 +940,6 @@ class RealTimeForum {
             let url = '/api/posts';
             const headers = {
                 'Content-Type': 'application/json',
-                'Session-ID': localStorage.getItem('session_id'),
                 'Last-Post-ID': lastPostId
             };
 
@@ -633,7 +1011,6 @@ class RealTimeForum {
                 method: 'GET',
                 headers: {
                     'Content-Type': 'application/json',
-                    'Session-ID': localStorage.getItem('session_id'),
                     'request-type': 'fetch-3-comments',
                     'Post-ID': postId,
                     'Last-Comment-ID': lastCommentId
@@ -666,20 +1043,20 @@ class RealTimeForum {
         }
     }
 
-    // handleReaction: Submit like/dislike and update UI counts
-    async handleReaction({ postId, commentId, reactionType }) {
+    // handleReaction: Toggle a reaction and update UI counts
+    async handleReaction({ postId, commentId, reaction }) {
 
         try {
             const response = await fetch('/api/reactions', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
-                    'Session-ID': localStorage.getItem('session_id')
+                    'X-CSRF-Token': csrfToken()
                 },
                 body: JSON.stringify({
                     post_id: postId || '',
                     comment_id: commentId || '',
-                    reaction_type: reactionType // 1 for like, -1 for dislike
+                    reaction: reaction // key from the reaction registry
                 })
             });
             if (!response.ok) {
@@ -701,8 +1078,11 @@ class RealTimeForum {
                     : `.comment[data-comment-id="${commentId}"]`;
                 const container = document.querySelector(selector);
                 if (container) {
-                    container.querySelector('.like-btn').textContent = `👍 ${data.like_count}`;
-                    container.querySelector('.dislike-btn').textContent = `👎 ${data.dislike_count}`;
+                    // Only this post's/comment's own buttons (a post also contains its comments)
+                    const attr = postId ? 'data-post-id' : 'data-comment-id';
+                    container.querySelectorAll(`.reaction-btn[${attr}][data-reaction]`).forEach(btn => {
+                        btn.querySelector('.count').textContent = data.reaction_counts[btn.getAttribute('data-reaction')] || 0;
+                    });
                 }
             }
         } catch (err) {
@@ -726,6 +1106,9 @@ class RealTimeForum {
         const chatContainer = document.getElementById('active-chat-container');
         chatContainer.style.display = 'block';
         document.getElementById('chat-with-user').textContent = `Chat with ${user.nickname}`;
+        document.querySelectorAll('.chat-export-link').forEach(link => {
+            link.href = `/api/conversations/${encodeURIComponent(userId)}/export?format=${link.getAttribute('data-format')}`;
+        });
 
         // Create a new throttled handler for this chat session
         this.chatScrollHandler = throttle((e) => {
@@ -755,6 +1138,14 @@ class RealTimeForum {
     sendMessage() {
         const input = document.getElementById('message-input');
         const messageText = input.value.trim();
+        if (messageText && this.editingMessageId) {
+            this.sendWS(JSON.stringify({
+                type: "edit_message",
+                data: { message_id: this.editingMessageId, content: messageText }
+            }));
+            this.stopEditingMessage();
+            return;
+        }
         if (messageText && this.activeChatUserId) {
             const payload = {
                 type: "private_message",
@@ -762,10 +1153,77 @@ class RealTimeForum {
 
                     recipient_id: this.activeChatUserId,
                     content: messageText,
+                    reply_to_message_id: this.replyingToMessageId || undefined,
                 }
             };
             this.sendWS(JSON.stringify(payload));
             input.value = '';
+            this.stopReply();
+        }
+    }
+
+    // startReply: Quote a message of the open conversation in the next one sent
+    startReply(messageEl) {
+        const banner = document.getElementById('reply-banner');
+        if (!messageEl || !banner) return;
+        if (this.editingMessageId) this.stopEditingMessage();
+        this.replyingToMessageId = messageEl.getAttribute('data-message-id');
+        const sender = messageEl.querySelector('.message-sender').textContent;
+        const content = messageEl.querySelector('.message-content').innerText.trim();
+        banner.querySelector('.reply-banner-text').textContent =
+            `Replying to ${sender}: ${content.length > 60 ? content.substring(0, 60) + '...' : content}`;
+        banner.classList.remove('hidden');
+        document.getElementById('message-input')?.focus();
+    }
+
+    // stopReply: Next message is a plain one again
+    stopReply() {
+        this.replyingToMessageId = null;
+        document.getElementById('reply-banner')?.classList.add('hidden');
+    }
+
+    // startEditingMessage: Load an own message into the chat input; Send saves the edit
+    startEditingMessage(messageEl) {
+        const input = document.getElementById('message-input');
+        const sendBtn = document.getElementById('send-message-btn');
+        if (!messageEl || !input || !sendBtn) return;
+        this.stopReply();
+        this.editingMessageId = messageEl.getAttribute('data-message-id');
+        input.value = messageEl.querySelector('.message-content').innerText.trim();
+        sendBtn.textContent = 'Save';
+        input.focus();
+    }
+
+    // stopEditingMessage: Back to composing new messages
+    stopEditingMessage() {
+        this.editingMessageId = null;
+        const input = document.getElementById('message-input');
+        const sendBtn = document.getElementById('send-message-btn');
+        if (input) input.value = '';
+        if (sendBtn) sendBtn.textContent = 'Send';
+    }
+
+    // replaceChatMessage: Re-render a message bubble after an edit or unsend
+    replaceChatMessage(message) {
+        const isOwn = message.sender_id === this.userData.user_id;
+        const otherUserId = isOwn ? message.recipient_id : message.sender_id;
+        const existing = document.querySelector(`.message[data-message-id="${message.message_id}"]`);
+        if (existing) {
+            const wrapper = document.createElement('div');
+            wrapper.innerHTML = renders.ChatMessage(message, isOwn, this.userData.user_id);
+            existing.replaceWith(wrapper.firstElementChild);
+        }
+        if (this.editingMessageId === message.message_id && message.deleted_at) {
+            this.stopEditingMessage();
+        }
+        // Sidebar preview, if this is the conversation's latest message
+        const cached = this.userList.find(u => u.id === otherUserId);
+        if (cached && cached.created_at === message.created_at) {
+            cached.lastMsg = message.deleted_at ? 'Message deleted' : message.content;
+            const preview = document.querySelector(`.user-list-item[data-user-id="${otherUserId}"] .last-message`);
+            if (preview) {
+                preview.textContent = cached.lastMsg.length > 25 ? cached.lastMsg.substring(0, 25) + '...' : cached.lastMsg;
+            }
         }
     }
 
@@ -775,14 +1233,62 @@ class RealTimeForum {
         const chatMessagesContainer = document.getElementById('chat-messages');
         if (chatMessagesContainer) {
             const messageEl = document.createElement('div');
-            messageEl.innerHTML = renders.ChatMessage(message, isOwn);
+            messageEl.innerHTML = renders.ChatMessage(message, isOwn, this.userData.user_id);
             chatMessagesContainer.appendChild(messageEl.firstElementChild);
             chatMessagesContainer.scrollTop = chatMessagesContainer.scrollHeight; // Auto-scroll to the latest message
         }
     }
 
+    // mergePresence: Apply a presence_changed event to the cached user list and sidebar
+    mergePresence(presence) {
+        if (!this.userData || !this.userList.length) return; // list not fetched yet, users_list will be current
+        let user = this.userList.find(u => u.id === presence.user_id);
+        if (!user) {
+            // Registered after the list was fetched
+            user = { id: presence.user_id, nickname: presence.nickname, lastMsg: "", created_at: "", sender_id: "", recipient_id: "" };
+            this.userList.push(user);
+        }
+        if (user.isOnline === presence.online) return;
+        user.isOnline = presence.online;
+        renders.UserPresence(user, this.userList, this.userData);
+    }
+
+    // rememberMessage: Records a message ID; false if it was already seen
+    rememberMessage(messageId) {
+        if (!messageId) return true;
+        if (this.seenMessageIds.has(messageId)) return false;
+        this.seenMessageIds.add(messageId);
+        if (this.seenMessageIds.size > 2000) {
+            this.seenMessageIds.delete(this.seenMessageIds.values().next().value); // oldest first
+        }
+        return true;
+    }
+
+    // scheduleAck: Acknowledge durable events in batches rather than one by one
+    scheduleAck(seq) {
+        this.pendingAck = Math.max(this.pendingAck, seq);
+        if (!this.ackTimer) {
+            this.ackTimer = setTimeout(() => this.flushAck(), 500);
+        }
+    }
+
+    // flushAck: Send the highest processed seq now
+    flushAck() {
+        clearTimeout(this.ackTimer);
+        this.ackTimer = null;
+        if (this.pendingAck && this.isAuthenticated) {
+            this.sendWS(JSON.stringify({ type: "ack", data: { seq: this.pendingAck } }));
+            this.pendingAck = 0;
+        }
+    }
+
     // updateLastMessage: Update sidebar preview and timestamp
     updateLastMessage(userId, content, timestamp) {
+        const cached = this.userList.find(u => u.id === userId);
+        if (cached) {
+            cached.lastMsg = content;
+            cached.created_at = String(timestamp);
+        }
         const userItem = document.querySelector(`.user-list-item[data-user-id="${userId}"]`);
         if (userItem) {
             const lastMsgEl = userItem.querySelector('.last-message');
@@ -800,6 +1306,7 @@ class RealTimeForum {
 
     // showNotification: Display unread dot and move to top
     showNotification(userId) {
+        this.unreadFrom.add(userId);
         const userItem = document.querySelector(`.user-list-item[data-user-id="${userId}"]`);
         const userList = userItem?.parentElement; // get the parent list
 
@@ -809,6 +1316,7 @@ class RealTimeForum {
 
     // hideNotification: Remove unread indicator
     hideNotification(userId) {
+        this.unreadFrom.delete(userId);
         const userItem = document.querySelector(`.user-list-item[data-user-id="${userId}"]`);
         if (userItem) {
             userItem.querySelector('.notification-dot')?.classList.add('hidden');
@@ -827,6 +1335,8 @@ class RealTimeForum {
             }
         }
 
+        if (this.editingMessageId) this.stopEditingMessage();
+        this.stopReply();
         this.activeChatUserId = null;
         const chatContainer = document.getElementById('active-chat-container');
         if (chatContainer) chatContainer.style.display = 'none';
@@ -1,6 +1,12 @@
 import { escapeHTML, formatMessage } from "./utils.js";
 export const components = {};
 
+// reactionTypes: Post/comment reaction registry, replaced by GET /api/reactions on first load
+components.reactionTypes = [
+    { key: 'like', emoji: '👍', label: 'Like', score: 1 },
+    { key: 'dislike', emoji: '👎', label: 'Dislike', score: -1 }
+];
+
 // posts: Full posts container with list and loader
 components.posts = (posts, isAuthenticated) => {
     return `
@@ -169,14 +175,7 @@ components.post = (post, isAuthenticated) => {
                 </div>
                 <div class="post-stats">
                     ${isAuthenticated ? `
-                        <button class="reaction-btn like-btn" data-post-id="${post.post_id}" data-type="1">
-                            <span>👍</span>
-                            <span class="count">${post.like_count}</span>
-                        </button>
-                        <button class="reaction-btn dislike-btn" data-post-id="${post.post_id}" data-type="-1">
-                            <span>👎</span>
-                            <span class="count">${post.dislike_count}</span>
-                        </button>
+                        ${components.reactionButtons(post, `data-post-id="${post.post_id}"`)}
                         <button class="reaction-btn comments-btn toggle-comments" data-post-id="${post.post_id}">
                             💬
                             <span class="count">${post.comments ? post.comment_count : 0}</span>
@@ -206,6 +205,17 @@ components.post = (post, isAuthenticated) => {
     `;
 };
 
+// reactionButtons: One toggle per registry reaction with its count
+// target is the data attribute naming the post or comment; like/dislike keep their colors
+components.reactionButtons = (item, target) => {
+    const counts = item.reaction_counts || { like: item.like_count || 0, dislike: item.dislike_count || 0 };
+    return components.reactionTypes.map(type => `
+                        <button class="reaction-btn ${type.score > 0 ? 'like-btn' : type.score < 0 ? 'dislike-btn' : 'emoji-btn'}" ${target} data-reaction="${escapeHTML(type.key)}" title="${escapeHTML(type.label)}">
+                            <span>${escapeHTML(type.emoji)}</span>
+                            <span class="count">${counts[type.key] || 0}</span>
+                        </button>`).join('');
+};
+
 // commentForm: Form to add a new comment
 components.commentForm = (post_id) => {
     return `
@@ -229,14 +239,7 @@ components.comment = (comment, isAuthenticated) => {
             <p class="comment-content">${escapeHTML(comment.content)}</p>
             ${isAuthenticated ? `
                 <div class="comment-reactions">
-                    <button class="reaction-btn like-btn" data-comment-id="${comment.comment_id}" data-type="1">
-                        <span>👍</span>
-                        <span class="count">${comment.like_count || 0}</span>
-                    </button>
-                    <button class="reaction-btn dislike-btn" data-comment-id="${comment.comment_id}" data-type="-1">
-                        <span>👎</span>
-                        <span class="count">${comment.dislike_count || 0}</span>
-                    </button>
+                    ${components.reactionButtons(comment, `data-comment-id="${comment.comment_id}"`)}
                 </div>
             ` : ''}
         </div>
@@ -258,6 +261,11 @@ components.login = (userData = {}) => {
                     
                     <label for="password">Password</label>
                     <input type="password" id="password" name="password" placeholder="Enter your password">
+
+                    <label class="remember-me">
+                        <input type="checkbox" id="remember_me" name="remember_me">
+                        <span>Remember me</span>
+                    </label>
                     
                     <div class="new_account_div">
                         <b>Don't have an account?</b>
@@ -265,11 +273,21 @@ components.login = (userData = {}) => {
                     </div>
                     <input type="submit" class="login_button" value="Sign In">
                 </form>
+                <div id="sso-providers" class="sso-providers"></div>
             </div>
         </div>
     `;
 };
 
+// ssoButton: "Sign in with ..." button for an external login provider
+components.ssoButton = (provider) => {
+    return `
+        <button type="button" class="sso-login-btn btn-secondary" data-login-url="${escapeHTML(provider.login_url)}">
+            Sign in with ${escapeHTML(provider.display_name)}
+        </button>
+    `;
+};
+
 // register: Full registration form
 components.register = () => {
     return `
@@ -354,10 +372,92 @@ components.profile = (userData) => {
                     <span>${escapeHTML(userData.gender) || 'Not specified'}</span>
                 </div>
             </div>
+            <div class="two-factor-section">
+                <h2>Two-Factor Authentication</h2>
+                <div id="two-factor-panel">${components.loading()}</div>
+            </div>
+            <div class="sessions-section">
+                <h2>Active Sessions</h2>
+                <div id="sessions-list" class="sessions-list">${components.loading()}</div>
+                <button class="revoke-other-sessions-btn btn-secondary">Log out all other devices</button>
+            </div>
+            <div class="account-data-section">
+                <h2>Your Data</h2>
+                <p>Download everything stored about you, or delete your account. Your posts, comments and messages stay, credited to "deleted user".</p>
+                <a class="btn-secondary account-export-link" href="/api/account/export" download>Download my data</a>
+                <button class="delete-account-btn btn-danger">Delete account</button>
+            </div>
         </div>
    `;
 };
 
+// twoFactorLogin: Second login step for accounts with two-factor authentication
+components.twoFactorLogin = (pendingToken) => {
+    return `
+        <div class="auth-container">
+            <div class="login_container">
+                <h1>Verification</h1>
+                <form id="login-2fa-form" data-pending-token="${escapeHTML(pendingToken)}">
+                    <div class="error-container"></div>
+
+                    <label for="totp_code">Authenticator code</label>
+                    <input type="text" id="totp_code" name="code" inputmode="numeric" autocomplete="one-time-code" placeholder="6-digit code or recovery code">
+
+                    <input type="submit" class="login_button" value="Verify">
+                </form>
+            </div>
+        </div>
+    `;
+};
+
+// twoFactorStatus: Profile 2FA panel - enabled state or the button to start setup
+components.twoFactorStatus = (status) => {
+    if (!status.enabled) {
+        return `
+            <p>Protect your account with an authenticator app.</p>
+            <button class="totp-setup-btn btn-secondary">Set up two-factor authentication</button>
+        `;
+    }
+    return `
+        <p>Two-factor authentication is <b>enabled</b>. ${status.recovery_codes_left} recovery codes left.</p>
+        <input type="text" id="totp-disable-code" class="totp-code-input" placeholder="Code to disable">
+        <button class="totp-disable-btn btn-secondary">Disable</button>
+    `;
+};
+
+// twoFactorSetup: Secret + provisioning URI to add to an authenticator app, then confirm with a code
+components.twoFactorSetup = (setup) => {
+    return `
+        <p>Add this account to your authenticator app, then enter the code it shows.</p>
+        <div class="totp-secret">${escapeHTML(setup.secret)}</div>
+        <a class="totp-uri" href="${escapeHTML(setup.provisioning_uri)}">Open in authenticator app</a>
+        <input type="text" id="totp-enable-code" class="totp-code-input" inputmode="numeric" placeholder="6-digit code">
+        <button class="totp-enable-btn btn-secondary">Verify and enable</button>
+    `;
+};
+
+// recoveryCodes: Shown once after enabling 2FA
+components.recoveryCodes = (codes = []) => {
+    return `
+        <p>Two-factor authentication is enabled. Save these recovery codes - each works once and they will not be shown again.</p>
+        <ul class="recovery-codes">${codes.map(c => `<li>${escapeHTML(c)}</li>`).join('')}</ul>
+    `;
+};
+
+// sessionItem: One logged-in device in the profile sessions list
+components.sessionItem = (session) => {
+    const lastUsed = new Date(session.last_used_at).toLocaleString();
+    return `
+        <div class="session-item ${session.current ? 'current-session' : ''}" data-session-id="${escapeHTML(session.id)}">
+            <div class="session-info">
+                <div class="session-device">${escapeHTML(session.device_label)}${session.current ? ' <span class="session-badge">This device</span>' : ''}</div>
+                <div class="session-meta">${escapeHTML(session.ip_address)} · Last active ${escapeHTML(lastUsed)}</div>
+            </div>
+            ${session.current ? '' : `<button class="revoke-session-btn btn-secondary" data-session-id="${escapeHTML(session.id)}">Revoke</button>`}
+        </div>
+    `;
+};
+
 // userListItem: Sidebar user with last message preview
 components.userListItem = (user, current_user) => {
     if ((current_user && current_user.lastMsg === "") && (user.recipient_id !== current_user.id && user.sender_id !== current_user.id)) {
@@ -407,9 +507,19 @@ components.chatSidebar = () => {
         <div id="active-chat-container" class="chat-container">
             <div class="chat-header">
                 <h3 id="chat-with-user">??</h3>
+                <div class="chat-export">
+                    Export:
+                    <a class="chat-export-link" data-format="json" download>JSON</a>
+                    <a class="chat-export-link" data-format="html" download>HTML</a>
+                    <a class="chat-export-link" data-format="txt" download>TXT</a>
+                </div>
                 <button id="close-chat" class="close-btn">✘</button>
             </div>
             <div id="chat-messages" class="chat-messages"></div>
+            <div id="reply-banner" class="reply-banner hidden">
+                <div class="reply-banner-text"></div>
+                <button id="cancel-reply" class="close-btn" title="Cancel reply">✘</button>
+            </div>
             <div class="chat-input">
                 <textarea id="message-input" placeholder="Type your message..." maxlength="1000"></textarea>
                 <button id="send-message-btn">Send</button>
@@ -419,8 +529,11 @@ components.chatSidebar = () => {
     `;
 };
 
+// MESSAGE_REACTIONS: Emoji offered on chat messages (same list as core.AppConfig.MessageReactions)
+export const MESSAGE_REACTIONS = ["👍", "❤️", "😂", "😮", "😢", "🎉"];
+
 // chatMessage: Single chat bubble with sender and timestamp
-components.chatMessage = (message, isOwn = false) => {
+components.chatMessage = (message, isOwn = false, currentUserId = '') => {
 
     const time = new Date(parseInt(message.created_at)).toLocaleString([], {
         year: 'numeric',
@@ -431,17 +544,62 @@ components.chatMessage = (message, isOwn = false) => {
         hour12: false  // 24-hour format
     });
 
+    // Unsent messages keep their place but lose their content; own messages can be
+    // changed until editable_until (the server enforces the window either way)
+    const deleted = Boolean(message.deleted_at);
+    const canChange = isOwn && !deleted && message.editable_until > Date.now();
+
     return `
-        <div class="message ${isOwn ? 'own-message' : 'other-message'}">
+        <div class="message ${isOwn ? 'own-message' : 'other-message'}${deleted ? ' deleted-message' : ''}" data-message-id="${escapeHTML(message.message_id || '')}">
             <div class="message-header">
                 <span class="message-sender">${escapeHTML(message.sender_nickname)}</span>
-                <span class="message-time">${time}</span>
+                <span class="message-time">${time}${message.edited_at && !deleted ? ' <span class="message-edited">(edited)</span>' : ''}</span>
             </div>
-            <div class="message-content">${formatMessage(escapeHTML(message.content))}</div>
+            ${message.reply_to && !deleted ? components.messageQuote(message.reply_to) : ''}
+            <div class="message-content">${deleted ? '<p><em>Message deleted</em></p>' : formatMessage(escapeHTML(message.content))}</div>
+            ${!deleted ? `
+            <div class="message-actions">
+                <button class="message-reply-btn">Reply</button>
+                ${canChange ? `
+                <button class="message-edit-btn">Edit</button>
+                <button class="message-delete-btn">Delete</button>` : ''}
+            </div>` : ''}
+            ${deleted ? '' : components.messageReactions(message.reactions, currentUserId)}
         </div>
     `;
 };
 
+// messageQuote: Preview of the message a reply refers to; clicking it jumps to the original
+components.messageQuote = (quoted) => {
+    return `
+            <div class="message-quote" data-reply-to="${escapeHTML(quoted.message_id)}">
+                <span class="quote-sender">${escapeHTML(quoted.sender_nickname)}</span>
+                <span class="quote-content">${quoted.deleted ? '<em>Message deleted</em>' : escapeHTML(quoted.content)}</span>
+            </div>
+    `;
+};
+
+// messageReactions: Reaction chips under a chat bubble plus the picker to add one
+// Chips the current user contributed to are marked; clicking a chip toggles it
+components.messageReactions = (reactions = [], currentUserId = '') => {
+    const chips = (reactions || []).map(reaction => {
+        const mine = reaction.user_ids.includes(currentUserId);
+        return `<button class="reaction-chip${mine ? ' mine' : ''}" data-emoji="${escapeHTML(reaction.emoji)}" data-mine="${mine}">${escapeHTML(reaction.emoji)} ${reaction.count}</button>`;
+    }).join('');
+
+    return `
+            <div class="message-reactions">
+                ${chips}
+                <div class="reaction-picker">
+                    <button class="reaction-picker-toggle" title="Add reaction">☺+</button>
+                    <div class="reaction-picker-options hidden">
+                        ${MESSAGE_REACTIONS.map(emoji => `<button class="reaction-option" data-emoji="${emoji}">${emoji}</button>`).join('')}
+                    </div>
+                </div>
+            </div>
+    `;
+};
+
 // errorPopup: Temporary dismissible error message
 components.errorPopup = (message) => {
     return `
@@ -452,6 +610,18 @@ components.errorPopup = (message) => {
     `;
 };
 
+// sessionExpiringNotice: Warning with a button to extend the session
+components.sessionExpiringNotice = (secondsLeft) => {
+    const minutes = Math.max(1, Math.ceil(secondsLeft / 60));
+    return `
+        <div class="error-popout session-notice">
+            <span class="error-close">&times;</span>
+            <p class="error-message">Your session expires in about ${minutes} minute${minutes > 1 ? 's' : ''}.</p>
+            <button class="refresh-session-btn">Stay signed in</button>
+        </div>
+    `;
+};
+
 // loading: Simple spinner
 components.loading = () => {
     return `<div class="loading-spinner">Loading...</div>`;
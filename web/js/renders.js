@@ -17,12 +17,20 @@ renders.Home = async (isAuthenticated, userData = {}) => {
     const mainContent = document.getElementById('main-content');
     mainContent.innerHTML = components.loading();    
     try {
+        // Reaction registry, once per page load (the buttons fall back to like/dislike)
+        if (!renders.reactionTypesLoaded) {
+            const typesResponse = await fetch('/api/reactions');
+            if (typesResponse.ok) {
+                components.reactionTypes = await typesResponse.json();
+                renders.reactionTypesLoaded = true;
+            }
+        }
+
         // Fetch initial posts via API
         const response = await fetch('/api/posts', {
             method: 'GET',
             headers: {
                 'Content-Type': 'application/json',
-                'Session-ID': localStorage.getItem('session_id'),
                 'request-type': 'fetch-3-posts'
             }
         });
@@ -153,6 +161,39 @@ renders.Profile = (userData) => {
     mainContent.innerHTML = components.profile(userData);
 }
 
+// LoginProviders: External login buttons under the login form
+renders.LoginProviders = (providers = []) => {
+    const container = document.getElementById('sso-providers');
+    if (!container) return;
+    container.innerHTML = providers.map(p => components.ssoButton(p)).join('');
+}
+
+// TwoFactorLogin: Replaces the login form with the verification code form
+renders.TwoFactorLogin = (pendingToken) => {
+    const mainContent = document.getElementById('main-content');
+    mainContent.innerHTML = components.twoFactorLogin(pendingToken);
+}
+
+// TwoFactor: Fills the profile 2FA panel - view is 'status', 'setup' or 'recovery_codes'
+renders.TwoFactor = (view, data) => {
+    const panel = document.getElementById('two-factor-panel');
+    if (!panel) return;
+    if (view === 'setup') {
+        panel.innerHTML = components.twoFactorSetup(data);
+    } else if (view === 'recovery_codes') {
+        panel.innerHTML = components.recoveryCodes(data);
+    } else {
+        panel.innerHTML = components.twoFactorStatus(data);
+    }
+}
+
+// Sessions: Renders the active sessions list on the profile page
+renders.Sessions = (sessions = []) => {
+    const sessionsList = document.getElementById('sessions-list');
+    if (!sessionsList) return;
+    sessionsList.innerHTML = sessions.map(s => components.sessionItem(s)).join('');
+}
+
 // Error: Shows temporary error popup
 renders.Error = (message) => {
     const errorContainer = document.getElementById('error-container');
@@ -164,6 +205,14 @@ renders.Error = (message) => {
     }
 }
 
+// SessionExpiring: Shows the session expiry warning until dismissed or refreshed
+renders.SessionExpiring = (secondsLeft) => {
+    const errorContainer = document.getElementById('error-container');
+    if (errorContainer) {
+        errorContainer.innerHTML = components.sessionExpiringNotice(secondsLeft);
+    }
+}
+
 // Users: Renders online/offline users in chat sidebar
 renders.Users = (users, user) => {
     
@@ -191,10 +240,36 @@ if (!currentUser) return;
 
 }
 
+// UserPresence: Moves one user between the online and offline lists, keeping its unread dot
+renders.UserPresence = (changed, users, user) => {
+    const currentUser = users.find(u => u.id === user.user_id);
+    const targetList = document.getElementById(changed.isOnline ? 'online-users-list' : 'conversations-list');
+    if (!currentUser || !targetList || changed.id === currentUser.id) return;
+
+    const existing = document.querySelector(`.user-list-item[data-user-id="${changed.id}"]`);
+    const unread = existing && !existing.querySelector('.notification-dot')?.classList.contains('hidden');
+    existing?.remove();
+
+    const wrapper = document.createElement('div');
+    wrapper.innerHTML = components.userListItem(changed, currentUser);
+    const item = wrapper.firstElementChild;
+    if (unread) { item.querySelector('.notification-dot')?.classList.remove('hidden'); }
+    targetList.prepend(item);
+}
+
 // ChatMessage: Renders a single chat bubble
-renders.ChatMessage = (message, isOwn) => {
+renders.ChatMessage = (message, isOwn, currentUserId) => {
     message.sender_nickname = isOwn ? 'You' : message.sender_nickname;
-    return components.chatMessage(message, isOwn);
+    return components.chatMessage(message, isOwn, currentUserId);
+};
+
+// MessageReactions: Replaces the reaction row of a chat bubble after a message_reactions event
+renders.MessageReactions = (messageEl, reactions, currentUserId) => {
+    const row = messageEl.querySelector('.message-reactions');
+    if (!row) return; // unsent message
+    const wrapper = document.createElement('div');
+    wrapper.innerHTML = components.messageReactions(reactions, currentUserId);
+    row.replaceWith(wrapper.firstElementChild);
 };
 
 // StatusPage: Renders 404/403/500 page
@@ -68,14 +68,14 @@ setups.HomeEvents = (app) => {
             })();
         }
 
-        // Handle like/dislike reactions
+        // Handle post/comment reactions
         const reactionBtn = e.target.closest('.reaction-btn');
         if (reactionBtn) {
             const postId = reactionBtn.getAttribute('data-post-id') || '';
             const commentId = reactionBtn.getAttribute('data-comment-id') || '';
-            const reactionType = parseInt(reactionBtn.getAttribute('data-type'), 10);
-            if ((postId || commentId) && !postId !== !commentId && !isNaN(reactionType)) {
-                app.handleReaction({ postId, commentId, reactionType });
+            const reaction = reactionBtn.getAttribute('data-reaction');
+            if ((postId || commentId) && !postId !== !commentId && reaction) {
+                app.handleReaction({ postId, commentId, reaction });
             }
         }
     });
@@ -120,6 +120,17 @@ setups.HomeEvents = (app) => {
     });
 }
 
+// TwoFactorEvents: Binds the second login step form
+setups.TwoFactorEvents = (app) => {
+    const form = document.getElementById('login-2fa-form');
+    if (form) {
+        form.addEventListener('submit', (e) => {
+            e.preventDefault();
+            app.handleLogin2FA(form.getAttribute('data-pending-token'));
+        });
+    }
+}
+
 // AuthEvents: Binds login/register form submissions
 setups.AuthEvents = (formType, app) => {
     const form = document.getElementById(`${formType}-form`);
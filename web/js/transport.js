@@ -0,0 +1,71 @@
+// EventStreamSocket: Server-Sent Events fallback with the WebSocket interface the app uses
+// Server events arrive on GET /api/events, messages are POSTed to /api/events/send;
+// both carry exactly the same JSON as /ws, and the stream ends with the same close codes
+export class EventStreamSocket extends EventTarget {
+    static CONNECTING = 0;
+    static OPEN = 1;
+    static CLOSING = 2;
+    static CLOSED = 3;
+
+    constructor() {
+        super();
+        this.readyState = EventStreamSocket.CONNECTING;
+        this.streamId = null;
+        this.outbox = Promise.resolve(); // POSTs go out one at a time to keep message order
+        this.source = new EventSource('/api/events');
+
+        this.source.addEventListener('message', (event) => {
+            const data = JSON.parse(event.data);
+            // stream_open: The stream is ready; its ID authorizes our POSTs
+            if (data.type === 'stream_open') {
+                this.streamId = data.data.stream_id;
+                this.readyState = EventStreamSocket.OPEN;
+                this.dispatchEvent(new Event('open'));
+                return;
+            }
+            this.dispatchEvent(new MessageEvent('message', { data: event.data }));
+        });
+
+        // close: Server ended the stream with a WebSocket-style code
+        this.source.addEventListener('close', (event) => {
+            const { code, reason } = JSON.parse(event.data);
+            this.finish(code, reason);
+        });
+
+        // error: EventSource would silently reconnect; behave like a dropped WebSocket instead
+        this.source.addEventListener('error', () => {
+            if (this.readyState === EventStreamSocket.CONNECTING) {
+                this.dispatchEvent(new Event('error'));
+            }
+            this.finish(1006, '');
+        });
+    }
+
+    // send: Same contract as WebSocket.send - the reply comes back on the stream
+    send(payload) {
+        if (this.readyState !== EventStreamSocket.OPEN) {
+            throw new Error('Event stream is not open');
+        }
+        const streamId = this.streamId;
+        this.outbox = this.outbox.then(async () => {
+            const response = await fetch('/api/events/send', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-Stream-ID': streamId },
+                body: payload
+            });
+            // 404: the server no longer knows this stream (restart, other instance)
+            if (response.status === 404) this.finish(1006, 'stream gone');
+        }).catch(err => console.error('Event stream send failed:', err));
+    }
+
+    close() {
+        this.finish(1000, '');
+    }
+
+    finish(code, reason) {
+        if (this.readyState === EventStreamSocket.CLOSED) return;
+        this.readyState = EventStreamSocket.CLOSED;
+        this.source.close();
+        this.dispatchEvent(new CloseEvent('close', { code, reason }));
+    }
+}
@@ -18,6 +18,12 @@ export function formatMessage(message) {
     .join('');
 }
 
+// csrfToken: Reads the double-submit CSRF cookie to echo back in X-CSRF-Token
+export function csrfToken() {
+    const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+    return match ? decodeURIComponent(match[1]) : '';
+}
+
 // throttle: Limits function execution rate (e.g., scroll/fetch)
 export function throttle(func, limit) {
     let inThrottle;